@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/voidkey-oss/cli/internal/agent"
+	"github.com/voidkey-oss/cli/internal/credserver"
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+)
+
+// agentOptions holds the flag values of a single agent invocation
+type agentOptions struct {
+	token         string
+	idpName       string
+	socket        string
+	refreshBefore time.Duration
+}
+
+// agentCommand creates the agent command
+func agentCommand(voidkeyClient *VoidkeyClient) *cobra.Command {
+	var opts agentOptions
+
+	cmd := &cobra.Command{
+		Use:   "agent",
+		Short: "Run an agent that mints and caches credentials for other commands",
+		Long: `Run an agent in the style of ssh-agent. It holds the OIDC token, caches the
+credentials it minted and refreshes them before they expire. 'voidkey mint'
+and 'voidkey exec' get their credentials from it instead of the broker when
+VOIDKEY_AGENT_SOCK points at its socket, unless --server or --token is given.
+
+The agent listens on a unix socket that only the current user can access;
+connections from processes of other users are refused. The variable is
+printed to stdout as an export statement:
+
+  export VOIDKEY_AGENT_SOCK=/run/user/1000/voidkey/agent.sock
+
+Examples:
+  # Start the agent in the background and point this shell at it
+  voidkey agent > ~/.voidkey-agent.env &
+  sleep 1 && . ~/.voidkey-agent.env
+  voidkey mint --keys AWS_CREDENTIALS`,
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			return runAgent(voidkeyClient, cobraCmd, opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.token, "token", "", "OIDC token for authentication (default from $OIDC_TOKEN or $GITHUB_TOKEN)")
+	cmd.Flags().StringVar(&opts.idpName, "idp", "", "IdP provider name to use (uses server default if not specified)")
+	cmd.Flags().StringVar(&opts.socket, "socket", agent.DefaultSocketPath(), "Path of the unix socket to listen on; its directory must only be accessible to the current user")
+	cmd.Flags().DurationVar(&opts.refreshBefore, "refresh-before", voidkey.DefaultRefreshBefore, "How long before they expire cached credentials are refreshed")
+
+	return cmd
+}
+
+// runAgent checks the token with the broker, then serves the agent until
+// the command is cancelled
+func runAgent(client *VoidkeyClient, cmd *cobra.Command, opts agentOptions) error {
+	if opts.refreshBefore < 0 {
+		return usageErrorf("invalid --refresh-before %s (must not be negative)", opts.refreshBefore)
+	}
+
+	token, err := resolveToken(cmd, opts.token, opts.idpName)
+	if err != nil {
+		return err
+	}
+
+	// Fail early on a token the broker does not accept
+	keys, err := client.GetAvailableKeys(commandContext(cmd), token)
+	if err != nil {
+		return err
+	}
+	progressf(cmd, "🔑 Token is permitted to mint %d keys", len(keys))
+
+	logger := logFor(cmd)
+	a := agent.New(opts.idpName,
+		func(key string, duration time.Duration) (credserver.Source, error) {
+			return client.KeySource(token, key,
+				voidkey.WithIdpName(opts.idpName),
+				voidkey.WithDuration(duration),
+				voidkey.WithRefreshBefore(opts.refreshBefore))
+		},
+		func(ctx context.Context) ([]string, error) {
+			return client.GetAvailableKeys(ctx, token)
+		},
+		logger)
+
+	listener, err := agent.Listen(opts.socket, logger)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(cmd.OutOrStdout(), "export %s=%s\n", agent.SocketEnv, opts.socket)
+
+	// Serving stops on Ctrl-C, which is not a failure
+	cmd.SilenceUsage = true
+	progressf(cmd, "🚀 Agent listening on %s (Ctrl-C to stop)", opts.socket)
+	if err := a.Serve(commandContext(cmd), listener); err != nil {
+		return fmt.Errorf("agent failed: %w", err)
+	}
+	progressf(cmd, "👋 Agent stopped")
+	return nil
+}
@@ -0,0 +1,124 @@
+//go:build linux || darwin
+
+package cmd
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/voidkey-oss/cli/internal/agent"
+	"github.com/voidkey-oss/cli/internal/credserver"
+	"github.com/voidkey-oss/cli/pkg/voidkey/voidkeytest"
+)
+
+// startAgent runs an agent for the broker of client on a temporary socket
+// until the test ends, and returns the socket's path
+func startAgent(t *testing.T, client *VoidkeyClient) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "voidkey-agent")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "agent.sock")
+
+	a := agent.New("", func(key string, duration time.Duration) (credserver.Source, error) {
+		return client.KeySource(voidkeytest.Token, key)
+	}, func(ctx context.Context) ([]string, error) {
+		return client.GetAvailableKeys(ctx, voidkeytest.Token)
+	}, discardLogger())
+	listener, err := agent.Listen(socket, discardLogger())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- a.Serve(ctx, listener) }()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+	})
+	return socket
+}
+
+func TestVoidkeyClient_UseAgent(t *testing.T) {
+	broker, brokerClient := newServeBroker(t)
+	socket := startAgent(t, brokerClient)
+
+	client := NewVoidkeyClient(nil, "https://broker.example.com")
+	client.UseAgent(socket, 5*time.Second)
+	assert.True(t, client.UsesAgent())
+	cmd, _, _ := SetupTestCommand()
+	token, err := resolveClientToken(client, cmd, "", "")
+	require.NoError(t, err)
+	assert.Empty(t, token)
+
+	for i := 0; i < 2; i++ {
+		responses, err := client.MintKeys(context.Background(), token, "", []string{"AWS_CREDENTIALS"}, 0, false)
+
+		require.NoError(t, err)
+		assert.Equal(t, "AKIA-1", responses["AWS_CREDENTIALS"].Credentials["AWS_ACCESS_KEY_ID"])
+	}
+	assert.Equal(t, 1, broker.Mints("AWS_CREDENTIALS"))
+
+	_, err = client.MintKeys(context.Background(), token, "", []string{"UNKNOWN", "AWS_CREDENTIALS"}, 0, false)
+	require.NoError(t, err)
+
+	_, err = client.MintKeys(context.Background(), token, "other-idp", []string{"AWS_CREDENTIALS"}, 0, false)
+	assert.ErrorIs(t, err, ErrRequestRejected)
+}
+
+func TestVoidkeyClient_UseAgentTimeout(t *testing.T) {
+	dir, err := os.MkdirTemp("", "voidkey-agent")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socket := filepath.Join(dir, "agent.sock")
+
+	// An agent that never answers
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})}
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	client := NewVoidkeyClient(nil, "https://broker.example.com")
+	client.UseAgent(socket, 50*time.Millisecond)
+
+	start := time.Now()
+	_, err = client.MintKeys(context.Background(), "", "", []string{"AWS_CREDENTIALS"}, 0, false)
+
+	assert.ErrorIs(t, err, ErrBrokerUnavailable)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestAgentSocket(t *testing.T) {
+	newCommand := func(annotated bool) *cobra.Command {
+		cmd := &cobra.Command{Use: "mint"}
+		if annotated {
+			cmd.Annotations = map[string]string{agentAnnotation: "true"}
+		}
+		cmd.Flags().String("token", "", "")
+		cmd.Flags().String("server", "", "")
+		return cmd
+	}
+	t.Setenv(agent.SocketEnv, "/run/user/1000/voidkey/agent.sock")
+
+	assert.Equal(t, "/run/user/1000/voidkey/agent.sock", agentSocket(newCommand(true)))
+	assert.Empty(t, agentSocket(newCommand(false)))
+
+	for _, flag := range []string{"token", "server"} {
+		cmd := newCommand(true)
+		require.NoError(t, cmd.Flags().Set(flag, "value"))
+		assert.Empty(t, agentSocket(cmd), flag)
+	}
+
+	t.Setenv(agent.SocketEnv, "")
+	assert.Empty(t, agentSocket(newCommand(true)))
+}
@@ -1,23 +1,54 @@
 package cmd
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/voidkey-oss/cli/internal/agent"
+	"github.com/voidkey-oss/cli/pkg/voidkey"
 )
 
-// HTTPClient interface for dependency injection and testing
-type HTTPClient interface {
-	Post(url, contentType string, body io.Reader) (*http.Response, error)
-	Get(url string) (*http.Response, error)
-}
+// Types of the broker API, defined by package voidkey
+type (
+	HTTPClient            = voidkey.HTTPClient
+	IdpProvider           = voidkey.IdpProvider
+	KeyCredentialResponse = voidkey.KeyCredentialResponse
+	KeyError              = voidkey.KeyError
+	Capabilities          = voidkey.Capabilities
+	RetryPolicy           = voidkey.RetryPolicy
+)
 
-// VoidkeyClient handles communication with the Voidkey broker server
+// VoidkeyClient is the broker client shared by the commands. The commands
+// are created before flags and the config file are parsed, so it holds the
+// settings until then and builds a voidkey.Client from them on first use.
+// On top of the SDK it registers tokens and credentials with the redactor,
+// adds hints to broker errors and warns about clock skew.
 type VoidkeyClient struct {
-	client    HTTPClient
-	serverURL string
+	mu                sync.Mutex
+	client            HTTPClient
+	serverURL         string
+	logger            *slog.Logger
+	retry             RetryPolicy
+	discover          bool
+	discoveryCacheDir string
+	// credentialCacheDir is where credential helpers cache credentials
+	// between invocations, empty to mint on every use
+	credentialCacheDir string
+	// agent is set when requests go to the agent instead of the broker
+	agent bool
+
+	// api is built from the settings above, and reset when they change
+	api *voidkey.Client
+	// clockWarned is set once the clock skew warning was shown
+	clockWarned bool
 }
 
 // NewVoidkeyClient creates a new client with the given HTTP client and server URL
@@ -25,131 +56,251 @@ func NewVoidkeyClient(client HTTPClient, serverURL string) *VoidkeyClient {
 	return &VoidkeyClient{
 		client:    client,
 		serverURL: serverURL,
+		logger:    discardLogger(),
+		retry:     voidkey.NoRetry,
 	}
 }
 
+// update changes the client's settings, so the SDK client is rebuilt
+func (c *VoidkeyClient) update(set func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	set()
+	c.api = nil
+}
 
-// IdpProvider represents an identity provider
-type IdpProvider struct {
-	Name      string `json:"name"`
-	IsDefault bool   `json:"isDefault"`
+// SetLogger sets the logger used for request diagnostics
+func (c *VoidkeyClient) SetLogger(logger *slog.Logger) {
+	c.update(func() { c.logger = logger })
 }
 
-// ListIdpProviders calls the broker server to list available IdP providers
-func (c *VoidkeyClient) ListIdpProviders() ([]IdpProvider, error) {
-	// Make HTTP request
-	url := fmt.Sprintf("%s/credentials/idp-providers", c.serverURL)
-	resp, err := c.client.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to broker server at %s: %w", c.serverURL, err)
-	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
+// SetHTTPClient replaces the HTTP client used for broker requests
+func (c *VoidkeyClient) SetHTTPClient(client HTTPClient) {
+	c.update(func() { c.client = client })
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
+// SetRetryPolicy sets how failed requests are retried. Clients start
+// without retries.
+func (c *VoidkeyClient) SetRetryPolicy(policy RetryPolicy) {
+	c.update(func() { c.retry = policy })
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("server returned error %d: %s", resp.StatusCode, string(body))
-	}
+// SetServerURL changes the broker server URL
+func (c *VoidkeyClient) SetServerURL(serverURL string) {
+	c.update(func() { c.serverURL = serverURL })
+}
+
+// EnableDiscovery makes the client discover the broker's capabilities, see
+// voidkey.WithDiscovery. Clients start without discovery.
+func (c *VoidkeyClient) EnableDiscovery(cacheDir string) {
+	c.update(func() {
+		c.discover = true
+		c.discoveryCacheDir = cacheDir
+	})
+}
 
-	// Parse response
-	var providers []IdpProvider
-	if err := json.Unmarshal(body, &providers); err != nil {
-		return nil, fmt.Errorf("failed to parse providers response: %w", err)
+// EnableCredentialCache makes credential helpers cache the credentials
+// they mint in dir, see credentialCacheFile. Clients start without a cache.
+func (c *VoidkeyClient) EnableCredentialCache(dir string) {
+	c.update(func() { c.credentialCacheDir = dir })
+}
+
+// credentialCacheFile returns the file caching the credentials of key
+// minted with token, or an empty string without a cache. The name is a
+// hash of the server URL, token and key, so cached credentials are only
+// handed to callers presenting the same token.
+func (c *VoidkeyClient) credentialCacheFile(token, key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.credentialCacheDir == "" || c.agent {
+		return ""
 	}
+	sum := sha256.Sum256([]byte(c.serverURL + "\x00" + token + "\x00" + key))
+	return filepath.Join(c.credentialCacheDir, hex.EncodeToString(sum[:])+".json")
+}
 
-	return providers, nil
+// UseAgent sends requests to the agent listening on socket instead of the
+// broker, each bounded by timeout. The agent authenticates with its own
+// token.
+func (c *VoidkeyClient) UseAgent(socket string, timeout time.Duration) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	voidkey.DialUnixSocket(transport, socket)
+
+	c.update(func() {
+		c.serverURL = "unix://" + socket
+		c.client = &http.Client{Timeout: timeout, Transport: transport, CheckRedirect: voidkey.CheckRedirect}
+		c.retry = voidkey.NoRetry
+		c.discover = false
+		c.agent = true
+	})
 }
 
-// Key-based methods
+// UsesAgent reports whether requests go to the agent, see UseAgent
+func (c *VoidkeyClient) UsesAgent() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.agent
+}
 
-// MintKeys calls the broker server to mint specific keys using the new API
-func (c *VoidkeyClient) MintKeys(oidcToken string, idpName string, keys []string, duration int, all bool) (map[string]KeyCredentialResponse, error) {
-	// Prepare request
-	reqBody := struct {
-		OidcToken string   `json:"oidcToken"`
-		IdpName   string   `json:"idpName,omitempty"`
-		Keys      []string `json:"keys,omitempty"`
-		Duration  int      `json:"duration,omitempty"`
-		All       bool     `json:"all,omitempty"`
-	}{
-		OidcToken: oidcToken,
-		IdpName:   idpName,
-		Keys:      keys,
-		Duration:  duration,
-		All:       all,
+// sdk returns the SDK client for the current settings, building it on
+// first use. An invalid server URL is a usage error.
+func (c *VoidkeyClient) sdk() (*voidkey.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.api != nil {
+		return c.api, nil
 	}
 
-	jsonData, err := json.Marshal(reqBody)
+	api, err := c.newSDK()
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+		return nil, err
 	}
+	c.api = api
+	return api, nil
+}
 
-	// Make HTTP request to mint endpoint
-	url := fmt.Sprintf("%s/credentials/mint", c.serverURL)
-	resp, err := c.client.Post(url, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to broker server at %s: %w", c.serverURL, err)
+// newSDK builds an SDK client from the current settings and opts. The
+// caller holds c.mu.
+func (c *VoidkeyClient) newSDK(opts ...voidkey.Option) (*voidkey.Client, error) {
+	if _, err := parseServerURL(c.serverURL); err != nil {
+		return nil, err
+	}
+	base := []voidkey.Option{
+		voidkey.WithBaseURL(c.serverURL),
+		voidkey.WithUserAgent(userAgent()),
+		voidkey.WithRetryPolicy(c.retry),
+		voidkey.WithLogger(c.logger),
+	}
+	if c.client != nil {
+		base = append(base, voidkey.WithHTTPClient(c.client))
 	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
+	if c.discover {
+		base = append(base, voidkey.WithDiscovery(c.discoveryCacheDir))
+	}
+	opts = append(base, opts...)
+	if c.agent {
+		opts = append(opts, voidkey.WithTokenSource(voidkey.StaticToken(agent.ClientToken)))
+	}
+	return voidkey.New(opts...)
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+// parseServerURL parses and validates a broker server URL, see
+// voidkey.ParseServerURL. Invalid URLs are usage errors.
+func parseServerURL(serverURL string) (*url.URL, error) {
+	u, err := voidkey.ParseServerURL(serverURL)
+	var urlErr *voidkey.ServerURLError
+	if errors.As(err, &urlErr) {
+		return nil, usageErrorf("invalid --server %q: %s", urlErr.URL, urlErr.Reason)
 	}
+	return u, err
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("server returned error %d: %s", resp.StatusCode, string(body))
+// ListIdpProviders calls the broker server to list available IdP providers
+func (c *VoidkeyClient) ListIdpProviders(ctx context.Context) ([]IdpProvider, error) {
+	api, err := c.sdk()
+	if err != nil {
+		return nil, err
 	}
+	providers, err := api.ListIdpProviders(ctx)
+	c.warnClockSkew(api)
+	return providers, withHint(err)
+}
+
+// MintKeys calls the broker server to mint specific keys. duration is in
+// seconds.
+func (c *VoidkeyClient) MintKeys(ctx context.Context, oidcToken string, idpName string, keys []string, duration int, all bool) (map[string]KeyCredentialResponse, error) {
+	redactor.Add(oidcToken)
 
-	// Parse response
-	var keyResponses map[string]KeyCredentialResponse
-	if err := json.Unmarshal(body, &keyResponses); err != nil {
-		return nil, fmt.Errorf("failed to parse key responses: %w", err)
+	api, err := c.sdk()
+	if err != nil {
+		return nil, err
+	}
+	keyResponses, err := api.MintKeys(ctx, voidkey.MintRequest{
+		Token:    oidcToken,
+		IdpName:  idpName,
+		Keys:     keys,
+		Duration: time.Duration(duration) * time.Second,
+		All:      all,
+	})
+	c.warnClockSkew(api)
+	if err != nil {
+		return nil, withHint(err)
 	}
+	redactor.AddCredentials(keyResponses)
 
 	return keyResponses, nil
 }
 
 // GetAvailableKeys calls the broker server to get available keys for an identity
-func (c *VoidkeyClient) GetAvailableKeys(token string) ([]string, error) {
-	// Make HTTP request
-	url := fmt.Sprintf("%s/credentials/keys?token=%s", c.serverURL, token)
-	resp, err := c.client.Get(url)
+func (c *VoidkeyClient) GetAvailableKeys(ctx context.Context, token string) ([]string, error) {
+	redactor.Add(token)
+
+	api, err := c.sdk()
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to broker server at %s: %w", c.serverURL, err)
+		return nil, err
 	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
+	keys, err := api.ListKeys(ctx, token)
+	c.warnClockSkew(api)
+	return keys, withHint(err)
+}
 
-	body, err := io.ReadAll(resp.Body)
+// Capabilities returns the broker's capabilities, see voidkey.Client.Capabilities
+func (c *VoidkeyClient) Capabilities(ctx context.Context) (*Capabilities, error) {
+	api, err := c.sdk()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
+		return nil, err
 	}
+	capabilities, err := api.Capabilities(ctx)
+	c.warnClockSkew(api)
+	return capabilities, err
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("server returned error %d: %s", resp.StatusCode, string(body))
+// ProbeCapabilities discovers the broker's capabilities with a request to
+// the broker, bypassing the discovery caches in memory and on disk, for
+// checks that must reach it. Legacy brokers answer with their assumed
+// capabilities.
+func (c *VoidkeyClient) ProbeCapabilities(ctx context.Context) (*Capabilities, error) {
+	c.mu.Lock()
+	api, err := c.newSDK(voidkey.WithDiscovery(""))
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
 	}
+	capabilities, err := api.Capabilities(ctx)
+	c.warnClockSkew(api)
+	return capabilities, err
+}
 
-	// Parse response
-	var keys []string
-	if err := json.Unmarshal(body, &keys); err != nil {
-		return nil, fmt.Errorf("failed to parse keys response: %w", err)
-	}
+// KeySource mints a single key and keeps its credentials fresh for the
+// long-running commands, see voidkey.KeySource. On top of it, minted
+// credentials are registered with the redactor, broker errors get hints
+// and clock skew is warned about.
+type KeySource struct {
+	*voidkey.KeySource
+	client *VoidkeyClient
+	api    *voidkey.Client
+}
+
+// KeySource returns a KeySource for key that authenticates with token
+func (c *VoidkeyClient) KeySource(token, key string, opts ...voidkey.KeySourceOption) (*KeySource, error) {
+	redactor.Add(token)
 
-	return keys, nil
+	c.mu.Lock()
+	api, err := c.newSDK(voidkey.WithTokenSource(voidkey.StaticToken(token)))
+	c.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return &KeySource{KeySource: voidkey.NewKeySource(api, key, opts...), client: c, api: api}, nil
 }
 
-// KeyCredentialResponse represents a single key's credential response
-type KeyCredentialResponse struct {
-	Credentials map[string]string `json:"credentials"`
-	ExpiresAt   string            `json:"expiresAt"`
-	Metadata    map[string]any    `json:"metadata,omitempty"`
+// Credentials returns the key's credentials, see voidkey.KeySource.Credentials
+func (s *KeySource) Credentials(ctx context.Context) (KeyCredentialResponse, error) {
+	response, err := s.KeySource.Credentials(ctx)
+	s.client.warnClockSkew(s.api)
+	if err != nil {
+		return response, withHint(err)
+	}
+	redactor.AddCredentials(map[string]KeyCredentialResponse{s.Key(): response})
+	return response, nil
 }
@@ -1,7 +1,7 @@
 package cmd
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -17,13 +17,8 @@ type MockHTTPClient struct {
 	mock.Mock
 }
 
-func (m *MockHTTPClient) Post(url, contentType string, body io.Reader) (*http.Response, error) {
-	args := m.Called(url, contentType, body)
-	return args.Get(0).(*http.Response), args.Error(1)
-}
-
-func (m *MockHTTPClient) Get(url string) (*http.Response, error) {
-	args := m.Called(url)
+func (m *MockHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	args := m.Called(req)
 	return args.Get(0).(*http.Response), args.Error(1)
 }
 
@@ -38,299 +33,137 @@ func TestNewVoidkeyClient(t *testing.T) {
 	assert.Equal(t, serverURL, client.serverURL)
 }
 
-
-func TestVoidkeyClient_ListIdpProviders_Success(t *testing.T) {
+func TestVoidkeyClient_ListIdpProviders(t *testing.T) {
 	mockClient := &MockHTTPClient{}
 	client := NewVoidkeyClient(mockClient, "http://localhost:3000")
+	MockSuccessfulListResponse(mockClient, "http://localhost:3000", CreateTestIdpProviders())
 
-	expectedProviders := []IdpProvider{
-		{Name: "auth0", IsDefault: true},
-		{Name: "github", IsDefault: false},
-		{Name: "hello-world", IsDefault: false},
-	}
-
-	responseBody, _ := json.Marshal(expectedProviders)
-	resp := &http.Response{
-		StatusCode: http.StatusOK,
-		Body:       io.NopCloser(bytes.NewReader(responseBody)),
-	}
-
-	mockClient.On("Get", "http://localhost:3000/credentials/idp-providers").Return(resp, nil)
-
-	providers, err := client.ListIdpProviders()
+	providers, err := client.ListIdpProviders(context.Background())
 
 	assert.NoError(t, err)
-	assert.NotNil(t, providers)
-	assert.Len(t, providers, 3)
-	assert.Equal(t, expectedProviders[0].Name, providers[0].Name)
-	assert.Equal(t, expectedProviders[0].IsDefault, providers[0].IsDefault)
-
+	assert.Equal(t, CreateTestIdpProviders(), providers)
 	mockClient.AssertExpectations(t)
 }
 
-func TestVoidkeyClient_ListIdpProviders_EmptyResponse(t *testing.T) {
+func TestVoidkeyClient_MintKeys(t *testing.T) {
 	mockClient := &MockHTTPClient{}
 	client := NewVoidkeyClient(mockClient, "http://localhost:3000")
 
-	responseBody, _ := json.Marshal([]IdpProvider{})
-	resp := &http.Response{
-		StatusCode: http.StatusOK,
-		Body:       io.NopCloser(bytes.NewReader(responseBody)),
-	}
-
-	mockClient.On("Get", "http://localhost:3000/credentials/idp-providers").Return(resp, nil)
+	var body map[string]any
+	mockClient.On("Do", requestMatching(http.MethodPost, "http://localhost:3000/credentials/mint")).Run(func(args mock.Arguments) {
+		data, _ := io.ReadAll(args.Get(0).(*http.Request).Body)
+		_ = json.Unmarshal(data, &body)
+	}).Return(CreateMockHTTPResponse(http.StatusOK, CreateTestKeyCredentials()), nil)
 
-	providers, err := client.ListIdpProviders()
+	keyResponses, err := client.MintKeys(context.Background(), "test-token", "test-idp", []string{"MINIO_CREDENTIALS"}, 1800, false)
 
 	assert.NoError(t, err)
-	assert.NotNil(t, providers)
-	assert.Len(t, providers, 0)
-
-	mockClient.AssertExpectations(t)
-}
-
-func TestVoidkeyClient_ListIdpProviders_ServerError(t *testing.T) {
-	mockClient := &MockHTTPClient{}
-	client := NewVoidkeyClient(mockClient, "http://localhost:3000")
-
-	resp := &http.Response{
-		StatusCode: http.StatusInternalServerError,
-		Body:       io.NopCloser(strings.NewReader("Internal server error")),
-	}
-
-	mockClient.On("Get", "http://localhost:3000/credentials/idp-providers").Return(resp, nil)
-
-	providers, err := client.ListIdpProviders()
-
-	assert.Error(t, err)
-	assert.Nil(t, providers)
-	assert.Contains(t, err.Error(), "server returned error 500")
-
-	mockClient.AssertExpectations(t)
-}
-
-func TestVoidkeyClient_ListIdpProviders_InvalidJSON(t *testing.T) {
-	mockClient := &MockHTTPClient{}
-	client := NewVoidkeyClient(mockClient, "http://localhost:3000")
-
-	resp := &http.Response{
-		StatusCode: http.StatusOK,
-		Body:       io.NopCloser(strings.NewReader("invalid json")),
-	}
-
-	mockClient.On("Get", "http://localhost:3000/credentials/idp-providers").Return(resp, nil)
+	assert.Equal(t, CreateTestKeyCredentials(), keyResponses)
+	assert.Equal(t, map[string]any{"oidcToken": "test-token", "idpName": "test-idp", "keys": []any{"MINIO_CREDENTIALS"}, "duration": float64(1800)}, body)
 
-	providers, err := client.ListIdpProviders()
-
-	assert.Error(t, err)
-	assert.Nil(t, providers)
-	assert.Contains(t, err.Error(), "failed to parse providers response")
+	// Minted credentials are redacted from all further output
+	secret := keyResponses["MINIO_CREDENTIALS"].Credentials["MINIO_SECRET_ACCESS_KEY"]
+	assert.Equal(t, redactedPlaceholder, redactor.Redact(secret))
 
 	mockClient.AssertExpectations(t)
 }
 
-// New key-based client method tests
-
-func TestVoidkeyClient_MintKeys_Success(t *testing.T) {
+func TestVoidkeyClient_GetAvailableKeys(t *testing.T) {
 	mockClient := &MockHTTPClient{}
 	client := NewVoidkeyClient(mockClient, "http://localhost:3000")
+	expectedKeys := []string{"MINIO_CREDENTIALS", "AWS_CREDENTIALS"}
+	mockClient.On("Do", requestMatching(http.MethodGet, "http://localhost:3000/credentials/keys?token=test-token")).Return(CreateMockHTTPResponse(http.StatusOK, expectedKeys), nil)
 
-	expectedKeyResponses := map[string]KeyCredentialResponse{
-		"MINIO_CREDENTIALS": {
-			Credentials: map[string]string{
-				"MINIO_ACCESS_KEY_ID":     "AKIAMINIO123",
-				"MINIO_SECRET_ACCESS_KEY": "miniosecret123",
-				"MINIO_ENDPOINT":          "http://localhost:9000",
-			},
-			ExpiresAt: "2025-01-01T12:00:00Z",
-			Metadata:  map[string]any{"provider": "minio-test", "keyName": "MINIO_CREDENTIALS"},
-		},
-		"AWS_CREDENTIALS": {
-			Credentials: map[string]string{
-				"AWS_ACCESS_KEY_ID":     "AKIAAWS123",
-				"AWS_SECRET_ACCESS_KEY": "awssecret123",
-			},
-			ExpiresAt: "2025-01-01T12:00:00Z",
-			Metadata:  map[string]any{"provider": "aws-test", "keyName": "AWS_CREDENTIALS"},
-		},
-	}
-
-	responseBody, _ := json.Marshal(expectedKeyResponses)
-	resp := &http.Response{
-		StatusCode: http.StatusOK,
-		Body:       io.NopCloser(bytes.NewReader(responseBody)),
-	}
-
-	mockClient.On("Post", "http://localhost:3000/credentials/mint", "application/json", mock.Anything).Return(resp, nil)
-
-	keys := []string{"MINIO_CREDENTIALS", "AWS_CREDENTIALS"}
-	keyResponses, err := client.MintKeys("test-token", "test-idp", keys, 1800, false)
-
-	assert.NoError(t, err)
-	assert.NotNil(t, keyResponses)
-	assert.Len(t, keyResponses, 2)
-	assert.Contains(t, keyResponses, "MINIO_CREDENTIALS")
-	assert.Contains(t, keyResponses, "AWS_CREDENTIALS")
-	assert.Equal(t, "AKIAMINIO123", keyResponses["MINIO_CREDENTIALS"].Credentials["MINIO_ACCESS_KEY_ID"])
-	assert.Equal(t, "AKIAAWS123", keyResponses["AWS_CREDENTIALS"].Credentials["AWS_ACCESS_KEY_ID"])
-
-	mockClient.AssertExpectations(t)
-}
-
-func TestVoidkeyClient_MintKeys_AllFlag(t *testing.T) {
-	mockClient := &MockHTTPClient{}
-	client := NewVoidkeyClient(mockClient, "http://localhost:3000")
-
-	expectedKeyResponses := map[string]KeyCredentialResponse{
-		"MINIO_CREDENTIALS": {
-			Credentials: map[string]string{
-				"MINIO_ACCESS_KEY_ID": "AKIAMINIO123",
-			},
-			ExpiresAt: "2025-01-01T12:00:00Z",
-		},
-	}
-
-	responseBody, _ := json.Marshal(expectedKeyResponses)
-	resp := &http.Response{
-		StatusCode: http.StatusOK,
-		Body:       io.NopCloser(bytes.NewReader(responseBody)),
-	}
-
-	mockClient.On("Post", "http://localhost:3000/credentials/mint", "application/json", mock.Anything).Return(resp, nil)
-
-	keyResponses, err := client.MintKeys("test-token", "test-idp", nil, 0, true)
+	keys, err := client.GetAvailableKeys(context.Background(), "test-token")
 
 	assert.NoError(t, err)
-	assert.NotNil(t, keyResponses)
-	assert.Len(t, keyResponses, 1)
-	assert.Contains(t, keyResponses, "MINIO_CREDENTIALS")
-
-	mockClient.AssertExpectations(t)
-}
-
-func TestVoidkeyClient_MintKeys_ServerError(t *testing.T) {
-	mockClient := &MockHTTPClient{}
-	client := NewVoidkeyClient(mockClient, "http://localhost:3000")
-
-	resp := &http.Response{
-		StatusCode: http.StatusInternalServerError,
-		Body:       io.NopCloser(strings.NewReader("Key not found")),
-	}
-
-	mockClient.On("Post", "http://localhost:3000/credentials/mint", "application/json", mock.Anything).Return(resp, nil)
-
-	keys := []string{"INVALID_KEY"}
-	keyResponses, err := client.MintKeys("test-token", "test-idp", keys, 0, false)
-
-	assert.Error(t, err)
-	assert.Nil(t, keyResponses)
-	assert.Contains(t, err.Error(), "server returned error 500")
-
+	assert.Equal(t, expectedKeys, keys)
 	mockClient.AssertExpectations(t)
 }
 
-func TestVoidkeyClient_MintKeys_InvalidJSON(t *testing.T) {
+func TestVoidkeyClient_ServerErrorHint(t *testing.T) {
 	mockClient := &MockHTTPClient{}
 	client := NewVoidkeyClient(mockClient, "http://localhost:3000")
+	MockErrorResponse(mockClient, "POST", "http://localhost:3000/credentials/mint", http.StatusForbidden,
+		`{"error":"forbidden","message":"keys denied","details":{"AWS_PROD":"not allowed"}}`)
 
-	resp := &http.Response{
-		StatusCode: http.StatusOK,
-		Body:       io.NopCloser(strings.NewReader("invalid json")),
-	}
-
-	mockClient.On("Post", "http://localhost:3000/credentials/mint", "application/json", mock.Anything).Return(resp, nil)
+	keyResponses, err := client.MintKeys(context.Background(), "test-token", "", []string{"AWS_PROD"}, 0, false)
 
-	keys := []string{"MINIO_CREDENTIALS"}
-	keyResponses, err := client.MintKeys("test-token", "test-idp", keys, 0, false)
-
-	assert.Error(t, err)
 	assert.Nil(t, keyResponses)
-	assert.Contains(t, err.Error(), "failed to parse key responses")
-
-	mockClient.AssertExpectations(t)
+	assert.ErrorIs(t, err, ErrForbiddenKey)
+	assert.Equal(t, "server returned error 403: keys denied\n  - AWS_PROD: not allowed\nHint: "+hintListKeys, err.Error())
 }
 
-func TestVoidkeyClient_GetAvailableKeys_Success(t *testing.T) {
+func TestVoidkeyClient_RequestHeaders(t *testing.T) {
 	mockClient := &MockHTTPClient{}
 	client := NewVoidkeyClient(mockClient, "http://localhost:3000")
 
-	expectedKeys := []string{"MINIO_CREDENTIALS", "AWS_CREDENTIALS", "GCP_CREDENTIALS"}
-
-	responseBody, _ := json.Marshal(expectedKeys)
-	resp := &http.Response{
-		StatusCode: http.StatusOK,
-		Body:       io.NopCloser(bytes.NewReader(responseBody)),
-	}
-
-	mockClient.On("Get", "http://localhost:3000/credentials/keys?token=test-token").Return(resp, nil)
+	resp := CreateMockHTTPResponse(http.StatusOK, CreateTestKeyCredentials())
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return req.Header.Get("Content-Type") == "application/json" &&
+			req.Header.Get("Accept") == "application/json" &&
+			strings.HasPrefix(req.Header.Get("User-Agent"), "voidkey-cli/")
+	})).Return(resp, nil)
 
-	keys, err := client.GetAvailableKeys("test-token")
+	_, err := client.MintKeys(context.Background(), "test-token", "", []string{"MINIO_CREDENTIALS"}, 0, false)
 
 	assert.NoError(t, err)
-	assert.NotNil(t, keys)
-	assert.Len(t, keys, 3)
-	assert.Equal(t, expectedKeys, keys)
-
 	mockClient.AssertExpectations(t)
 }
 
-func TestVoidkeyClient_GetAvailableKeys_EmptyResponse(t *testing.T) {
-	mockClient := &MockHTTPClient{}
-	client := NewVoidkeyClient(mockClient, "http://localhost:3000")
-
-	responseBody, _ := json.Marshal([]string{})
-	resp := &http.Response{
-		StatusCode: http.StatusOK,
-		Body:       io.NopCloser(bytes.NewReader(responseBody)),
-	}
-
-	mockClient.On("Get", "http://localhost:3000/credentials/keys?token=test-token").Return(resp, nil)
-
-	keys, err := client.GetAvailableKeys("test-token")
+func TestVoidkeyClient_SettingsApplyToNextRequest(t *testing.T) {
+	first := &MockHTTPClient{}
+	second := &MockHTTPClient{}
+	client := NewVoidkeyClient(first, "http://localhost:3000")
+	MockSuccessfulListResponse(first, "http://localhost:3000", CreateTestIdpProviders())
+	MockSuccessfulListResponse(second, "https://broker.example.com", CreateTestIdpProviders())
 
+	_, err := client.ListIdpProviders(context.Background())
 	assert.NoError(t, err)
-	assert.NotNil(t, keys)
-	assert.Len(t, keys, 0)
-
-	mockClient.AssertExpectations(t)
-}
 
-func TestVoidkeyClient_GetAvailableKeys_ServerError(t *testing.T) {
-	mockClient := &MockHTTPClient{}
-	client := NewVoidkeyClient(mockClient, "http://localhost:3000")
+	client.SetHTTPClient(second)
+	client.SetServerURL("https://broker.example.com")
+	_, err = client.ListIdpProviders(context.Background())
+	assert.NoError(t, err)
 
-	resp := &http.Response{
-		StatusCode: http.StatusInternalServerError,
-		Body:       io.NopCloser(strings.NewReader("Invalid token")),
+	first.AssertExpectations(t)
+	second.AssertExpectations(t)
+}
+
+func TestParseServerURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		server   string
+		expected string
+	}{
+		{name: "http", server: "http://localhost:3000"},
+		{name: "unix socket", server: "unix:///var/run/voidkey.sock"},
+		{name: "missing scheme", server: "broker.example.com", expected: `invalid --server "broker.example.com": missing scheme`},
+		{name: "query", server: "https://broker.example.com/?token=x", expected: "must not contain a query"},
+		{name: "unparsable", server: "https://broker example.com", expected: "invalid --server"},
 	}
 
-	mockClient.On("Get", "http://localhost:3000/credentials/keys?token=invalid-token").Return(resp, nil)
-
-	keys, err := client.GetAvailableKeys("invalid-token")
-
-	assert.Error(t, err)
-	assert.Nil(t, keys)
-	assert.Contains(t, err.Error(), "server returned error 500")
-
-	mockClient.AssertExpectations(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseServerURL(tt.server)
+
+			if tt.expected == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrUsage)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
 }
 
-func TestVoidkeyClient_GetAvailableKeys_InvalidJSON(t *testing.T) {
+func TestVoidkeyClient_InvalidServerURL(t *testing.T) {
 	mockClient := &MockHTTPClient{}
-	client := NewVoidkeyClient(mockClient, "http://localhost:3000")
-
-	resp := &http.Response{
-		StatusCode: http.StatusOK,
-		Body:       io.NopCloser(strings.NewReader("invalid json")),
-	}
+	client := NewVoidkeyClient(mockClient, "localhost:3000")
 
-	mockClient.On("Get", "http://localhost:3000/credentials/keys?token=test-token").Return(resp, nil)
+	_, err := client.ListIdpProviders(context.Background())
 
-	keys, err := client.GetAvailableKeys("test-token")
-
-	assert.Error(t, err)
-	assert.Nil(t, keys)
-	assert.Contains(t, err.Error(), "failed to parse keys response")
-
-	mockClient.AssertExpectations(t)
+	assert.ErrorIs(t, err, ErrUsage)
+	assert.Contains(t, err.Error(), "invalid --server")
+	mockClient.AssertNotCalled(t, "Do", mock.Anything)
 }
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+)
+
+// clockSkewThreshold is the clock skew against the broker above which a
+// warning is shown
+const clockSkewThreshold = 30 * time.Second
+
+// warnClockSkew warns the first time the clock skew measured by api exceeds
+// clockSkewThreshold
+func (c *VoidkeyClient) warnClockSkew(api *voidkey.Client) {
+	skew, ok := api.ClockSkew()
+	if !ok || skew.Abs() <= clockSkewThreshold {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.clockWarned {
+		return
+	}
+	c.clockWarned = true
+	c.logger.Warn("⚠️ Local clock is " + describeSkew(skew) + "; expiry times are adjusted to the broker's clock, but tokens and credentials may be rejected. Sync the clock (e.g. with NTP).")
+}
+
+// ClockSkew returns how far the broker's clock is ahead of the local one,
+// see voidkey.Client.ClockSkew. ok is false if no skew was measured yet.
+func (c *VoidkeyClient) ClockSkew() (skew time.Duration, ok bool) {
+	api := c.currentSDK()
+	if api == nil {
+		return 0, false
+	}
+	return api.ClockSkew()
+}
+
+// Now returns the current time by the broker's clock, see
+// voidkey.Client.Now. Before the client was used it is the local time.
+func (c *VoidkeyClient) Now() time.Time {
+	api := c.currentSDK()
+	if api == nil {
+		return time.Now()
+	}
+	return api.Now()
+}
+
+// currentSDK returns the SDK client if it was built already
+func (c *VoidkeyClient) currentSDK() *voidkey.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.api
+}
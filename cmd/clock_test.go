@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newSkewedBroker starts a broker whose clock is skew ahead of the local one
+// and that answers every request with body
+func newSkewedBroker(t *testing.T, skew time.Duration, body string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(skew).UTC().Format(http.TimeFormat))
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestVoidkeyClient_ClockSkew(t *testing.T) {
+	server := newSkewedBroker(t, 2*time.Hour, `[]`)
+	client := NewVoidkeyClient(server.Client(), server.URL)
+	var logs bytes.Buffer
+	client.SetLogger(newLogger(&logs, logOptions{level: slog.LevelWarn, format: logFormatText}))
+
+	_, ok := client.ClockSkew()
+	assert.False(t, ok)
+
+	for i := 0; i < 2; i++ {
+		_, err := client.ListIdpProviders(context.Background())
+		assert.NoError(t, err)
+	}
+
+	skew, ok := client.ClockSkew()
+	assert.True(t, ok)
+	assert.InDelta(t, (2 * time.Hour).Seconds(), skew.Seconds(), 2)
+	assert.InDelta(t, time.Now().Add(2*time.Hour).Unix(), client.Now().Unix(), 2)
+
+	// The warning is only shown once per client
+	assert.Equal(t, 1, strings.Count(logs.String(), "Local clock is"))
+	assert.Contains(t, logs.String(), "behind the broker")
+}
+
+func TestVoidkeyClient_ClockSkewBelowThreshold(t *testing.T) {
+	server := newSkewedBroker(t, 0, `[]`)
+	client := NewVoidkeyClient(server.Client(), server.URL)
+	var logs bytes.Buffer
+	client.SetLogger(newLogger(&logs, logOptions{level: slog.LevelWarn, format: logFormatText}))
+
+	_, err := client.ListIdpProviders(context.Background())
+
+	assert.NoError(t, err)
+	skew, ok := client.ClockSkew()
+	assert.True(t, ok)
+	assert.Less(t, skew.Abs(), clockSkewThreshold)
+	assert.Empty(t, logs.String())
+}
+
+func TestMintCredentialsWithFlags_ExpiryUsesBrokerClock(t *testing.T) {
+	// The credentials expire in an hour by the local clock, but the
+	// broker's clock is two hours ahead, so they are already expired
+	expiresAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	server := newSkewedBroker(t, 2*time.Hour, `{"AWS_CREDENTIALS": {"credentials": {"AWS_ACCESS_KEY_ID": "AKIA"}, "expiresAt": "`+expiresAt+`"}}`)
+	client := NewVoidkeyClient(server.Client(), server.URL)
+
+	cmd, stdout, stderr := SetupTestCommand()
+	err := mintCredentialsWithFlags(client, cmd, mintOptions{token: "test-token", format: "env", keys: []string{"AWS_CREDENTIALS"}})
+
+	assert.NoError(t, err)
+	assert.Contains(t, stdout.String(), "export AWS_ACCESS_KEY_ID=AKIA")
+	assert.Contains(t, stderr.String(), "Credentials for AWS_CREDENTIALS expired at "+expiresAt)
+	assert.Contains(t, stderr.String(), "(expires: "+expiresAt+", in -")
+}
@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config represents the contents of the voidkey configuration file
+type Config struct {
+	// Server is the broker server URL, overridden by --server
+	Server string `yaml:"server"`
+	// Timeout bounds each broker request, overridden by --timeout
+	Timeout time.Duration `yaml:"timeout"`
+	// Retries is the number of retries for failed broker requests,
+	// overridden by --retries
+	Retries *int `yaml:"retries"`
+	// Proxy is the proxy URL for broker requests, overridden by --proxy
+	Proxy string `yaml:"proxy"`
+	// TLS configures how the broker's TLS connections are verified
+	TLS TLSConfig `yaml:"tls"`
+	// Keys holds per-key settings indexed by key name
+	Keys map[string]KeyConfig `yaml:"keys"`
+	// Docker configures the Docker credential helper
+	Docker DockerConfig `yaml:"docker"`
+	// Git configures the Git credential helper
+	Git GitConfig `yaml:"git"`
+	// Profile selects the default profile, overridden by --profile and
+	// VOIDKEY_PROFILE
+	Profile string `yaml:"profile"`
+	// Profiles holds named broker settings that override the ones above
+	Profiles map[string]Profile `yaml:"profiles"`
+}
+
+// Profile holds the broker settings of a named profile. Settings left unset
+// fall back to the top level of the configuration file.
+type Profile struct {
+	Server  string        `yaml:"server"`
+	Timeout time.Duration `yaml:"timeout"`
+	Retries *int          `yaml:"retries"`
+	Proxy   string        `yaml:"proxy"`
+	TLS     TLSConfig     `yaml:"tls"`
+}
+
+// TLSConfig configures TLS for broker connections
+type TLSConfig struct {
+	// CAFile is a PEM bundle of CAs trusted in addition to the system ones
+	CAFile string `yaml:"caFile"`
+	// ClientCert and ClientKey are the PEM client certificate and key
+	// presented to brokers that require mutual TLS
+	ClientCert string `yaml:"clientCert"`
+	ClientKey  string `yaml:"clientKey"`
+	// PinSHA256 lists base64 SHA-256 hashes of the SubjectPublicKeyInfo of
+	// certificates, one of which must appear in the broker's chain
+	PinSHA256 []string `yaml:"pinSha256"`
+	// InsecureSkipVerify disables certificate verification, for local
+	// development only
+	InsecureSkipVerify bool `yaml:"insecureSkipVerify"`
+}
+
+// KeyConfig holds settings for a single key
+type KeyConfig struct {
+	// Env renames credential variables of the key in env output,
+	// mapping the broker's variable name to the exported one
+	Env map[string]string `yaml:"env"`
+}
+
+// DockerConfig configures the Docker credential helper
+type DockerConfig struct {
+	// Registries maps registry hostnames to the keys that log in to them
+	Registries map[string]LoginConfig `yaml:"registries"`
+}
+
+// GitConfig configures the Git credential helper
+type GitConfig struct {
+	// Hosts maps hostnames, optionally followed by a repository path
+	// prefix, to the keys that log in to them
+	Hosts map[string]LoginConfig `yaml:"hosts"`
+}
+
+// LoginConfig selects the key and credential fields a login is taken from
+type LoginConfig struct {
+	// Key is the name of the key minted for the login
+	Key string `yaml:"key"`
+	// Username is a fixed username, for registries such as ECR or Git hosts
+	// such as GitHub whose credentials are a token only. It takes
+	// precedence over UsernameField.
+	Username string `yaml:"username"`
+	// UsernameField and PasswordField name the credential fields holding
+	// the login. The defaults depend on the helper, e.g. DOCKER_USERNAME
+	// and DOCKER_PASSWORD for Docker.
+	UsernameField string `yaml:"usernameField"`
+	PasswordField string `yaml:"passwordField"`
+}
+
+var (
+	configFile  string
+	profileName string
+	cfg         Config
+)
+
+// defaultConfigFile returns the path of the configuration file used when
+// neither --config nor VOIDKEY_CONFIG is set
+func defaultConfigFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".voidkey", "config.yaml")
+}
+
+// loadConfig reads the configuration file at path. A missing file is only an
+// error when the path was given explicitly.
+func loadConfig(path string, explicit bool) (Config, error) {
+	var config Config
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return config, nil
+		}
+		return config, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+// withProfile returns the configuration with the settings of the named
+// profile applied. An empty name selects the configuration's default
+// profile, if any.
+func (c Config) withProfile(name string) (Config, error) {
+	if name == "" {
+		name = c.Profile
+	}
+	if name == "" {
+		return c, nil
+	}
+
+	profile, ok := c.Profiles[name]
+	if !ok {
+		return c, usageErrorf("unknown profile %q", name)
+	}
+
+	if profile.Server != "" {
+		c.Server = profile.Server
+	}
+	if profile.Timeout > 0 {
+		c.Timeout = profile.Timeout
+	}
+	if profile.Retries != nil {
+		c.Retries = profile.Retries
+	}
+	if profile.Proxy != "" {
+		c.Proxy = profile.Proxy
+	}
+	if profile.TLS.CAFile != "" {
+		c.TLS.CAFile = profile.TLS.CAFile
+	}
+	if profile.TLS.ClientCert != "" {
+		c.TLS.ClientCert = profile.TLS.ClientCert
+		c.TLS.ClientKey = profile.TLS.ClientKey
+	}
+	if profile.TLS.PinSHA256 != nil {
+		c.TLS.PinSHA256 = profile.TLS.PinSHA256
+	}
+	if profile.TLS.InsecureSkipVerify {
+		c.TLS.InsecureSkipVerify = true
+	}
+	c.Profile = name
+	return c, nil
+}
+
+// resolveProfile returns the profile selected with --profile or
+// VOIDKEY_PROFILE, or an empty name for the configuration's default
+func resolveProfile() string {
+	if profileName != "" {
+		return profileName
+	}
+	return os.Getenv("VOIDKEY_PROFILE")
+}
+
+// resolveConfigFile returns the configuration file to load and whether it was
+// chosen explicitly by the user
+func resolveConfigFile() (string, bool) {
+	if configFile != "" {
+		return configFile, true
+	}
+	if path := os.Getenv("VOIDKEY_CONFIG"); path != "" {
+		return path, true
+	}
+	return defaultConfigFile(), false
+}
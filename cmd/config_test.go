@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	content := `keys:
+  AWS_STAGING:
+    env:
+      AWS_ACCESS_KEY_ID: STAGING_AWS_ACCESS_KEY_ID
+`
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	config, err := loadConfig(path, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "STAGING_AWS_ACCESS_KEY_ID", config.Keys["AWS_STAGING"].Env["AWS_ACCESS_KEY_ID"])
+}
+
+func TestLoadConfig_MissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.yaml")
+
+	// The default location is optional
+	config, err := loadConfig(path, false)
+	assert.NoError(t, err)
+	assert.Empty(t, config.Keys)
+
+	// An explicitly requested file must exist
+	_, err = loadConfig(path, true)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to read config file")
+}
+
+func TestLoadConfig_InvalidYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("keys: [unterminated"), 0600))
+
+	_, err := loadConfig(path, true)
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to parse config file")
+}
+
+func TestResolveConfigFile(t *testing.T) {
+	original := configFile
+	defer func() { configFile = original }()
+
+	configFile = ""
+	t.Setenv("VOIDKEY_CONFIG", "")
+	path, explicit := resolveConfigFile()
+	assert.Equal(t, defaultConfigFile(), path)
+	assert.False(t, explicit)
+
+	t.Setenv("VOIDKEY_CONFIG", "/tmp/voidkey.yaml")
+	path, explicit = resolveConfigFile()
+	assert.Equal(t, "/tmp/voidkey.yaml", path)
+	assert.True(t, explicit)
+
+	configFile = "/etc/voidkey.yaml"
+	path, explicit = resolveConfigFile()
+	assert.Equal(t, "/etc/voidkey.yaml", path)
+	assert.True(t, explicit)
+}
+
+func TestConfigWithProfile(t *testing.T) {
+	retries := 5
+	config := Config{
+		Server:  "https://broker.example.com",
+		Timeout: 10 * time.Second,
+		TLS:     TLSConfig{CAFile: "/etc/voidkey/ca.pem"},
+		Profile: "prod",
+		Profiles: map[string]Profile{
+			"prod": {Server: "https://broker.prod.example.com", Retries: &retries},
+			"ci":   {TLS: TLSConfig{ClientCert: "/run/ci.pem", ClientKey: "/run/ci-key.pem"}},
+		},
+	}
+
+	// The default profile applies without a name
+	resolved, err := config.withProfile("")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://broker.prod.example.com", resolved.Server)
+	assert.Equal(t, 10*time.Second, resolved.Timeout)
+	assert.Equal(t, 5, *resolved.Retries)
+
+	// Unset profile settings fall back to the top level
+	resolved, err = config.withProfile("ci")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://broker.example.com", resolved.Server)
+	assert.Equal(t, TLSConfig{CAFile: "/etc/voidkey/ca.pem", ClientCert: "/run/ci.pem", ClientKey: "/run/ci-key.pem"}, resolved.TLS)
+
+	_, err = config.withProfile("missing")
+	assert.ErrorIs(t, err, ErrUsage)
+}
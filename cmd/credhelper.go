@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+)
+
+// mintKey mints a single key for the credential helpers, with the token
+// from the environment or the agent. A refused key is an error.
+func mintKey(client *VoidkeyClient, cmd *cobra.Command, key string) (KeyCredentialResponse, error) {
+	token, err := resolveClientToken(client, cmd, "", "")
+	if err != nil {
+		return KeyCredentialResponse{}, err
+	}
+
+	// Helpers run once per request, so without the agent credentials are
+	// cached on disk until shortly before they expire
+	if cacheFile := client.credentialCacheFile(token, key); cacheFile != "" {
+		source, err := client.KeySource(token, key, voidkey.WithCacheFile(cacheFile))
+		if err != nil {
+			return KeyCredentialResponse{}, err
+		}
+		return source.Credentials(commandContext(cmd))
+	}
+
+	keyResponses, err := client.MintKeys(commandContext(cmd), token, "", []string{key}, 0, false)
+	if err != nil {
+		return KeyCredentialResponse{}, err
+	}
+	response, ok := keyResponses[key]
+	if !ok {
+		return KeyCredentialResponse{}, voidkey.WrapKind(ErrInvalidResponse, fmt.Errorf("broker did not return key %s", key))
+	}
+	if _, failures := splitKeyResults(keyResponses, []string{key}); len(failures) > 0 {
+		return KeyCredentialResponse{}, newMintError(1, failures, false)
+	}
+	return response, nil
+}
+
+// forgetKey deletes the cached credentials of key, so the next mintKey
+// mints new ones. Helpers call it for logins the server rejected. Without a
+// token or a cache there is nothing to forget.
+func forgetKey(client *VoidkeyClient, cmd *cobra.Command, key string) error {
+	token, _ := lookupToken("", "")
+	cacheFile := client.credentialCacheFile(token, key)
+	if token == "" || cacheFile == "" {
+		return nil
+	}
+	if err := os.Remove(cacheFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete cached credentials of key %s: %w", key, err)
+	}
+	logFor(cmd).Debug("deleted cached credentials", "key", key)
+	return nil
+}
+
+// login is a username and password minted from a key, as credential helpers
+// hand them out
+type login struct {
+	Username string
+	Password string
+	// Response is the minted key the login was taken from
+	Response KeyCredentialResponse
+}
+
+// mintLogin mints the key of config and takes the login from its credential
+// fields, or usernameField and passwordField unless configured otherwise
+func mintLogin(client *VoidkeyClient, cmd *cobra.Command, config LoginConfig, usernameField, passwordField string) (login, error) {
+	response, err := mintKey(client, cmd, config.Key)
+	if err != nil {
+		return login{}, err
+	}
+
+	result := login{Username: config.Username, Response: response}
+	if result.Username == "" {
+		if config.UsernameField != "" {
+			usernameField = config.UsernameField
+		}
+		if result.Username, err = credentialField(response, config.Key, usernameField); err != nil {
+			return login{}, err
+		}
+	}
+	if config.PasswordField != "" {
+		passwordField = config.PasswordField
+	}
+	if result.Password, err = credentialField(response, config.Key, passwordField); err != nil {
+		return login{}, err
+	}
+	return result, nil
+}
+
+// credentialField returns a credential field of a minted key, which must be
+// set
+func credentialField(response KeyCredentialResponse, key, field string) (string, error) {
+	value := response.Credentials[field]
+	if value == "" {
+		return "", fmt.Errorf("key %s has no credential %s", key, field)
+	}
+	return value, nil
+}
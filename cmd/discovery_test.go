@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newDiscoveryBroker starts a broker that publishes the given discovery
+// document, or answers 404 for it when document is empty. Discovery itself
+// is tested in package voidkey.
+func newDiscoveryBroker(t *testing.T, document string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if document == "" || r.URL.Path != "/.well-known/voidkey" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(document))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDiscovery_UnsupportedFeatureExitCode(t *testing.T) {
+	server := newDiscoveryBroker(t, `{"serverVersion": "0.5.0", "features": ["mint"]}`)
+
+	client := NewVoidkeyClient(server.Client(), server.URL)
+	client.EnableDiscovery("")
+
+	_, err := client.GetAvailableKeys(context.Background(), "test-token")
+
+	code, name := classifyError(err)
+	assert.Equal(t, exitUnsupported, code)
+	assert.Equal(t, "unsupported_feature", name)
+}
+
+func TestServerVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		document string
+		expected string
+	}{
+		{name: "discovery", document: `{"serverVersion": "0.9.0", "features": ["mint"]}`, expected: "0.9.0"},
+		{name: "legacy broker", expected: "unknown (broker does not support discovery)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newDiscoveryBroker(t, tt.document)
+
+			client := NewVoidkeyClient(server.Client(), server.URL)
+			client.EnableDiscovery("")
+
+			assert.Equal(t, tt.expected, serverVersion(context.Background(), client))
+		})
+	}
+}
+
+func TestServerVersion_Unavailable(t *testing.T) {
+	server := newDiscoveryBroker(t, "")
+	server.Close()
+
+	client := NewVoidkeyClient(&http.Client{}, server.URL)
+	client.EnableDiscovery("")
+
+	assert.Equal(t, "unavailable", serverVersion(context.Background(), client))
+}
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// Credential fields a registry login is taken from unless configured
+// otherwise
+const (
+	defaultDockerUsernameField = "DOCKER_USERNAME"
+	defaultDockerPasswordField = "DOCKER_PASSWORD"
+)
+
+// errCredentialsNotFound tells Docker the helper has no credentials for a
+// registry. Docker matches the exact message.
+var errCredentialsNotFound = errors.New("credentials not found in native keychain")
+
+// dockerHubAliases are the hostnames of Docker Hub. Docker asks for its
+// credentials as https://index.docker.io/v1/.
+var dockerHubAliases = map[string]string{
+	"docker.io":            "index.docker.io",
+	"registry-1.docker.io": "index.docker.io",
+}
+
+// dockerCredentials is a registry login in the credential helper protocol
+type dockerCredentials struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// dockerCredential creates the docker-credential command
+func dockerCredential(voidkeyClient *VoidkeyClient) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "docker-credential <get|store|erase|list>",
+		Short: "Act as a Docker credential helper for registries backed by Voidkey keys",
+		Long: `Act as a Docker credential helper, so docker pull and push log in to
+registries with short-lived credentials minted from Voidkey keys. The
+registries and the keys and credential fields their logins are taken from
+are configured in the configuration file:
+
+  docker:
+    registries:
+      ghcr.io:
+        key: GHCR_CREDENTIALS          # DOCKER_USERNAME and DOCKER_PASSWORD
+      123456789012.dkr.ecr.eu-west-1.amazonaws.com:
+        key: ECR_CREDENTIALS
+        username: AWS                  # fixed username, token only
+        passwordField: ECR_TOKEN
+
+Install the CLI as docker-credential-voidkey on the PATH, e.g. as a symlink,
+and select it in ~/.docker/config.json:
+
+  {"credHelpers": {"ghcr.io": "voidkey"}}
+
+Credentials are taken from the agent if one runs. Otherwise they are cached
+in the user's cache directory, readable only by the user, until shortly
+before they expire, so pulls and pushes do not mint every time. erase
+deletes the cached login of the registry; store accepts and discards
+logins, as there is nothing to store.`,
+		Args:        cobra.ExactArgs(1),
+		ValidArgs:   []string{"get", "store", "erase", "list"},
+		Annotations: map[string]string{agentAnnotation: "true"},
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			// Docker does not read stderr, but shows what failed helpers
+			// print to stdout
+			cobraCmd.SilenceUsage = true
+			err := runDockerCredential(voidkeyClient, cobraCmd, args[0])
+			if err != nil {
+				_, _ = fmt.Fprintln(cobraCmd.OutOrStdout(), redactor.Redact(err.Error()))
+			}
+			return err
+		},
+	}
+
+	return cmd
+}
+
+// runDockerCredential runs a single action of the credential helper
+// protocol, reading its input from stdin
+func runDockerCredential(client *VoidkeyClient, cmd *cobra.Command, action string) error {
+	switch action {
+	case "get":
+		serverURL, err := io.ReadAll(cmd.InOrStdin())
+		if err != nil {
+			return fmt.Errorf("failed to read server URL: %w", err)
+		}
+		credentials, err := dockerLogin(client, cmd, strings.TrimSpace(string(serverURL)))
+		if err != nil {
+			return err
+		}
+		return json.NewEncoder(cmd.OutOrStdout()).Encode(credentials)
+	case "store":
+		// Logins are minted on demand, so there is nothing to keep
+		_, _ = io.Copy(io.Discard, cmd.InOrStdin())
+		logFor(cmd).Debug("ignoring docker credential action", "action", action)
+		return nil
+	case "erase":
+		serverURL, err := io.ReadAll(cmd.InOrStdin())
+		if err != nil {
+			return fmt.Errorf("failed to read server URL: %w", err)
+		}
+		config, ok := registryConfig(cfg.Docker.Registries, strings.TrimSpace(string(serverURL)))
+		if !ok || config.Key == "" {
+			return nil
+		}
+		return forgetKey(client, cmd, config.Key)
+	case "list":
+		registries := make(map[string]string, len(cfg.Docker.Registries))
+		for registry, config := range cfg.Docker.Registries {
+			registries[registry] = config.Username
+		}
+		return json.NewEncoder(cmd.OutOrStdout()).Encode(registries)
+	default:
+		return usageErrorf("unknown docker credential action %q (must be get, store, erase or list)", action)
+	}
+}
+
+// dockerLogin mints the login for the registry at serverURL
+func dockerLogin(client *VoidkeyClient, cmd *cobra.Command, serverURL string) (dockerCredentials, error) {
+	config, ok := registryConfig(cfg.Docker.Registries, serverURL)
+	if !ok {
+		logFor(cmd).Debug("no key configured for registry", "serverURL", serverURL)
+		return dockerCredentials{}, errCredentialsNotFound
+	}
+	if config.Key == "" {
+		return dockerCredentials{}, usageErrorf("no key configured for registry %s", serverURL)
+	}
+
+	login, err := mintLogin(client, cmd, config, defaultDockerUsernameField, defaultDockerPasswordField)
+	if err != nil {
+		return dockerCredentials{}, err
+	}
+	return dockerCredentials{ServerURL: serverURL, Username: login.Username, Secret: login.Password}, nil
+}
+
+// registryConfig returns the configuration of the registry at serverURL.
+// Registries are matched by hostname, so both ghcr.io and https://ghcr.io/v2/
+// select the registry configured as ghcr.io.
+func registryConfig(registries map[string]LoginConfig, serverURL string) (LoginConfig, bool) {
+	host := registryHost(serverURL)
+	if host == "" {
+		return LoginConfig{}, false
+	}
+
+	names := make([]string, 0, len(registries))
+	for name := range registries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if registryHost(name) == host {
+			return registries[name], true
+		}
+	}
+	return LoginConfig{}, false
+}
+
+// registryHost returns the hostname, with port, of a registry server URL
+func registryHost(serverURL string) string {
+	host := strings.ToLower(strings.TrimSpace(serverURL))
+	if _, rest, ok := strings.Cut(host, "://"); ok {
+		host = rest
+	}
+	host, _, _ = strings.Cut(host, "/")
+	if alias, ok := dockerHubAliases[host]; ok {
+		return alias
+	}
+	return host
+}
@@ -0,0 +1,154 @@
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dockerTestConfig configures the registries used by the tests
+var dockerTestConfig = Config{Docker: DockerConfig{Registries: map[string]LoginConfig{
+	"ghcr.io": {Key: "GHCR_CREDENTIALS"},
+	"https://123456789012.dkr.ecr.eu-west-1.amazonaws.com": {
+		Key:           "ECR_CREDENTIALS",
+		Username:      "AWS",
+		PasswordField: "ECR_TOKEN",
+	},
+	"harbor.example.com:8443": {Key: "HARBOR_CREDENTIALS", UsernameField: "HARBOR_ROBOT"},
+}}}
+
+func TestDockerCredential_Get(t *testing.T) {
+	setConfig(t, dockerTestConfig)
+	broker, client := newServeBroker(t)
+	broker.SetKey("GHCR_CREDENTIALS", map[string]string{"DOCKER_USERNAME": "x-access-token", "DOCKER_PASSWORD": "ghs_token"})
+	broker.SetKey("ECR_CREDENTIALS", map[string]string{"ECR_TOKEN": "ecr-token"})
+
+	tests := []struct {
+		serverURL string
+		expected  dockerCredentials
+	}{
+		{
+			serverURL: "ghcr.io",
+			expected:  dockerCredentials{ServerURL: "ghcr.io", Username: "x-access-token-1", Secret: "ghs_token-1"},
+		},
+		{
+			serverURL: "https://123456789012.dkr.ecr.eu-west-1.amazonaws.com",
+			expected:  dockerCredentials{ServerURL: "https://123456789012.dkr.ecr.eu-west-1.amazonaws.com", Username: "AWS", Secret: "ecr-token-1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.serverURL, func(t *testing.T) {
+			stdout, err := runHelperCommand(t, dockerCredential, client, "get", tt.serverURL+"\n")
+			require.NoError(t, err)
+
+			var credentials dockerCredentials
+			require.NoError(t, json.Unmarshal([]byte(stdout), &credentials))
+			assert.Equal(t, tt.expected, credentials)
+		})
+	}
+}
+
+func TestDockerCredential_GetErrors(t *testing.T) {
+	setConfig(t, dockerTestConfig)
+	broker, client := newServeBroker(t)
+	broker.SetKey("HARBOR_CREDENTIALS", map[string]string{"DOCKER_PASSWORD": "secret"})
+
+	// Docker recognises unknown registries by the exact message on stdout
+	stdout, err := runHelperCommand(t, dockerCredential, client, "get", "https://index.docker.io/v1/")
+	assert.ErrorIs(t, err, errCredentialsNotFound)
+	assert.Equal(t, "credentials not found in native keychain\n", stdout)
+
+	stdout, err = runHelperCommand(t, dockerCredential, client, "get", "harbor.example.com:8443")
+	assert.EqualError(t, err, "key HARBOR_CREDENTIALS has no credential HARBOR_ROBOT")
+	assert.Equal(t, "key HARBOR_CREDENTIALS has no credential HARBOR_ROBOT\n", stdout)
+
+	_, err = runHelperCommand(t, dockerCredential, client, "get", "ghcr.io")
+	assert.ErrorIs(t, err, ErrRequestRejected)
+}
+
+func TestDockerCredential_StoreEraseList(t *testing.T) {
+	setConfig(t, dockerTestConfig)
+	_, client := newServeBroker(t)
+
+	stdout, err := runHelperCommand(t, dockerCredential, client, "store", `{"ServerURL":"ghcr.io","Username":"user","Secret":"secret"}`)
+	assert.NoError(t, err)
+	assert.Empty(t, stdout)
+
+	// Without a credential cache there is nothing to erase
+	stdout, err = runHelperCommand(t, dockerCredential, client, "erase", "ghcr.io\n")
+	assert.NoError(t, err)
+	assert.Empty(t, stdout)
+
+	stdout, err = runHelperCommand(t, dockerCredential, client, "list", "")
+	require.NoError(t, err)
+	var registries map[string]string
+	require.NoError(t, json.Unmarshal([]byte(stdout), &registries))
+	assert.Equal(t, map[string]string{
+		"ghcr.io": "",
+		"https://123456789012.dkr.ecr.eu-west-1.amazonaws.com": "AWS",
+		"harbor.example.com:8443":                              "",
+	}, registries)
+
+	_, err = runHelperCommand(t, dockerCredential, client, "login", "")
+	assert.ErrorIs(t, err, ErrUsage)
+}
+
+func TestDockerCredential_EraseDeletesCachedLogin(t *testing.T) {
+	setConfig(t, dockerTestConfig)
+	broker, client := newServeBroker(t)
+	broker.SetKey("GHCR_CREDENTIALS", map[string]string{"DOCKER_USERNAME": "x-access-token", "DOCKER_PASSWORD": "ghs_token"})
+	client.EnableCredentialCache(t.TempDir())
+
+	secret := func() string {
+		stdout, err := runHelperCommand(t, dockerCredential, client, "get", "ghcr.io\n")
+		require.NoError(t, err)
+		var credentials dockerCredentials
+		require.NoError(t, json.Unmarshal([]byte(stdout), &credentials))
+		return credentials.Secret
+	}
+
+	assert.Equal(t, "ghs_token-1", secret())
+	assert.Equal(t, "ghs_token-1", secret())
+
+	_, err := runHelperCommand(t, dockerCredential, client, "erase", "https://ghcr.io\n")
+	require.NoError(t, err)
+	assert.Equal(t, "ghs_token-2", secret())
+	assert.Equal(t, 2, broker.Mints("GHCR_CREDENTIALS"))
+}
+
+func TestRegistryHost(t *testing.T) {
+	tests := map[string]string{
+		"ghcr.io":                     "ghcr.io",
+		"https://ghcr.io":             "ghcr.io",
+		"https://GHCR.io/v2/":         "ghcr.io",
+		"harbor.example.com:8443":     "harbor.example.com:8443",
+		"https://index.docker.io/v1/": "index.docker.io",
+		"docker.io":                   "index.docker.io",
+		"":                            "",
+	}
+
+	for serverURL, expected := range tests {
+		assert.Equal(t, expected, registryHost(serverURL), serverURL)
+	}
+}
+
+func TestRegistryConfig(t *testing.T) {
+	registries := map[string]LoginConfig{
+		"docker.io": {Key: "DOCKERHUB_CREDENTIALS"},
+		"ghcr.io":   {Key: "GHCR_CREDENTIALS"},
+	}
+
+	config, ok := registryConfig(registries, "https://index.docker.io/v1/")
+	assert.True(t, ok)
+	assert.Equal(t, "DOCKERHUB_CREDENTIALS", config.Key)
+
+	config, ok = registryConfig(registries, "https://ghcr.io")
+	assert.True(t, ok)
+	assert.Equal(t, "GHCR_CREDENTIALS", config.Key)
+
+	_, ok = registryConfig(registries, "quay.io")
+	assert.False(t, ok)
+}
@@ -0,0 +1,313 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+)
+
+// Doctor check results
+const (
+	checkPass = "pass"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+// tokenExpiryWarning is how close to expiry a token may get before the
+// doctor warns about it
+const tokenExpiryWarning = 5 * time.Minute
+
+// doctorCheck is the result of a single diagnostic check
+type doctorCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// doctorReport is the result of all checks, as printed with --output json
+type doctorReport struct {
+	Checks  []doctorCheck `json:"checks"`
+	Summary struct {
+		Pass int `json:"pass"`
+		Warn int `json:"warn"`
+		Fail int `json:"fail"`
+	} `json:"summary"`
+}
+
+// add records a check result
+func (r *doctorReport) add(name, status, format string, args ...any) {
+	r.Checks = append(r.Checks, doctorCheck{Name: name, Status: status, Message: redactor.Redact(fmt.Sprintf(format, args...))})
+	switch status {
+	case checkPass:
+		r.Summary.Pass++
+	case checkWarn:
+		r.Summary.Warn++
+	case checkFail:
+		r.Summary.Fail++
+	}
+}
+
+// doctor creates a new doctor command with dependency injection
+func doctor(voidkeyClient *VoidkeyClient) *cobra.Command {
+	var token, idpName, format string
+
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose problems with the broker, TLS, token and keys",
+		Long: `Run end-to-end diagnostics against the broker server and print pass, warn or
+fail for each check: broker reachability, TLS, the IdP list, token presence
+and source, token expiry and audience, key permissions and clock skew.
+
+The broker is always contacted, even if its capabilities are cached. The
+command exits with code 10 if any check fails.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch format {
+			case "text", "json":
+			default:
+				return usageErrorf("invalid --output %q (must be text or json)", format)
+			}
+			// Failed checks are reported in the output, not with usage
+			cmd.SilenceUsage = true
+
+			report := runDoctor(commandContext(cmd), voidkeyClient, resolveTLSConfig(cmd), token, idpName)
+
+			if format == "json" {
+				data, _ := json.MarshalIndent(report, "", "  ")
+				_, _ = fmt.Fprintln(cmd.OutOrStdout(), string(data))
+			} else if err := printDoctorReport(cmd.OutOrStdout(), report); err != nil {
+				return err
+			}
+
+			if report.Summary.Fail > 0 {
+				return voidkey.WrapKind(ErrCheckFailed, fmt.Errorf("%d of %d checks failed", report.Summary.Fail, len(report.Checks)))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&token, "token", "", "OIDC token to check (default from $OIDC_TOKEN or $GITHUB_TOKEN)")
+	cmd.Flags().StringVar(&idpName, "idp", "", "IdP provider name to check")
+	cmd.Flags().StringVarP(&format, "output", "o", "text", "Output format (text|json)")
+
+	return cmd
+}
+
+// runDoctor runs all checks in order. Checks that depend on an earlier one
+// that failed report that instead of repeating its error.
+func runDoctor(ctx context.Context, client *VoidkeyClient, tlsConfig TLSConfig, token, idpName string) *doctorReport {
+	report := &doctorReport{}
+
+	// Broker reachability, via discovery which every command starts with.
+	// Cached capabilities would not prove the broker or its certificate
+	// are reachable.
+	capabilities, brokerErr := client.ProbeCapabilities(ctx)
+	if brokerErr != nil {
+		report.add("broker", checkFail, "%s is not reachable: %v", client.serverURL, brokerErr)
+	} else {
+		report.add("broker", checkPass, "%s is reachable (server version %s)", client.serverURL, capabilities.DisplayVersion())
+	}
+
+	checkTLS(report, client.serverURL, tlsConfig, brokerErr)
+
+	providers, err := client.ListIdpProviders(ctx)
+	switch {
+	case err != nil:
+		report.add("idps", checkFail, "failed to list IdP providers: %v", err)
+	case len(providers) == 0:
+		report.add("idps", checkWarn, "the broker has no IdP providers configured")
+	default:
+		report.add("idps", checkPass, "%s", describeProviders(providers, idpName))
+		if idpName != "" && !hasProvider(providers, idpName) {
+			report.add("idps", checkFail, "IdP provider %q is not configured on the broker", idpName)
+		}
+	}
+
+	token, source := lookupToken(token, idpName)
+	if token == "" {
+		report.add("token", checkFail, "no OIDC token found; pass --token or set OIDC_TOKEN or GITHUB_TOKEN")
+	} else {
+		redactor.Add(token)
+		report.add("token", checkPass, "found in %s", source)
+		checkTokenClaims(report, token, client)
+	}
+
+	if token == "" {
+		report.add("keys", checkWarn, "skipped, no OIDC token")
+	} else if keys, err := client.GetAvailableKeys(ctx, token); err != nil {
+		report.add("keys", checkFail, "failed to list keys for the token: %v", err)
+	} else if len(keys) == 0 {
+		report.add("keys", checkWarn, "the token's identity is not permitted to mint any keys")
+	} else {
+		report.add("keys", checkPass, "%d keys available: %s", len(keys), strings.Join(keys, ", "))
+	}
+
+	if skew, ok := client.ClockSkew(); !ok {
+		report.add("clock", checkWarn, "could not measure clock skew, the broker sent no Date header")
+	} else if skew.Abs() > clockSkewThreshold {
+		report.add("clock", checkWarn, "local clock is %s; token and credential expiry checks may fail", describeSkew(skew))
+	} else {
+		report.add("clock", checkPass, "local clock is %s", describeSkew(skew))
+	}
+
+	return report
+}
+
+// checkTLS reports how the connection to the broker is secured
+func checkTLS(report *doctorReport, server string, tlsConfig TLSConfig, brokerErr error) {
+	parsed, err := parseServerURL(server)
+	if err != nil {
+		report.add("tls", checkFail, "%v", err)
+		return
+	}
+
+	switch {
+	case parsed.Scheme == "unix":
+		report.add("tls", checkPass, "not needed for a unix socket")
+	case parsed.Scheme == "http" && isLoopback(parsed.Hostname()):
+		report.add("tls", checkPass, "not needed for a loopback broker")
+	case parsed.Scheme == "http":
+		report.add("tls", checkWarn, "plain HTTP, tokens and credentials are sent unencrypted")
+	case isTLSError(brokerErr):
+		report.add("tls", checkFail, "TLS handshake failed: %v", brokerErr)
+	case tlsConfig.InsecureSkipVerify:
+		report.add("tls", checkWarn, "certificate verification is disabled (--insecure-skip-verify)")
+	case brokerErr != nil:
+		report.add("tls", checkWarn, "skipped, broker not reachable")
+	default:
+		report.add("tls", checkPass, "certificate verified%s", describeTLSConfig(tlsConfig))
+	}
+}
+
+// isTLSError reports whether err was caused by the TLS handshake
+func isTLSError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var verificationErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	return errors.As(err, &verificationErr) || errors.As(err, &unknownAuthority) || errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidErr) || errors.As(err, &recordErr) || errors.As(err, &alertErr) ||
+		errors.Is(err, errPinMismatch)
+}
+
+// describeTLSConfig lists the non-default TLS settings in use
+func describeTLSConfig(config TLSConfig) string {
+	var settings []string
+	if config.CAFile != "" {
+		settings = append(settings, "CA file "+config.CAFile)
+	}
+	if config.ClientCert != "" {
+		settings = append(settings, "client certificate "+config.ClientCert)
+	}
+	if len(config.PinSHA256) > 0 {
+		settings = append(settings, fmt.Sprintf("%d pinned keys", len(config.PinSHA256)))
+	}
+	if len(settings) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(settings, ", ") + ")"
+}
+
+// checkTokenClaims reports on the expiry and audience of a JWT, decoded
+// without verification
+func checkTokenClaims(report *doctorReport, token string, client *VoidkeyClient) {
+	claims, err := decodeJWTClaims(token)
+	if err != nil {
+		report.add("token-claims", checkWarn, "cannot check expiry and audience: %v", err)
+		return
+	}
+
+	// Judge the token by the broker's clock, which is what verifies it
+	now := client.Now()
+
+	switch {
+	case claims.ExpiresAt == nil:
+		report.add("token-claims", checkWarn, "token has no expiry (exp)")
+	case !claims.ExpiresAt.After(now):
+		report.add("token-claims", checkFail, "token expired %s ago", now.Sub(claims.ExpiresAt.Time).Round(time.Second))
+	case claims.ExpiresAt.Sub(now) < tokenExpiryWarning:
+		report.add("token-claims", checkWarn, "token expires in %s", claims.ExpiresAt.Sub(now).Round(time.Second))
+	default:
+		report.add("token-claims", checkPass, "token expires in %s", claims.ExpiresAt.Sub(now).Round(time.Second))
+	}
+
+	if claims.NotBefore != nil && claims.NotBefore.After(now) {
+		report.add("token-claims", checkFail, "token is not valid for another %s (nbf)", claims.NotBefore.Sub(now).Round(time.Second))
+	}
+
+	if len(claims.Audience) == 0 {
+		report.add("token-claims", checkWarn, "token has no audience (aud)")
+	} else {
+		report.add("token-claims", checkPass, "audience %s, issuer %s, subject %s", strings.Join(claims.Audience, ", "), claims.Issuer, claims.Subject)
+	}
+}
+
+// describeProviders summarises the IdP list
+func describeProviders(providers []IdpProvider, selected string) string {
+	names := make([]string, 0, len(providers))
+	for _, provider := range providers {
+		name := provider.Name
+		if provider.IsDefault {
+			name += " (default)"
+		}
+		names = append(names, name)
+	}
+	description := fmt.Sprintf("%d IdP providers: %s", len(providers), strings.Join(names, ", "))
+	if selected != "" {
+		description += fmt.Sprintf("; using %s", selected)
+	}
+	return description
+}
+
+// hasProvider reports whether providers includes the named provider
+func hasProvider(providers []IdpProvider, name string) bool {
+	for _, provider := range providers {
+		if provider.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// describeSkew formats a clock skew against the broker for the user
+func describeSkew(skew time.Duration) string {
+	skew = skew.Round(time.Second)
+	switch {
+	case skew > 0:
+		return fmt.Sprintf("%s behind the broker", skew)
+	case skew < 0:
+		return fmt.Sprintf("%s ahead of the broker", -skew)
+	default:
+		return "in sync with the broker"
+	}
+}
+
+// printDoctorReport writes the report as a table
+func printDoctorReport(out io.Writer, report *doctorReport) error {
+	symbols := map[string]string{checkPass: "✅", checkWarn: "⚠️", checkFail: "❌"}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, check := range report.Checks {
+		_, _ = fmt.Fprintf(w, "%s %s\t%s\t%s\n", symbols[check.Status], strings.ToUpper(check.Status), check.Name, check.Message)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to display report: %w", err)
+	}
+
+	_, _ = fmt.Fprintf(out, "\n%d passed, %d warnings, %d failed\n", report.Summary.Pass, report.Summary.Warn, report.Summary.Fail)
+	return nil
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testJWT returns an unsigned JWT with the given claims
+func testJWT(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	return header + "." + base64.RawURLEncoding.EncodeToString(payload) + ".signature"
+}
+
+// newDoctorBroker starts a broker for doctor checks. date, if set, is sent
+// as the Date header of every response.
+func newDoctorBroker(t *testing.T, keys string, date time.Time) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/voidkey", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"serverVersion": "0.9.0", "features": ["mint", "keys", "idp-providers"]}`))
+	})
+	mux.HandleFunc("/credentials/idp-providers", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"name":"github","isDefault":true}]`))
+	})
+	mux.HandleFunc("/credentials/keys", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(keys))
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !date.IsZero() {
+			w.Header().Set("Date", date.UTC().Format(http.TimeFormat))
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// runDoctorCommand runs the doctor command against client with args
+func runDoctorCommand(client *VoidkeyClient, args ...string) (string, error) {
+	root := &cobra.Command{Use: "voidkey"}
+	root.AddCommand(doctor(client))
+
+	var stdout, stderr bytes.Buffer
+	root.SetOut(&stdout)
+	root.SetErr(&stderr)
+	root.SetArgs(append([]string{"doctor"}, args...))
+
+	err := root.Execute()
+	return stdout.String(), err
+}
+
+// checkStatuses returns the status of each check by name; for names used
+// by several checks the worst status wins
+func checkStatuses(report doctorReport) map[string]string {
+	rank := map[string]int{checkPass: 0, checkWarn: 1, checkFail: 2}
+	statuses := map[string]string{}
+	for _, check := range report.Checks {
+		if current, ok := statuses[check.Name]; !ok || rank[check.Status] > rank[current] {
+			statuses[check.Name] = check.Status
+		}
+	}
+	return statuses
+}
+
+func TestDoctor_Healthy(t *testing.T) {
+	server := newDoctorBroker(t, `["AWS_CREDENTIALS","MINIO_CREDENTIALS"]`, time.Time{})
+	client := NewVoidkeyClient(server.Client(), server.URL)
+	client.EnableDiscovery("")
+	token := testJWT(t, map[string]any{"aud": "voidkey", "iss": "https://issuer.example.com", "sub": "repo:acme/app", "exp": time.Now().Add(time.Hour).Unix()})
+
+	output, err := runDoctorCommand(client, "--token", token, "--output", "json")
+
+	assert.NoError(t, err)
+	var report doctorReport
+	require.NoError(t, json.Unmarshal([]byte(output), &report))
+	assert.Equal(t, map[string]string{
+		"broker": checkPass, "tls": checkPass, "idps": checkPass, "token": checkPass,
+		"token-claims": checkPass, "keys": checkPass, "clock": checkPass,
+	}, checkStatuses(report))
+	assert.Zero(t, report.Summary.Fail)
+	assert.NotContains(t, output, token)
+}
+
+func TestDoctor_Problems(t *testing.T) {
+	// The broker's clock is two minutes ahead of ours
+	server := newDoctorBroker(t, `[]`, time.Now().Add(2*time.Minute))
+	client := NewVoidkeyClient(server.Client(), server.URL)
+	client.EnableDiscovery("")
+	token := testJWT(t, map[string]any{"exp": time.Now().Add(-time.Minute).Unix()})
+
+	output, err := runDoctorCommand(client, "--token", token, "--idp", "auth0")
+
+	assert.ErrorIs(t, err, ErrCheckFailed)
+	assert.Contains(t, err.Error(), "checks failed")
+	assert.Contains(t, output, "IdP provider \"auth0\" is not configured on the broker")
+	assert.Contains(t, output, "token expired")
+	assert.Contains(t, output, "token has no audience")
+	assert.Contains(t, output, "not permitted to mint any keys")
+	assert.Contains(t, output, "behind the broker; token and credential expiry checks may fail")
+	assert.Contains(t, output, "❌ FAIL")
+	assert.Contains(t, output, "⚠️ WARN")
+}
+
+func TestDoctor_NoToken(t *testing.T) {
+	t.Setenv("OIDC_TOKEN", "")
+	t.Setenv("GITHUB_TOKEN", "")
+	server := newDoctorBroker(t, `[]`, time.Time{})
+	client := NewVoidkeyClient(server.Client(), server.URL)
+
+	output, err := runDoctorCommand(client, "-o", "json")
+
+	assert.Error(t, err)
+	var report doctorReport
+	require.NoError(t, json.Unmarshal([]byte(output), &report))
+	statuses := checkStatuses(report)
+	assert.Equal(t, checkFail, statuses["token"])
+	assert.Equal(t, checkWarn, statuses["keys"])
+}
+
+func TestDoctor_TLSFailure(t *testing.T) {
+	server := httptest.NewTLSServer(okHandler())
+	defer server.Close()
+
+	// A client that does not trust the test server's certificate
+	client := NewVoidkeyClient(&http.Client{}, server.URL)
+	client.EnableDiscovery("")
+
+	output, err := runDoctorCommand(client, "--token", "test-token", "-o", "json")
+
+	assert.Error(t, err)
+	var report doctorReport
+	require.NoError(t, json.Unmarshal([]byte(output), &report))
+	statuses := checkStatuses(report)
+	assert.Equal(t, checkFail, statuses["broker"])
+	assert.Equal(t, checkFail, statuses["tls"])
+}
+
+func TestDoctor_IgnoresDiscoveryCache(t *testing.T) {
+	server := newDoctorBroker(t, `[]`, time.Time{})
+	cacheDir := t.TempDir()
+
+	// Another command cached the broker's capabilities before it went away
+	client := NewVoidkeyClient(server.Client(), server.URL)
+	client.EnableDiscovery(cacheDir)
+	_, err := client.Capabilities(context.Background())
+	require.NoError(t, err)
+	server.Close()
+
+	client = NewVoidkeyClient(server.Client(), server.URL)
+	client.EnableDiscovery(cacheDir)
+	output, err := runDoctorCommand(client, "--token", "test-token", "-o", "json")
+
+	assert.ErrorIs(t, err, ErrCheckFailed)
+	var report doctorReport
+	require.NoError(t, json.Unmarshal([]byte(output), &report))
+	assert.Equal(t, checkFail, checkStatuses(report)["broker"])
+}
+
+func TestDoctor_InvalidOutput(t *testing.T) {
+	client := NewVoidkeyClient(&MockHTTPClient{}, "http://localhost:3000")
+
+	_, err := runDoctorCommand(client, "-o", "yaml")
+
+	assert.ErrorIs(t, err, ErrUsage)
+}
+
+func TestDescribeSkew(t *testing.T) {
+	assert.Equal(t, "in sync with the broker", describeSkew(300*time.Millisecond))
+	assert.Equal(t, "45s behind the broker", describeSkew(45*time.Second))
+	assert.Equal(t, "1m30s ahead of the broker", describeSkew(-90*time.Second))
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/spf13/cobra"
+	"github.com/voidkey-oss/cli/internal/credserver"
+)
+
+// defaultECSListen is the default listen address of serve ecs-creds, on a
+// random port as clients learn the full URI anyway
+const defaultECSListen = "127.0.0.1:0"
+
+// serveECS creates the serve ecs-creds command
+func serveECS(voidkeyClient *VoidkeyClient) *cobra.Command {
+	var opts serveOptions
+
+	cmd := &cobra.Command{
+		Use:   "ecs-creds",
+		Short: "Serve AWS credentials over an ECS container credentials endpoint",
+		Long: `Serve the credentials of an AWS key over an emulation of the ECS container
+credentials endpoint. Clients must present a random authorization token that
+is generated at startup.
+
+The environment variables that point AWS SDKs at the endpoint are printed to
+stdout as export statements:
+
+  export AWS_CONTAINER_CREDENTIALS_FULL_URI=http://127.0.0.1:41231/credentials
+  export AWS_CONTAINER_AUTHORIZATION_TOKEN=...
+
+AWS SDKs only accept plain HTTP endpoints on loopback addresses, so in
+docker-compose run it in a container whose network namespace the clients
+share (network_mode: "service:voidkey").
+
+Examples:
+  # Serve AWS_CREDENTIALS on a random port
+  voidkey serve ecs-creds --key AWS_CREDENTIALS
+
+  # Run a single command with the endpoint instead
+  voidkey exec --ecs-endpoint AWS_CREDENTIALS -- terraform apply`,
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			authToken, err := credserver.NewAuthToken()
+			if err != nil {
+				return err
+			}
+			redactor.Add(authToken)
+
+			source, listener, err := prepareServe(voidkeyClient, cobraCmd, opts)
+			if err != nil {
+				return err
+			}
+
+			_, _ = fmt.Fprintf(cobraCmd.OutOrStdout(), "export %s=%s\nexport %s=%s\n",
+				credserver.ECSFullURIEnv, ecsEndpointURL(listener.Addr()), credserver.ECSAuthTokenEnv, authToken)
+			return serveListener(cobraCmd, listener, credserver.NewECS(source, authToken, logFor(cobraCmd)), source)
+		},
+	}
+
+	addServeFlags(cmd, &opts, defaultECSListen)
+
+	return cmd
+}
+
+// ecsEndpointURL returns the URL clients reach the ECS endpoint listening
+// on addr at. Wildcard addresses are reached over loopback.
+func ecsEndpointURL(addr net.Addr) string {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return "http://" + addr.String() + credserver.ECSCredentialsPath
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	return "http://" + net.JoinHostPort(host, port) + credserver.ECSCredentialsPath
+}
@@ -0,0 +1,250 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+)
+
+// Error kinds. Those of the broker client are defined by package voidkey.
+// Use errors.Is to tell them apart.
+var (
+	// ErrUnauthorized means the broker rejected the OIDC token
+	ErrUnauthorized = voidkey.ErrUnauthorized
+	// ErrForbiddenKey means the identity is not permitted to use a requested key
+	ErrForbiddenKey = voidkey.ErrForbiddenKey
+	// ErrBrokerUnavailable means the broker could not be reached or failed
+	// to handle the request
+	ErrBrokerUnavailable = voidkey.ErrBrokerUnavailable
+	// ErrInvalidResponse means the broker answered with something the CLI
+	// could not understand
+	ErrInvalidResponse = voidkey.ErrInvalidResponse
+	// ErrRequestRejected means the broker rejected the request for another
+	// reason, e.g. an unknown IdP or key
+	ErrRequestRejected = voidkey.ErrRequestRejected
+	// ErrPartialMint means some of the requested keys were minted and
+	// others were not
+	ErrPartialMint = errors.New("partial mint")
+	// ErrUnsupportedFeature means the broker is too old for a feature
+	ErrUnsupportedFeature = voidkey.ErrUnsupportedFeature
+	// ErrUsage means the command was invoked incorrectly
+	ErrUsage = errors.New("usage error")
+	// ErrCheckFailed means a voidkey doctor check failed
+	ErrCheckFailed = errors.New("check failed")
+)
+
+// Broker error types, defined by package voidkey
+type (
+	BrokerError = voidkey.BrokerError
+	KeyFailure  = voidkey.KeyFailure
+)
+
+// Exit codes of the voidkey CLI
+const (
+	exitOK              = 0
+	exitError           = 1
+	exitUsage           = 2
+	exitUnauthorized    = 3
+	exitForbiddenKey    = 4
+	exitUnavailable     = 5
+	exitInvalidResponse = 6
+	exitRejected        = 7
+	exitPartial         = 8
+	exitUnsupported     = 9
+	exitCheckFailed     = 10
+	exitInterrupted     = 130
+)
+
+// errorClasses maps error kinds to exit codes and the stable names used in
+// --error-format json output, checked in order
+var errorClasses = []struct {
+	kind error
+	exit int
+	name string
+}{
+	{context.Canceled, exitInterrupted, "interrupted"},
+	{ErrUsage, exitUsage, "usage"},
+	{ErrUnauthorized, exitUnauthorized, "unauthorized"},
+	{ErrForbiddenKey, exitForbiddenKey, "forbidden_key"},
+	{ErrBrokerUnavailable, exitUnavailable, "broker_unavailable"},
+	{ErrInvalidResponse, exitInvalidResponse, "invalid_response"},
+	{ErrRequestRejected, exitRejected, "request_rejected"},
+	{ErrPartialMint, exitPartial, "partial_mint"},
+	{ErrUnsupportedFeature, exitUnsupported, "unsupported_feature"},
+	{ErrCheckFailed, exitCheckFailed, "check_failed"},
+}
+
+// classifyError returns the exit code and error name for err
+func classifyError(err error) (int, string) {
+	if err == nil {
+		return exitOK, ""
+	}
+	var childErr *childExitError
+	if errors.As(err, &childErr) {
+		return childErr.code, "child_exit"
+	}
+	for _, class := range errorClasses {
+		if errors.Is(err, class.kind) {
+			return class.exit, class.name
+		}
+	}
+	return exitError, "error"
+}
+
+// Hints printed with broker errors
+const (
+	hintListKeys     = "run 'voidkey list-keys' to see which keys your identity is permitted to mint"
+	hintUnauthorized = "check that the OIDC token is valid, not expired and issued for the broker's audience"
+)
+
+// hintFor suggests how to fix a broker error
+func hintFor(e *BrokerError) string {
+	switch {
+	case errors.Is(e, ErrForbiddenKey), len(e.KeyFailures) > 0:
+		return hintListKeys
+	case errors.Is(e, ErrUnauthorized):
+		return hintUnauthorized
+	}
+	return ""
+}
+
+// hintedError adds a hint on how to fix an error to its message
+type hintedError struct {
+	err  error
+	hint string
+}
+
+func (e *hintedError) Error() string { return e.err.Error() + "\nHint: " + e.hint }
+func (e *hintedError) Unwrap() error { return e.err }
+
+// withHint returns err with a hint if it is a broker error there is one for
+func withHint(err error) error {
+	var brokerErr *BrokerError
+	if !errors.As(err, &brokerErr) {
+		return err
+	}
+	if hint := hintFor(brokerErr); hint != "" {
+		return &hintedError{err: err, hint: hint}
+	}
+	return err
+}
+
+// MintError is returned when the broker refused some of the keys of a mint
+// request
+type MintError struct {
+	// Requested is the number of keys in the broker's response
+	Requested int
+	// KeyFailures lists the keys that were not minted and why
+	KeyFailures []KeyFailure
+	// kind is one of the Err* sentinels
+	kind error
+}
+
+func (e *MintError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "failed to mint %d of %d keys", len(e.KeyFailures), e.Requested)
+	for _, failure := range e.KeyFailures {
+		fmt.Fprintf(&b, "\n  - %s: %s", failure.Key, failure.Reason)
+	}
+	fmt.Fprintf(&b, "\nHint: %s", hintListKeys)
+	return b.String()
+}
+
+// Unwrap returns the kind of the error
+func (e *MintError) Unwrap() error {
+	return e.kind
+}
+
+// newMintError builds the error for a mint with per-key failures. Partial
+// results are reported as ErrPartialMint; otherwise the kind is taken from
+// the failure codes, as for a whole-request failure.
+func newMintError(requested int, failures []KeyFailure, partial bool) *MintError {
+	e := &MintError{Requested: requested, KeyFailures: failures, kind: ErrRequestRejected}
+	if partial {
+		e.kind = ErrPartialMint
+		return e
+	}
+	for _, failure := range failures {
+		if kind := voidkey.ErrorKind(failure.Code); kind != nil {
+			e.kind = kind
+			break
+		}
+	}
+	return e
+}
+
+// childExitError is returned when a command run by the CLI exits non-zero.
+// The CLI exits with the same code without printing an error, as the
+// command has reported its failure itself.
+type childExitError struct {
+	code int
+}
+
+func (e *childExitError) Error() string {
+	return fmt.Sprintf("command exited with code %d", e.code)
+}
+
+// usageErrorf returns an ErrUsage error with the formatted message
+func usageErrorf(format string, args ...any) error {
+	return voidkey.WrapKind(ErrUsage, fmt.Errorf(format, args...))
+}
+
+// Error output formats for --error-format
+const (
+	errorFormatText = "text"
+	errorFormatJSON = "json"
+)
+
+// errorOutput is the JSON document printed for --error-format json
+type errorOutput struct {
+	Error struct {
+		Code       string       `json:"code"`
+		Message    string       `json:"message"`
+		ExitCode   int          `json:"exitCode"`
+		Status     int          `json:"status,omitempty"`
+		BrokerCode string       `json:"brokerCode,omitempty"`
+		Keys       []KeyFailure `json:"keys,omitempty"`
+		Hint       string       `json:"hint,omitempty"`
+	} `json:"error"`
+}
+
+// printError writes err to w in the given format
+func printError(w io.Writer, err error, format string) {
+	exit, name := classifyError(err)
+
+	if format != errorFormatJSON {
+		_, _ = fmt.Fprintln(w, "Error:", err.Error())
+		return
+	}
+
+	var out errorOutput
+	out.Error.Code = name
+	out.Error.Message = err.Error()
+	out.Error.ExitCode = exit
+
+	var brokerErr *BrokerError
+	if errors.As(err, &brokerErr) {
+		out.Error.Status = brokerErr.StatusCode
+		out.Error.BrokerCode = brokerErr.Code
+		out.Error.Keys = brokerErr.KeyFailures
+	}
+
+	var hinted *hintedError
+	if errors.As(err, &hinted) {
+		out.Error.Hint = hinted.hint
+	}
+
+	var mintErr *MintError
+	if errors.As(err, &mintErr) {
+		out.Error.Keys = mintErr.KeyFailures
+		out.Error.Hint = hintListKeys
+	}
+
+	data, _ := json.Marshal(out)
+	_, _ = fmt.Fprintln(w, string(data))
+}
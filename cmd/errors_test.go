@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		expectedExit int
+		expectedName string
+	}{
+		{name: "nil", err: nil, expectedExit: exitOK, expectedName: ""},
+		{name: "generic", err: errors.New("boom"), expectedExit: exitError, expectedName: "error"},
+		{name: "usage", err: usageErrorf("bad flag"), expectedExit: exitUsage, expectedName: "usage"},
+		{name: "unauthorized", err: &BrokerError{StatusCode: http.StatusUnauthorized}, expectedExit: exitUnauthorized, expectedName: "unauthorized"},
+		{name: "forbidden", err: &BrokerError{StatusCode: http.StatusForbidden}, expectedExit: exitForbiddenKey, expectedName: "forbidden_key"},
+		{name: "unavailable", err: &BrokerError{StatusCode: http.StatusBadGateway}, expectedExit: exitUnavailable, expectedName: "broker_unavailable"},
+		{name: "rejected", err: &BrokerError{StatusCode: http.StatusNotFound}, expectedExit: exitRejected, expectedName: "request_rejected"},
+		{name: "invalid response", err: voidkey.WrapKind(ErrInvalidResponse, errors.New("bad json")), expectedExit: exitInvalidResponse, expectedName: "invalid_response"},
+		{name: "wrapped", err: fmt.Errorf("failed to list IdP providers: %w", &BrokerError{StatusCode: http.StatusUnauthorized}), expectedExit: exitUnauthorized, expectedName: "unauthorized"},
+		{name: "partial mint", err: newMintError(2, []KeyFailure{{Key: "AWS_PROD", Reason: "denied"}}, true), expectedExit: exitPartial, expectedName: "partial_mint"},
+		{name: "check failed", err: voidkey.WrapKind(ErrCheckFailed, errors.New("1 of 7 checks failed")), expectedExit: exitCheckFailed, expectedName: "check_failed"},
+		{name: "interrupted", err: voidkey.WrapKind(ErrBrokerUnavailable, fmt.Errorf("request failed: %w", context.Canceled)), expectedExit: exitInterrupted, expectedName: "interrupted"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exit, name := classifyError(tt.err)
+			assert.Equal(t, tt.expectedExit, exit)
+			assert.Equal(t, tt.expectedName, name)
+		})
+	}
+}
+
+func TestVoidkeyClient_TypedErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		expected error
+	}{
+		{name: "unauthorized", status: http.StatusUnauthorized, expected: ErrUnauthorized},
+		{name: "forbidden", status: http.StatusForbidden, expected: ErrForbiddenKey},
+		{name: "unavailable", status: http.StatusServiceUnavailable, expected: ErrBrokerUnavailable},
+		{name: "rejected", status: http.StatusUnprocessableEntity, expected: ErrRequestRejected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := &MockHTTPClient{}
+			client := NewVoidkeyClient(mockClient, "http://localhost:3000")
+			MockErrorResponse(mockClient, "POST", "http://localhost:3000/credentials/mint", tt.status, "nope")
+
+			_, err := client.MintKeys(context.Background(), "test-token", "", []string{"A"}, 0, false)
+
+			assert.ErrorIs(t, err, tt.expected)
+			mockClient.AssertExpectations(t)
+		})
+	}
+
+	t.Run("connection error", func(t *testing.T) {
+		mockClient := &MockHTTPClient{}
+		client := NewVoidkeyClient(mockClient, "http://localhost:3000")
+		mockClient.On("Do", requestMatching(http.MethodGet, "http://localhost:3000/credentials/idp-providers")).
+			Return((*http.Response)(nil), errors.New("connection refused"))
+
+		_, err := client.ListIdpProviders(context.Background())
+
+		assert.ErrorIs(t, err, ErrBrokerUnavailable)
+		assert.Contains(t, err.Error(), "failed to connect to broker server")
+	})
+
+	t.Run("invalid response", func(t *testing.T) {
+		mockClient := &MockHTTPClient{}
+		client := NewVoidkeyClient(mockClient, "http://localhost:3000")
+		MockSuccessfulListResponse(mockClient, "http://localhost:3000", nil)
+		mockClient.ExpectedCalls[0].ReturnArguments[0] = CreateMockHTTPResponse(http.StatusOK, "<html>")
+
+		_, err := client.ListIdpProviders(context.Background())
+
+		assert.ErrorIs(t, err, ErrInvalidResponse)
+	})
+}
+
+func TestPrintError(t *testing.T) {
+	err := fmt.Errorf("failed to list IdP providers: %w",
+		&BrokerError{StatusCode: http.StatusUnauthorized, Code: "invalid_token", Message: "invalid_token"})
+
+	var text bytes.Buffer
+	printError(&text, err, errorFormatText)
+	assert.Equal(t, "Error: "+err.Error()+"\n", text.String())
+
+	var out bytes.Buffer
+	printError(&out, err, errorFormatJSON)
+
+	var parsed errorOutput
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &parsed))
+	assert.Equal(t, "unauthorized", parsed.Error.Code)
+	assert.Equal(t, exitUnauthorized, parsed.Error.ExitCode)
+	assert.Equal(t, http.StatusUnauthorized, parsed.Error.Status)
+	assert.Equal(t, "invalid_token", parsed.Error.BrokerCode)
+	assert.Equal(t, err.Error(), parsed.Error.Message)
+}
+
+func TestRun_ExitCodes(t *testing.T) {
+	original := errorFormat
+	defer func() { errorFormat = original }()
+
+	newRoot := func(client *VoidkeyClient) (*cobra.Command, *bytes.Buffer) {
+		root := &cobra.Command{Use: "voidkey", SilenceUsage: true}
+		root.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+			return voidkey.WrapKind(ErrUsage, err)
+		})
+		root.AddCommand(mintCreds(client))
+
+		var stderr bytes.Buffer
+		root.SetOut(&bytes.Buffer{})
+		root.SetErr(&stderr)
+		return root, &stderr
+	}
+
+	t.Run("unknown flag", func(t *testing.T) {
+		errorFormat = errorFormatText
+		root, stderr := newRoot(NewVoidkeyClient(&MockHTTPClient{}, "http://localhost:3000"))
+		root.SetArgs([]string{"mint", "--no-such-flag"})
+
+		assert.Equal(t, exitUsage, run(context.Background(), root))
+		assert.Contains(t, stderr.String(), "Error: unknown flag")
+	})
+
+	t.Run("unauthorized as json", func(t *testing.T) {
+		errorFormat = errorFormatJSON
+		mockClient := &MockHTTPClient{}
+		MockErrorResponse(mockClient, "POST", "http://localhost:3000/credentials/mint", http.StatusUnauthorized, "bad token")
+		root, stderr := newRoot(NewVoidkeyClient(mockClient, "http://localhost:3000"))
+		root.SetArgs([]string{"mint", "--token", "test-token", "--keys", "A"})
+
+		assert.Equal(t, exitUnauthorized, run(context.Background(), root))
+
+		var parsed errorOutput
+		lines := bytes.Split(bytes.TrimSpace(stderr.Bytes()), []byte("\n"))
+		assert.NoError(t, json.Unmarshal(lines[len(lines)-1], &parsed))
+		assert.Equal(t, "unauthorized", parsed.Error.Code)
+	})
+}
+
+func TestWithHint(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		expectedHint string
+	}{
+		{name: "nil", err: nil},
+		{name: "generic", err: errors.New("boom")},
+		{name: "key failures", err: &BrokerError{StatusCode: http.StatusBadRequest, KeyFailures: []KeyFailure{{Key: "AWS_PROD"}}}, expectedHint: hintListKeys},
+		{name: "forbidden", err: &BrokerError{StatusCode: http.StatusForbidden}, expectedHint: hintListKeys},
+		{name: "unauthorized code", err: &BrokerError{StatusCode: http.StatusBadRequest, Code: "token_expired"}, expectedHint: hintUnauthorized},
+		{name: "unavailable", err: &BrokerError{StatusCode: http.StatusBadGateway}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := withHint(tt.err)
+
+			if tt.expectedHint == "" {
+				assert.Equal(t, tt.err, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.err.Error()+"\nHint: "+tt.expectedHint, err.Error())
+		})
+	}
+}
+
+func TestPrintError_Hint(t *testing.T) {
+	err := withHint(&BrokerError{StatusCode: http.StatusForbidden, Message: "keys denied"})
+
+	var out bytes.Buffer
+	printError(&out, err, errorFormatJSON)
+
+	var parsed errorOutput
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &parsed))
+	assert.Equal(t, "forbidden_key", parsed.Error.Code)
+	assert.Equal(t, hintListKeys, parsed.Error.Hint)
+	assert.Equal(t, err.Error(), parsed.Error.Message)
+}
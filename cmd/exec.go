@@ -0,0 +1,242 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/voidkey-oss/cli/internal/credserver"
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+)
+
+// execWaitDelay is how long a command gets to exit after it was asked to
+// stop, before it is killed
+const execWaitDelay = 10 * time.Second
+
+// awsCredentialVars are inherited variables that would take precedence
+// over an ECS endpoint in the AWS SDKs' credential chain
+var awsCredentialVars = []string{
+	"AWS_ACCESS_KEY_ID",
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SESSION_TOKEN",
+	"AWS_CONTAINER_CREDENTIALS_RELATIVE_URI",
+}
+
+// execOptions holds the flag values of a single exec invocation
+type execOptions struct {
+	token     string
+	idpName   string
+	keys      []string
+	all       bool
+	duration  int
+	collision string
+	// ecsKey is the AWS key served to the command over an ECS endpoint
+	ecsKey        string
+	refreshBefore time.Duration
+}
+
+// execCommand creates a new exec command with dependency injection
+func execCommand(voidkeyClient *VoidkeyClient) *cobra.Command {
+	var opts execOptions
+
+	cmd := &cobra.Command{
+		Use:   "exec [flags] -- command [args...]",
+		Short: "Run a command with minted credentials",
+		Long: `Run a command with minted credentials in its environment, without writing
+them anywhere else. The command's exit code is passed through.
+
+Credentials set as environment variables stay the same while the command
+runs. For long-running commands, --ecs-endpoint serves an AWS key over a
+local ECS container credentials endpoint instead, which AWS SDKs query
+again before the credentials expire.
+
+Examples:
+  # Run a command with the credentials of two keys
+  voidkey exec --keys MINIO_CREDENTIALS,AWS_CREDENTIALS -- ./deploy.sh
+
+  # Serve AWS credentials over an ECS endpoint that refreshes them
+  voidkey exec --ecs-endpoint AWS_CREDENTIALS -- terraform apply`,
+		Args:        cobra.MinimumNArgs(1),
+		Annotations: map[string]string{agentAnnotation: "true"},
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			return runExec(voidkeyClient, cobraCmd, opts, args)
+		},
+	}
+
+	// Flags after the command belong to it
+	cmd.Flags().SetInterspersed(false)
+
+	cmd.Flags().StringVar(&opts.token, "token", "", "OIDC token for authentication (default from $OIDC_TOKEN or $GITHUB_TOKEN)")
+	cmd.Flags().StringVar(&opts.idpName, "idp", "", "IdP provider name to use (uses server default if not specified)")
+	cmd.Flags().StringSliceVar(&opts.keys, "keys", nil, "Comma-separated list of keys whose credentials are set as environment variables")
+	cmd.Flags().BoolVar(&opts.all, "all", false, "Set the credentials of all available keys as environment variables")
+	cmd.Flags().IntVar(&opts.duration, "duration", 0, "Duration in seconds to override default credential lifetime")
+	cmd.Flags().StringVar(&opts.collision, "on-collision", collisionError, "How to handle environment variables set by more than one key (error|prefix)")
+	cmd.Flags().StringVar(&opts.ecsKey, "ecs-endpoint", "", "AWS key to serve to the command over a local ECS container credentials endpoint")
+	cmd.Flags().DurationVar(&opts.refreshBefore, "refresh-before", voidkey.DefaultRefreshBefore, "How long before they expire --ecs-endpoint credentials are refreshed")
+
+	return cmd
+}
+
+// runExec mints the credentials of opts and runs args with them
+func runExec(client *VoidkeyClient, cmd *cobra.Command, opts execOptions, args []string) error {
+	if len(opts.keys) == 0 && !opts.all && opts.ecsKey == "" {
+		return usageErrorf("must specify keys to mint (--keys or --all) or an AWS key to serve (--ecs-endpoint)")
+	}
+	switch opts.collision {
+	case "", collisionError, collisionPrefix:
+	default:
+		return usageErrorf("invalid --on-collision %q (must be %s or %s)", opts.collision, collisionError, collisionPrefix)
+	}
+
+	token, err := resolveClientToken(client, cmd, opts.token, opts.idpName)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(commandContext(cmd))
+	defer cancel()
+
+	env := os.Environ()
+
+	if opts.ecsKey != "" {
+		endpoint, authToken, err := startECSEndpoint(ctx, client, cmd, token, opts)
+		if err != nil {
+			return err
+		}
+		env = unsetEnv(env, awsCredentialVars)
+		env = setEnv(env, credserver.ECSFullURIEnv, endpoint)
+		env = setEnv(env, credserver.ECSAuthTokenEnv, authToken)
+	}
+
+	if len(opts.keys) > 0 || opts.all {
+		vars, err := mintEnvVars(ctx, client, cmd, token, opts)
+		if err != nil {
+			return err
+		}
+		for _, v := range vars {
+			env = setEnv(env, v.name, v.value)
+		}
+	}
+
+	return runChild(ctx, cmd, args, env)
+}
+
+// mintEnvVars mints the keys of opts and returns their environment
+// variables. Any refused key fails the command.
+func mintEnvVars(ctx context.Context, client *VoidkeyClient, cmd *cobra.Command, token string, opts execOptions) ([]envVar, error) {
+	keyResponses, err := client.MintKeys(ctx, token, opts.idpName, opts.keys, opts.duration, opts.all)
+	if err != nil {
+		return nil, err
+	}
+
+	keyNames := orderKeyNames(keyResponses, opts.keys, keyOrderName)
+	minted, failures := splitKeyResults(keyResponses, keyNames)
+	if len(failures) > 0 {
+		return nil, newMintError(len(keyNames), failures, false)
+	}
+
+	vars, err := resolveEnvVars(keyResponses, minted, envOptions{collision: opts.collision, keys: cfg.Keys})
+	if err != nil {
+		return nil, err
+	}
+	progressf(cmd, "🔑 Minted %d keys with %d environment variables", len(minted), len(vars))
+	return vars, nil
+}
+
+// startECSEndpoint serves the --ecs-endpoint key on a random loopback port
+// until ctx is done, and returns the endpoint's URL and auth token
+func startECSEndpoint(ctx context.Context, client *VoidkeyClient, cmd *cobra.Command, token string, opts execOptions) (string, string, error) {
+	authToken, err := credserver.NewAuthToken()
+	if err != nil {
+		return "", "", err
+	}
+	redactor.Add(authToken)
+
+	source, listener, err := prepareServe(client, cmd, serveOptions{
+		token:         token,
+		idpName:       opts.idpName,
+		key:           opts.ecsKey,
+		listen:        defaultECSListen,
+		duration:      opts.duration,
+		refreshBefore: opts.refreshBefore,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	logger := logFor(cmd)
+	go func() {
+		if err := credserver.Serve(ctx, listener, credserver.NewECS(source, authToken, logger), source, logger); err != nil {
+			logger.Warn("credentials endpoint failed", "error", err)
+		}
+	}()
+	logger.Debug("serving credentials endpoint", "key", opts.ecsKey, "addr", listener.Addr())
+	return ecsEndpointURL(listener.Addr()), authToken, nil
+}
+
+// runChild runs args with env and the command's standard streams. When ctx
+// is cancelled, e.g. by SIGTERM, the child is asked to stop too.
+func runChild(ctx context.Context, cmd *cobra.Command, args []string, env []string) error {
+	child := exec.CommandContext(ctx, args[0], args[1:]...)
+	child.Env = env
+	child.Stdin = cmd.InOrStdin()
+	child.Stdout = cmd.OutOrStdout()
+	child.Stderr = cmd.ErrOrStderr()
+	child.Cancel = func() error {
+		// Not every platform can deliver SIGTERM
+		if err := child.Process.Signal(syscall.SIGTERM); err != nil {
+			return child.Process.Kill()
+		}
+		return nil
+	}
+	child.WaitDelay = execWaitDelay
+
+	// The child reports its own failures
+	cmd.SilenceUsage = true
+	err := child.Run()
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if code := exitErr.ExitCode(); code > 0 {
+			return &childExitError{code: code}
+		}
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to run %s: %w", args[0], err)
+	}
+	return nil
+}
+
+// setEnv returns env with the variable name set to value
+func setEnv(env []string, name, value string) []string {
+	return append(unsetEnv(env, []string{name}), name+"="+value)
+}
+
+// unsetEnv returns env without the given variables
+func unsetEnv(env []string, names []string) []string {
+	kept := env[:0:0]
+	for _, entry := range env {
+		name, _, _ := strings.Cut(entry, "=")
+		unset := false
+		for _, n := range names {
+			if name == n {
+				unset = true
+				break
+			}
+		}
+		if !unset {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
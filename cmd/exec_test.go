@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/voidkey-oss/cli/internal/credserver"
+	"github.com/voidkey-oss/cli/pkg/voidkey/voidkeytest"
+)
+
+// helperProcessEnv marks the test binary as started by helperCommand
+const helperProcessEnv = "VOIDKEY_TEST_HELPER_PROCESS"
+
+// helperCommand returns the args that run the test binary as a child
+// process acting out TestExecHelperProcess with the given args
+func helperCommand(t *testing.T, args ...string) []string {
+	t.Setenv(helperProcessEnv, "1")
+	return append([]string{os.Args[0], "-test.run=^TestExecHelperProcess$", "--"}, args...)
+}
+
+// TestExecHelperProcess is the child process of the exec tests. It prints
+// environment variables, fetches credentials from an ECS endpoint or exits
+// with a code, depending on its args.
+func TestExecHelperProcess(t *testing.T) {
+	if os.Getenv(helperProcessEnv) != "1" {
+		return
+	}
+
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	args = args[1:]
+
+	switch args[0] {
+	case "env":
+		for _, name := range args[1:] {
+			fmt.Printf("%s=%s\n", name, os.Getenv(name))
+		}
+	case "ecs":
+		req, _ := http.NewRequest(http.MethodGet, os.Getenv(credserver.ECSFullURIEnv), nil)
+		req.Header.Set("Authorization", os.Getenv(credserver.ECSAuthTokenEnv))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		defer resp.Body.Close()
+		var credentials map[string]string
+		_ = json.NewDecoder(resp.Body).Decode(&credentials)
+		fmt.Printf("AccessKeyId=%s\n", credentials["AccessKeyId"])
+	case "exit":
+		code, _ := strconv.Atoi(args[1])
+		os.Exit(code)
+	}
+	os.Exit(0)
+}
+
+func TestRunExec_Keys(t *testing.T) {
+	_, client := newServeBroker(t)
+	cmd, stdout, _ := SetupTestCommand()
+	opts := execOptions{token: voidkeytest.Token, keys: []string{"AWS_CREDENTIALS"}}
+
+	err := runExec(client, cmd, opts, helperCommand(t, "env", "AWS_ACCESS_KEY_ID", "AWS_SESSION_TOKEN"))
+
+	require.NoError(t, err)
+	assert.Equal(t, "AWS_ACCESS_KEY_ID=AKIA-1\nAWS_SESSION_TOKEN=session-1\n", stdout.String())
+}
+
+func TestRunExec_ECSEndpoint(t *testing.T) {
+	broker, client := newServeBroker(t)
+	cmd, stdout, _ := SetupTestCommand()
+	t.Setenv("AWS_ACCESS_KEY_ID", "inherited")
+	opts := execOptions{token: voidkeytest.Token, ecsKey: "AWS_CREDENTIALS"}
+
+	err := runExec(client, cmd, opts, helperCommand(t, "ecs"))
+	require.NoError(t, err)
+	assert.Equal(t, "AccessKeyId=AKIA-1\n", stdout.String())
+	assert.Equal(t, 1, broker.Mints("AWS_CREDENTIALS"))
+
+	stdout.Reset()
+	err = runExec(client, cmd, opts, helperCommand(t, "env", "AWS_ACCESS_KEY_ID"))
+	require.NoError(t, err)
+	assert.Equal(t, "AWS_ACCESS_KEY_ID=\n", stdout.String())
+}
+
+func TestRunExec_ExitCode(t *testing.T) {
+	_, client := newServeBroker(t)
+	cmd, _, _ := SetupTestCommand()
+	opts := execOptions{token: voidkeytest.Token, keys: []string{"AWS_CREDENTIALS"}}
+
+	err := runExec(client, cmd, opts, helperCommand(t, "exit", "3"))
+
+	var childErr *childExitError
+	require.ErrorAs(t, err, &childErr)
+	assert.Equal(t, 3, childErr.code)
+	code, kind := classifyError(err)
+	assert.Equal(t, 3, code)
+	assert.Equal(t, "child_exit", kind)
+}
+
+func TestRunExec_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     execOptions
+		args     []string
+		expected error
+		message  string
+	}{
+		{
+			name:     "nothing to mint",
+			opts:     execOptions{token: voidkeytest.Token},
+			args:     []string{"true"},
+			expected: ErrUsage,
+			message:  "must specify keys to mint",
+		},
+		{
+			name:     "invalid collision mode",
+			opts:     execOptions{token: voidkeytest.Token, all: true, collision: "merge"},
+			args:     []string{"true"},
+			expected: ErrUsage,
+			message:  `invalid --on-collision "merge"`,
+		},
+		{
+			name:    "unknown key",
+			opts:    execOptions{token: voidkeytest.Token, keys: []string{"UNKNOWN"}},
+			args:    []string{"true"},
+			message: "UNKNOWN",
+		},
+		{
+			name:    "missing command",
+			opts:    execOptions{token: voidkeytest.Token, keys: []string{"AWS_CREDENTIALS"}},
+			args:    []string{"voidkey-test-no-such-command"},
+			message: "failed to run voidkey-test-no-such-command",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, client := newServeBroker(t)
+			cmd, _, _ := SetupTestCommand()
+
+			err := runExec(client, cmd, tt.opts, tt.args)
+
+			require.Error(t, err)
+			if tt.expected != nil {
+				assert.ErrorIs(t, err, tt.expected)
+			}
+			assert.Contains(t, err.Error(), tt.message)
+		})
+	}
+}
+
+func TestEnvHelpers(t *testing.T) {
+	env := []string{"A=1", "B=2", "C=3=4"}
+
+	assert.Equal(t, []string{"B=2"}, unsetEnv(env, []string{"A", "C"}))
+	assert.Equal(t, []string{"A=1", "C=3=4", "B=5"}, setEnv(env, "B", "5"))
+	assert.Equal(t, []string{"A=1", "B=2", "C=3=4"}, env)
+}
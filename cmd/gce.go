@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/voidkey-oss/cli/internal/credserver"
+)
+
+// defaultGCEListen is the default listen address of serve gce-metadata
+const defaultGCEListen = "127.0.0.1:1339"
+
+// serveGCE creates the serve gce-metadata command
+func serveGCE(voidkeyClient *VoidkeyClient) *cobra.Command {
+	var opts serveOptions
+	var email string
+
+	cmd := &cobra.Command{
+		Use:   "gce-metadata",
+		Short: "Serve a GCP access token over a GCE metadata server endpoint",
+		Long: `Serve the access token of a GCP key over an emulation of the GCE metadata
+server, as the token of the instance's default service account. Like on GCE,
+requests must carry the Metadata-Flavor: Google header. The project ID is
+served too if the key returns GOOGLE_CLOUD_PROJECT.
+
+Point Google client libraries and gcloud at it with:
+
+  export GCE_METADATA_HOST=127.0.0.1:1339
+  export GCE_METADATA_IP=127.0.0.1:1339
+
+Scopes requested by clients are ignored; the token has the scopes the key
+is configured with on the broker.
+
+Examples:
+  # Serve GCP_CREDENTIALS on the default address
+  voidkey serve gce-metadata --key GCP_CREDENTIALS
+
+  # Report the email of the service account the token belongs to
+  voidkey serve gce-metadata --key GCP_CREDENTIALS --email deploy@my-project.iam.gserviceaccount.com`,
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			source, listener, err := prepareServe(voidkeyClient, cobraCmd, opts)
+			if err != nil {
+				return err
+			}
+			return serveListener(cobraCmd, listener, credserver.NewGCE(source, email, logFor(cobraCmd)), source)
+		},
+	}
+
+	addServeFlags(cmd, &opts, defaultGCEListen)
+	cmd.Flags().StringVar(&email, "email", "default", "Email of the service account reported to clients")
+
+	return cmd
+}
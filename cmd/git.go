@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+)
+
+// Credential fields a Git login is taken from unless configured otherwise
+const (
+	defaultGitUsernameField = "GIT_USERNAME"
+	defaultGitPasswordField = "GIT_PASSWORD"
+)
+
+// gitCredentialRequest holds the attributes Git describes a credential with
+type gitCredentialRequest struct {
+	Protocol string
+	Host     string
+	// Path is the repository path, only sent with credential.useHttpPath
+	Path string
+}
+
+// gitCredential creates the git-credential command
+func gitCredential(voidkeyClient *VoidkeyClient) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "git-credential <get|store|erase>",
+		Short: "Act as a Git credential helper for hosts backed by Voidkey keys",
+		Long: `Act as a Git credential helper, so git clone, fetch and push over HTTPS
+authenticate with short-lived tokens minted from Voidkey keys, such as GitHub
+App installation tokens. The hosts, optionally followed by a repository path
+prefix, and the keys and credential fields their logins are taken from are
+configured in the configuration file:
+
+  git:
+    hosts:
+      github.com/my-org:
+        key: GITHUB_CREDENTIALS
+        username: x-access-token       # fixed username, token only
+        passwordField: GITHUB_TOKEN
+      gitlab.example.com:
+        key: GITLAB_CREDENTIALS        # GIT_USERNAME and GIT_PASSWORD
+
+The longest matching path prefix wins. Git only sends repository paths when
+credential.useHttpPath is set; without it only hosts configured without a
+path match. Select the helper in the Git configuration:
+
+  git config --global credential.https://github.com.helper '!voidkey git-credential'
+  git config --global credential.https://github.com.useHttpPath true
+
+Credentials are taken from the agent if one runs. Otherwise they are cached
+in the user's cache directory, readable only by the user, until shortly
+before they expire, so fetches and pushes do not mint every time. Only
+HTTPS is answered. Git erases logins the server rejected, which deletes the
+cached login so the next get mints a new one. store is ignored, as there is
+nothing to store.`,
+		Args:        cobra.ExactArgs(1),
+		Annotations: map[string]string{agentAnnotation: "true"},
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			cobraCmd.SilenceUsage = true
+			return runGitCredential(voidkeyClient, cobraCmd, args[0])
+		},
+	}
+
+	return cmd
+}
+
+// runGitCredential runs a single action of the credential helper protocol,
+// reading the credential's attributes from stdin
+func runGitCredential(client *VoidkeyClient, cmd *cobra.Command, action string) error {
+	request, err := readGitCredentialRequest(cmd.InOrStdin())
+	if err != nil {
+		return err
+	}
+
+	if action != "get" && action != "erase" {
+		// Logins are minted on demand, so there is nothing to store. Git
+		// expects unknown actions to be ignored as well.
+		logFor(cmd).Debug("ignoring git credential action", "action", action)
+		return nil
+	}
+
+	if request.Protocol != "https" {
+		logFor(cmd).Debug("not answering for protocol", "protocol", request.Protocol, "host", request.Host)
+		return nil
+	}
+	config, ok := gitHostConfig(cfg.Git.Hosts, request.Host, request.Path)
+	if !ok {
+		// Git falls back to the next helper, or prompts, if nothing is
+		// printed
+		logFor(cmd).Debug("no key configured for git host", "host", request.Host, "path", request.Path)
+		return nil
+	}
+	if config.Key == "" {
+		return usageErrorf("no key configured for git host %s", request.Host)
+	}
+	if action == "erase" {
+		// Git erases logins the server rejected
+		return forgetKey(client, cmd, config.Key)
+	}
+
+	login, err := mintLogin(client, cmd, config, defaultGitUsernameField, defaultGitPasswordField)
+	if err != nil {
+		return err
+	}
+	if strings.ContainsAny(login.Username+login.Password, "\n\x00") {
+		return voidkey.WrapKind(ErrInvalidResponse, fmt.Errorf("key %s has a login Git cannot accept", config.Key))
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "username=%s\npassword=%s\n", login.Username, login.Password)
+	if expiry, ok := login.Response.Expiry(); ok {
+		// Lets Git 2.41 and later discard the token once it expired
+		fmt.Fprintf(&out, "password_expiry_utc=%d\n", expiry.Unix())
+	}
+	_, err = io.WriteString(cmd.OutOrStdout(), out.String())
+	return err
+}
+
+// readGitCredentialRequest reads the key=value attributes Git sends, up to
+// a blank line or the end of input. Attributes the helper does not use are
+// skipped.
+func readGitCredentialRequest(r io.Reader) (gitCredentialRequest, error) {
+	var request gitCredentialRequest
+	var rawURL string
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return gitCredentialRequest{}, usageErrorf("invalid git credential attribute %q", line)
+		}
+		switch name {
+		case "protocol":
+			request.Protocol = value
+		case "host":
+			request.Host = value
+		case "path":
+			request.Path = value
+		case "url":
+			rawURL = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return gitCredentialRequest{}, fmt.Errorf("failed to read git credential attributes: %w", err)
+	}
+
+	// git credential fill accepts a url instead of its parts
+	if request.Host == "" && rawURL != "" {
+		parsed, err := url.Parse(rawURL)
+		if err != nil {
+			return gitCredentialRequest{}, usageErrorf("invalid git credential url %q", rawURL)
+		}
+		request.Protocol = parsed.Scheme
+		request.Host = parsed.Host
+		request.Path = strings.TrimPrefix(parsed.Path, "/")
+	}
+	return request, nil
+}
+
+// gitHostConfig returns the configuration of the host and repository path
+// Git asks for. Configured names are a hostname, optionally followed by a
+// path prefix matching whole path segments, with or without the .git suffix
+// of repositories; the longest match wins.
+func gitHostConfig(hosts map[string]LoginConfig, host, path string) (LoginConfig, bool) {
+	host = strings.ToLower(host)
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	if host == "" {
+		return LoginConfig{}, false
+	}
+
+	names := make([]string, 0, len(hosts))
+	for name := range hosts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	best, bestLength := "", -1
+	for _, name := range names {
+		nameHost, prefix := splitGitHost(name)
+		if nameHost != host {
+			continue
+		}
+		if prefix != "" && path != prefix && !strings.HasPrefix(path, prefix+"/") {
+			continue
+		}
+		if len(prefix) > bestLength {
+			best, bestLength = name, len(prefix)
+		}
+	}
+	if bestLength < 0 {
+		return LoginConfig{}, false
+	}
+	return hosts[best], true
+}
+
+// splitGitHost splits a configured host name into its lowercase hostname,
+// with port, and path prefix
+func splitGitHost(name string) (string, string) {
+	name = strings.TrimSpace(name)
+	if _, rest, ok := strings.Cut(name, "://"); ok {
+		name = rest
+	}
+	host, prefix, _ := strings.Cut(name, "/")
+	return strings.ToLower(host), strings.TrimSuffix(strings.Trim(prefix, "/"), ".git")
+}
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gitTestConfig configures the hosts used by the tests
+var gitTestConfig = Config{Git: GitConfig{Hosts: map[string]LoginConfig{
+	"github.com": {Key: "GITHUB_CREDENTIALS", Username: "x-access-token", PasswordField: "GITHUB_TOKEN"},
+	"github.com/my-org/infra": {
+		Key:           "INFRA_CREDENTIALS",
+		Username:      "x-access-token",
+		PasswordField: "GITHUB_TOKEN",
+	},
+	"gitlab.example.com": {Key: "GITLAB_CREDENTIALS"},
+}}}
+
+// gitAttributes parses the key=value lines the helper prints
+func gitAttributes(t *testing.T, output string) map[string]string {
+	t.Helper()
+	attributes := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+		name, value, ok := strings.Cut(line, "=")
+		require.True(t, ok, line)
+		attributes[name] = value
+	}
+	return attributes
+}
+
+func TestGitCredential_Get(t *testing.T) {
+	setConfig(t, gitTestConfig)
+	broker, client := newServeBroker(t)
+	broker.SetKey("GITHUB_CREDENTIALS", map[string]string{"GITHUB_TOKEN": "ghs_token"})
+	broker.SetKey("INFRA_CREDENTIALS", map[string]string{"GITHUB_TOKEN": "ghs_infra"})
+	broker.SetKey("GITLAB_CREDENTIALS", map[string]string{"GIT_USERNAME": "project_bot", "GIT_PASSWORD": "glpat"})
+
+	tests := []struct {
+		name     string
+		stdin    string
+		username string
+		password string
+	}{
+		{
+			name:     "host",
+			stdin:    "protocol=https\nhost=github.com\n\n",
+			username: "x-access-token",
+			password: "ghs_token-1",
+		},
+		{
+			name:     "path prefix",
+			stdin:    "protocol=https\nhost=github.com\npath=my-org/infra.git\n\n",
+			username: "x-access-token",
+			password: "ghs_infra-1",
+		},
+		{
+			name:     "other repository",
+			stdin:    "protocol=https\nhost=github.com\npath=my-org/infrastructure.git\n",
+			username: "x-access-token",
+			password: "ghs_token-2",
+		},
+		{
+			name:     "default fields",
+			stdin:    "capability[]=authtype\nprotocol=https\nhost=GitLab.example.com\nusername=ignored\n",
+			username: "project_bot-1",
+			password: "glpat-1",
+		},
+		{
+			name:     "url",
+			stdin:    "url=https://github.com/my-org/infra\n",
+			username: "x-access-token",
+			password: "ghs_infra-2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, err := runHelperCommand(t, gitCredential, client, "get", tt.stdin)
+			require.NoError(t, err)
+
+			attributes := gitAttributes(t, stdout)
+			assert.Equal(t, tt.username, attributes["username"])
+			assert.Equal(t, tt.password, attributes["password"])
+			assert.NotEmpty(t, attributes["password_expiry_utc"])
+		})
+	}
+}
+
+func TestGitCredential_CachesCredentials(t *testing.T) {
+	setConfig(t, gitTestConfig)
+	broker, client := newServeBroker(t)
+	broker.SetKey("GITHUB_CREDENTIALS", map[string]string{"GITHUB_TOKEN": "ghs_token"})
+	client.EnableCredentialCache(t.TempDir())
+
+	// Every fetch or push runs the helper again
+	for i := 0; i < 3; i++ {
+		stdout, err := runHelperCommand(t, gitCredential, client, "get", "protocol=https\nhost=github.com\n")
+		require.NoError(t, err)
+		assert.Equal(t, "ghs_token-1", gitAttributes(t, stdout)["password"])
+	}
+	assert.Equal(t, 1, broker.Mints("GITHUB_CREDENTIALS"))
+
+	// Git erases logins the server rejected, so the next get mints again
+	stdout, err := runHelperCommand(t, gitCredential, client, "erase", "protocol=https\nhost=github.com\nusername=x-access-token\npassword=ghs_token-1\n")
+	require.NoError(t, err)
+	assert.Empty(t, stdout)
+
+	stdout, err = runHelperCommand(t, gitCredential, client, "get", "protocol=https\nhost=github.com\n")
+	require.NoError(t, err)
+	assert.Equal(t, "ghs_token-2", gitAttributes(t, stdout)["password"])
+}
+
+func TestGitCredential_NotAnswered(t *testing.T) {
+	setConfig(t, gitTestConfig)
+	broker, client := newServeBroker(t)
+
+	// Git falls back to other helpers, or prompts, when nothing is printed
+	for _, stdin := range []string{
+		"protocol=https\nhost=bitbucket.org\n",
+		"protocol=http\nhost=github.com\n",
+		"",
+	} {
+		stdout, err := runHelperCommand(t, gitCredential, client, "get", stdin)
+		assert.NoError(t, err)
+		assert.Empty(t, stdout)
+	}
+
+	for _, action := range []string{"store", "erase", "capability"} {
+		stdout, err := runHelperCommand(t, gitCredential, client, action, "protocol=https\nhost=github.com\nusername=x-access-token\npassword=ghs_token\n")
+		assert.NoError(t, err)
+		assert.Empty(t, stdout)
+	}
+	assert.Equal(t, 0, broker.Mints("GITHUB_CREDENTIALS"))
+}
+
+func TestGitCredential_Errors(t *testing.T) {
+	setConfig(t, gitTestConfig)
+	broker, client := newServeBroker(t)
+	broker.SetKey("GITLAB_CREDENTIALS", map[string]string{"GIT_PASSWORD": "glpat"})
+
+	_, err := runHelperCommand(t, gitCredential, client, "get", "protocol=https\nhost=gitlab.example.com\n")
+	assert.EqualError(t, err, "key GITLAB_CREDENTIALS has no credential GIT_USERNAME")
+
+	_, err = runHelperCommand(t, gitCredential, client, "get", "protocol=https\nhost=github.com\n")
+	assert.ErrorIs(t, err, ErrRequestRejected)
+
+	_, err = runHelperCommand(t, gitCredential, client, "get", "protocol https\n")
+	assert.ErrorIs(t, err, ErrUsage)
+}
+
+func TestGitHostConfig(t *testing.T) {
+	hosts := map[string]LoginConfig{
+		"github.com":                 {Key: "GITHUB"},
+		"https://github.com/my-org/": {Key: "MY_ORG"},
+		"github.com/my-org/app.git":  {Key: "APP"},
+		"git.example.com:8443":       {Key: "EXAMPLE"},
+	}
+
+	tests := []struct {
+		host     string
+		path     string
+		expected string
+	}{
+		{host: "github.com", expected: "GITHUB"},
+		{host: "GitHub.com", path: "other/repo.git", expected: "GITHUB"},
+		{host: "github.com", path: "my-org/repo.git", expected: "MY_ORG"},
+		{host: "github.com", path: "my-org", expected: "MY_ORG"},
+		{host: "github.com", path: "my-organisation/repo", expected: "GITHUB"},
+		{host: "github.com", path: "my-org/app.git", expected: "APP"},
+		{host: "github.com", path: "my-org/app", expected: "APP"},
+		{host: "git.example.com:8443", path: "repo.git", expected: "EXAMPLE"},
+		{host: "git.example.com", expected: ""},
+		{host: "", expected: ""},
+	}
+
+	for _, tt := range tests {
+		config, ok := gitHostConfig(hosts, tt.host, tt.path)
+		assert.Equal(t, tt.expected != "", ok, tt.host+"/"+tt.path)
+		assert.Equal(t, tt.expected, config.Key, tt.host+"/"+tt.path)
+	}
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/voidkey-oss/cli/internal/credserver"
+)
+
+// defaultIMDSListen is the default listen address of serve imds
+const defaultIMDSListen = "127.0.0.1:1338"
+
+// serveIMDS creates the serve imds command
+func serveIMDS(voidkeyClient *VoidkeyClient) *cobra.Command {
+	var opts serveOptions
+	var role string
+
+	cmd := &cobra.Command{
+		Use:   "imds",
+		Short: "Serve AWS credentials over an EC2 instance metadata (IMDSv2) endpoint",
+		Long: `Serve the credentials of an AWS key over an emulation of the EC2 instance
+metadata service, as the instance profile role. Only IMDSv2 is supported:
+clients first fetch a session token with PUT /latest/api/token.
+
+Point AWS SDKs and the AWS CLI at it with:
+
+  export AWS_EC2_METADATA_SERVICE_ENDPOINT=http://127.0.0.1:1338
+
+Examples:
+  # Serve AWS_CREDENTIALS on the default address
+  voidkey serve imds --key AWS_CREDENTIALS
+
+  # Serve on another port under a custom role name
+  voidkey serve imds --key AWS_CREDENTIALS --listen 127.0.0.1:9911 --role deploy`,
+		RunE: func(cobraCmd *cobra.Command, args []string) error {
+			if role == "" || strings.Contains(role, "/") {
+				return usageErrorf("invalid --role %q (must be a non-empty name without slashes)", role)
+			}
+			source, listener, err := prepareServe(voidkeyClient, cobraCmd, opts)
+			if err != nil {
+				return err
+			}
+			return serveListener(cobraCmd, listener, credserver.NewIMDS(source, role, logFor(cobraCmd)), source)
+		},
+	}
+
+	addServeFlags(cmd, &opts, defaultIMDSListen)
+	cmd.Flags().StringVar(&role, "role", "voidkey", "Instance profile role name the credentials are served under")
+
+	return cmd
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeJWTClaims(t *testing.T) {
+	claims, err := decodeJWTClaims(testJWT(t, map[string]any{
+		"iss": "https://token.actions.githubusercontent.com",
+		"sub": "repo:acme/app:ref:refs/heads/main",
+		"aud": []string{"voidkey", "sts.amazonaws.com"},
+		"exp": 1735732800,
+		"nbf": 1735729200.5,
+	}))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "https://token.actions.githubusercontent.com", claims.Issuer)
+	assert.Equal(t, audience{"voidkey", "sts.amazonaws.com"}, claims.Audience)
+	assert.Equal(t, time.Unix(1735732800, 0), claims.ExpiresAt.Time)
+	assert.Equal(t, time.Unix(1735729200, int64(500*time.Millisecond)), claims.NotBefore.Time)
+	assert.Nil(t, claims.IssuedAt)
+
+	claims, err = decodeJWTClaims(testJWT(t, map[string]any{"aud": "voidkey"}))
+	assert.NoError(t, err)
+	assert.Equal(t, audience{"voidkey"}, claims.Audience)
+}
+
+func TestDecodeJWTClaims_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		expected string
+	}{
+		{name: "not a JWT", token: "cli-hello-world-token", expected: "token is not a JWT"},
+		{name: "bad encoding", token: "header.!!!.signature", expected: "failed to decode JWT payload"},
+		{name: "bad JSON", token: "header.bm90LWpzb24.signature", expected: "failed to parse JWT claims"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeJWTClaims(tt.token)
+
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+}
@@ -15,7 +15,7 @@ func listIdpProviders(voidkeyClient *VoidkeyClient) *cobra.Command {
 		Long:  `List all configured Identity Providers available in the broker server, including which one is set as default.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			// Get providers from server
-			providers, err := voidkeyClient.ListIdpProviders()
+			providers, err := voidkeyClient.ListIdpProviders(commandContext(cmd))
 			if err != nil {
 				return fmt.Errorf("failed to list IdP providers: %w", err)
 			}
@@ -49,3 +49,39 @@ func listIdpProviders(voidkeyClient *VoidkeyClient) *cobra.Command {
 
 	return cmd
 }
+
+// listKeys creates a new list-keys command with dependency injection
+func listKeys(voidkeyClient *VoidkeyClient) *cobra.Command {
+	var token string
+
+	cmd := &cobra.Command{
+		Use:   "list-keys",
+		Short: "List keys available to your identity",
+		Long:  `List the keys the broker server allows the current identity to mint.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			token, err := resolveToken(cmd, token, "")
+			if err != nil {
+				return err
+			}
+
+			keys, err := voidkeyClient.GetAvailableKeys(commandContext(cmd), token)
+			if err != nil {
+				return fmt.Errorf("failed to list available keys: %w", err)
+			}
+
+			if len(keys) == 0 {
+				_, _ = fmt.Fprintln(cmd.OutOrStdout(), "No keys available for this identity")
+				return nil
+			}
+
+			for _, key := range keys {
+				_, _ = fmt.Fprintln(cmd.OutOrStdout(), key)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&token, "token", "", "OIDC token for authentication (default from $OIDC_TOKEN or $GITHUB_TOKEN)")
+
+	return cmd
+}
@@ -39,7 +39,7 @@ func TestListIdpProviders_Success(t *testing.T) {
 		Body:       io.NopCloser(bytes.NewReader(responseBody)),
 	}
 
-	mockClient.On("Get", "http://localhost:3000/credentials/idp-providers").Return(resp, nil)
+	mockClient.On("Do", requestMatching(http.MethodGet, "http://localhost:3000/credentials/idp-providers")).Return(resp, nil)
 
 	cmd := listIdpProviders(client)
 	var stdout bytes.Buffer
@@ -70,7 +70,7 @@ func TestListIdpProviders_EmptyList(t *testing.T) {
 		Body:       io.NopCloser(bytes.NewReader(responseBody)),
 	}
 
-	mockClient.On("Get", "http://localhost:3000/credentials/idp-providers").Return(resp, nil)
+	mockClient.On("Do", requestMatching(http.MethodGet, "http://localhost:3000/credentials/idp-providers")).Return(resp, nil)
 
 	cmd := listIdpProviders(client)
 	var stdout bytes.Buffer
@@ -100,7 +100,7 @@ func TestListIdpProviders_SingleProvider(t *testing.T) {
 		Body:       io.NopCloser(bytes.NewReader(responseBody)),
 	}
 
-	mockClient.On("Get", "http://localhost:3000/credentials/idp-providers").Return(resp, nil)
+	mockClient.On("Do", requestMatching(http.MethodGet, "http://localhost:3000/credentials/idp-providers")).Return(resp, nil)
 
 	cmd := listIdpProviders(client)
 	var stdout bytes.Buffer
@@ -133,7 +133,7 @@ func TestListIdpProviders_MultipleProvidersNoDefault(t *testing.T) {
 		Body:       io.NopCloser(bytes.NewReader(responseBody)),
 	}
 
-	mockClient.On("Get", "http://localhost:3000/credentials/idp-providers").Return(resp, nil)
+	mockClient.On("Do", requestMatching(http.MethodGet, "http://localhost:3000/credentials/idp-providers")).Return(resp, nil)
 
 	cmd := listIdpProviders(client)
 	var stdout bytes.Buffer
@@ -164,7 +164,7 @@ func TestListIdpProviders_ServerError(t *testing.T) {
 		Body:       io.NopCloser(strings.NewReader("Internal server error")),
 	}
 
-	mockClient.On("Get", "http://localhost:3000/credentials/idp-providers").Return(resp, nil)
+	mockClient.On("Do", requestMatching(http.MethodGet, "http://localhost:3000/credentials/idp-providers")).Return(resp, nil)
 
 	cmd := listIdpProviders(client)
 	var stdout bytes.Buffer
@@ -193,7 +193,7 @@ func TestListIdpProviders_TableFormatting(t *testing.T) {
 		Body:       io.NopCloser(bytes.NewReader(responseBody)),
 	}
 
-	mockClient.On("Get", "http://localhost:3000/credentials/idp-providers").Return(resp, nil)
+	mockClient.On("Do", requestMatching(http.MethodGet, "http://localhost:3000/credentials/idp-providers")).Return(resp, nil)
 
 	cmd := listIdpProviders(client)
 	var stdout bytes.Buffer
@@ -235,7 +235,7 @@ func TestListIdpProviders_Integration(t *testing.T) {
 		Body:       io.NopCloser(bytes.NewReader(responseBody)),
 	}
 
-	mockClient.On("Get", "http://localhost:3000/credentials/idp-providers").Return(resp, nil)
+	mockClient.On("Do", requestMatching(http.MethodGet, "http://localhost:3000/credentials/idp-providers")).Return(resp, nil)
 
 	// Create a root command and add the list command
 	rootCmd := &cobra.Command{Use: "voidkey"}
@@ -255,3 +255,46 @@ func TestListIdpProviders_Integration(t *testing.T) {
 
 	mockClient.AssertExpectations(t)
 }
+
+func TestListKeys_Success(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client := NewVoidkeyClient(mockClient, "http://localhost:3000")
+
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`["AWS_CREDENTIALS","MINIO_CREDENTIALS"]`)),
+	}
+	mockClient.On("Do", requestMatching(http.MethodGet, "http://localhost:3000/credentials/keys?token=test-token")).Return(resp, nil)
+
+	cmd := listKeys(client)
+	var stdout bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetArgs([]string{"--token", "test-token"})
+
+	err := cmd.Execute()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "AWS_CREDENTIALS\nMINIO_CREDENTIALS\n", stdout.String())
+	mockClient.AssertExpectations(t)
+}
+
+func TestListKeys_EmptyList(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client := NewVoidkeyClient(mockClient, "http://localhost:3000")
+
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(`[]`)),
+	}
+	mockClient.On("Do", requestMatching(http.MethodGet, "http://localhost:3000/credentials/keys?token=test-token")).Return(resp, nil)
+
+	cmd := listKeys(client)
+	var stdout bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetArgs([]string{"--token", "test-token"})
+
+	err := cmd.Execute()
+
+	assert.NoError(t, err)
+	assert.Contains(t, stdout.String(), "No keys available")
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+)
+
+// Log output formats for --log-format
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// logOptions controls the level and format of everything the CLI logs to stderr
+type logOptions struct {
+	level  slog.Level
+	format string
+}
+
+var (
+	logOpts = logOptions{level: slog.LevelInfo, format: logFormatText}
+
+	verbose   bool
+	quiet     bool
+	logFormat string
+)
+
+// resolveLogOptions turns the logging flags and VOIDKEY_DEBUG into log options
+func resolveLogOptions(verbose, quiet bool, format string) (logOptions, error) {
+	if verbose && quiet {
+		return logOptions{}, usageErrorf("--verbose and --quiet cannot be used together")
+	}
+
+	switch format {
+	case "":
+		format = logFormatText
+	case logFormatText, logFormatJSON:
+	default:
+		return logOptions{}, usageErrorf("invalid --log-format %q (must be %s or %s)", format, logFormatText, logFormatJSON)
+	}
+
+	opts := logOptions{level: slog.LevelInfo, format: format}
+	if debug, _ := strconv.ParseBool(os.Getenv("VOIDKEY_DEBUG")); debug || verbose {
+		opts.level = slog.LevelDebug
+	}
+	if quiet {
+		opts.level = slog.LevelWarn
+	}
+
+	return opts, nil
+}
+
+// newLogger creates a logger writing to w. All output passes through the
+// redactor. In text mode informational messages are printed as plain
+// progress lines, followed by any attributes, and debug records as logfmt; in JSON mode every record is
+// a JSON object.
+func newLogger(w io.Writer, opts logOptions) *slog.Logger {
+	w = redactor.Writer(w)
+	handlerOpts := &slog.HandlerOptions{Level: opts.level}
+
+	if opts.format == logFormatJSON {
+		return slog.New(slog.NewJSONHandler(w, handlerOpts))
+	}
+
+	return slog.New(&consoleHandler{
+		w:     w,
+		mu:    &sync.Mutex{},
+		level: opts.level,
+		debug: slog.NewTextHandler(w, handlerOpts),
+	})
+}
+
+// logFor returns the logger for a command, writing to its stderr
+func logFor(cmd *cobra.Command) *slog.Logger {
+	return newLogger(cmd.ErrOrStderr(), logOpts)
+}
+
+// progressf logs a progress line for the user, suppressed by --quiet
+func progressf(cmd *cobra.Command, format string, args ...any) {
+	logFor(cmd).Info(fmt.Sprintf(format, args...))
+}
+
+// discardLogger drops every record
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+}
+
+// consoleHandler prints records at info level and above as bare messages,
+// keeping the CLI's progress output readable, followed by their attributes
+// as key=value pairs, and delegates debug records to a structured handler
+type consoleHandler struct {
+	w     io.Writer
+	mu    *sync.Mutex
+	level slog.Level
+	debug slog.Handler
+	// attrs are the formatted attributes added with WithAttrs
+	attrs string
+	// group prefixes the keys of attributes, from WithGroup
+	group string
+}
+
+func (h *consoleHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *consoleHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelInfo {
+		return h.debug.Handle(ctx, r)
+	}
+
+	var line strings.Builder
+	line.WriteString(r.Message)
+	line.WriteString(h.attrs)
+	r.Attrs(func(attr slog.Attr) bool {
+		appendAttr(&line, h.group, attr)
+		return true
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := fmt.Fprintln(h.w, line.String())
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.debug = h.debug.WithAttrs(attrs)
+	var formatted strings.Builder
+	for _, attr := range attrs {
+		appendAttr(&formatted, h.group, attr)
+	}
+	clone.attrs += formatted.String()
+	return &clone
+}
+
+func (h *consoleHandler) WithGroup(name string) slog.Handler {
+	clone := *h
+	clone.debug = h.debug.WithGroup(name)
+	if name != "" {
+		clone.group += name + "."
+	}
+	return &clone
+}
+
+// appendAttr writes attr to b as " key=value", quoting values that would
+// be ambiguous otherwise. Groups are flattened into dotted keys.
+func appendAttr(b *strings.Builder, prefix string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, member := range attr.Value.Group() {
+			appendAttr(b, prefix, member)
+		}
+		return
+	}
+
+	value := attr.Value.String()
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		value = strconv.Quote(value)
+	}
+	fmt.Fprintf(b, " %s%s=%s", prefix, attr.Key, value)
+}
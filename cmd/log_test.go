@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveLogOptions(t *testing.T) {
+	tests := []struct {
+		name          string
+		verbose       bool
+		quiet         bool
+		format        string
+		debugEnv      string
+		expectedLevel slog.Level
+		expectedErr   string
+	}{
+		{name: "defaults", expectedLevel: slog.LevelInfo},
+		{name: "verbose", verbose: true, expectedLevel: slog.LevelDebug},
+		{name: "VOIDKEY_DEBUG", debugEnv: "true", expectedLevel: slog.LevelDebug},
+		{name: "VOIDKEY_DEBUG disabled", debugEnv: "false", expectedLevel: slog.LevelInfo},
+		{name: "quiet", quiet: true, expectedLevel: slog.LevelWarn},
+		{name: "json format", format: logFormatJSON, expectedLevel: slog.LevelInfo},
+		{name: "verbose and quiet", verbose: true, quiet: true, expectedErr: "cannot be used together"},
+		{name: "invalid format", format: "xml", expectedErr: "invalid --log-format"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("VOIDKEY_DEBUG", tt.debugEnv)
+
+			opts, err := resolveLogOptions(tt.verbose, tt.quiet, tt.format)
+
+			if tt.expectedErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedLevel, opts.level)
+		})
+	}
+}
+
+func TestNewLogger_Text(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, logOptions{level: slog.LevelInfo, format: logFormatText})
+
+	logger.Info("🔑 Minting keys: [A]")
+	logger.Debug("broker request", "status", 200)
+
+	assert.Equal(t, "🔑 Minting keys: [A]\n", buf.String())
+
+	buf.Reset()
+	logger = newLogger(&buf, logOptions{level: slog.LevelDebug, format: logFormatText})
+	logger.Debug("broker request", "status", 200)
+
+	assert.Contains(t, buf.String(), "level=DEBUG")
+	assert.Contains(t, buf.String(), `msg="broker request"`)
+	assert.Contains(t, buf.String(), "status=200")
+}
+
+func TestNewLogger_Quiet(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, logOptions{level: slog.LevelWarn, format: logFormatText})
+
+	logger.Info("🔑 Minting keys: [A]")
+	logger.Warn("⚠️ something is off")
+
+	assert.Equal(t, "⚠️ something is off\n", buf.String())
+}
+
+func TestNewLogger_TextAttributes(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, logOptions{level: slog.LevelInfo, format: logFormatText})
+
+	logger.Warn("failed to refresh credentials", "key", "AWS", "error", errors.New("broker down"))
+	logger.With("uid", 1000).WithGroup("request").Error("refused connection", "path", "/tmp/agent.sock", "status", "")
+
+	assert.Equal(t, `failed to refresh credentials key=AWS error="broker down"
+refused connection uid=1000 request.path=/tmp/agent.sock request.status=""
+`, buf.String())
+}
+
+func TestNewLogger_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newLogger(&buf, logOptions{level: slog.LevelDebug, format: logFormatJSON})
+
+	logger.Debug("broker request", "status", 200)
+
+	var record map[string]any
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "DEBUG", record["level"])
+	assert.Equal(t, "broker request", record["msg"])
+	assert.Equal(t, float64(200), record["status"])
+}
+
+func TestNewLogger_Redacts(t *testing.T) {
+	redactor.Add("logged-secret-token")
+
+	var buf bytes.Buffer
+	logger := newLogger(&buf, logOptions{level: slog.LevelDebug, format: logFormatText})
+	logger.Debug("broker request", "url", "http://localhost:3000/credentials/keys?token=logged-secret-token")
+
+	assert.NotContains(t, buf.String(), "logged-secret-token")
+	assert.Contains(t, buf.String(), redactedPlaceholder)
+}
+
+func TestVoidkeyClient_DebugLogging(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client := NewVoidkeyClient(mockClient, "http://localhost:3000")
+	MockSuccessfulListResponse(mockClient, "http://localhost:3000", CreateTestIdpProviders())
+
+	var buf bytes.Buffer
+	client.SetLogger(newLogger(&buf, logOptions{level: slog.LevelDebug, format: logFormatText}))
+
+	_, err := client.ListIdpProviders(context.Background())
+
+	assert.NoError(t, err)
+	output := buf.String()
+	assert.Contains(t, output, "url=http://localhost:3000/credentials/idp-providers")
+	assert.Contains(t, output, "status=200")
+	assert.Contains(t, output, "latency=")
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestMintCredentialsWithFlags_Quiet(t *testing.T) {
+	original := logOpts
+	defer func() { logOpts = original }()
+	logOpts = logOptions{level: slog.LevelWarn, format: logFormatText}
+
+	mockClient := &MockHTTPClient{}
+	client := NewVoidkeyClient(mockClient, "http://localhost:3000")
+	// Expired credentials would be warned about even with --quiet
+	credentials := CreateTestKeyCredentials()
+	minio := credentials["MINIO_CREDENTIALS"]
+	minio.ExpiresAt = time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	credentials["MINIO_CREDENTIALS"] = minio
+	MockSuccessfulMintResponse(mockClient, "http://localhost:3000", credentials)
+
+	cmd, stdout, stderr := SetupTestCommand()
+	err := mintCredentialsWithFlags(client, cmd, mintOptions{token: "test-token", format: "env", keys: []string{"MINIO_CREDENTIALS"}})
+
+	assert.NoError(t, err)
+	assert.Empty(t, stderr.String())
+	assert.True(t, strings.HasPrefix(stdout.String(), "export "))
+
+	mockClient.AssertExpectations(t)
+}
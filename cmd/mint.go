@@ -1,29 +1,43 @@
 package cmd
 
 import (
-	"encoding/json"
+	"bytes"
 	"fmt"
-	"os"
+	"io"
+	"path/filepath"
+	"regexp"
 
 	"github.com/spf13/cobra"
 )
 
-type MintKeysRequest struct {
-	OidcToken string   `json:"oidcToken"`
-	IdpName   string   `json:"idpName,omitempty"`
-	Keys      []string `json:"keys,omitempty"`
-	Duration  int      `json:"duration,omitempty"`
-	All       bool     `json:"all,omitempty"`
+// Modes for --fail-on, deciding when keys the broker refused fail the command
+const (
+	failOnAny     = "any"
+	failOnPartial = "partial"
+	failOnNone    = "none"
+)
+
+// mintOptions holds the flag values of a single mint invocation
+type mintOptions struct {
+	token    string
+	format   string
+	idpName  string
+	keys     []string
+	duration int
+	all      bool
+	keyOrder string
+	// collision is the --on-collision mode for env output
+	collision string
+	// outFile and outDir redirect the credentials from stdout to disk
+	outFile string
+	outDir  string
+	// failOn is the --fail-on mode for keys the broker refused
+	failOn string
 }
 
 // mintCreds creates a new mint command with dependency injection
 func mintCreds(voidkeyClient *VoidkeyClient) *cobra.Command {
-	var localOidcToken string
-	var localOutputFormat string
-	var localIdpName string
-	var localKeys []string
-	var localDuration int
-	var localAll bool
+	var opts mintOptions
 
 	cmd := &cobra.Command{
 		Use:   "mint",
@@ -39,119 +53,209 @@ Examples:
   voidkey mint --all
 
   # Mint with custom duration (in seconds)
-  voidkey mint --keys MINIO_CREDENTIALS --duration 1800`,
+  voidkey mint --keys MINIO_CREDENTIALS --duration 1800
+
+  # Keep the order given in --keys instead of sorting by key name
+  voidkey mint --keys MINIO_CREDENTIALS,AWS_CREDENTIALS --key-order request
+
+  # Prefix variables that several keys set with the key name
+  voidkey mint --keys AWS_PROD,AWS_STAGING --on-collision prefix
+
+  # Output the keys that could be minted even if others were refused
+  voidkey mint --keys AWS_PROD,AWS_STAGING --fail-on none
+
+  # Write credentials to disk instead of stdout
+  voidkey mint --keys AWS_CREDENTIALS --out-file ./aws.env
+  voidkey mint --keys AWS_CREDENTIALS --out-dir /run/secrets/aws`,
+		Annotations: map[string]string{agentAnnotation: "true"},
 		RunE: func(cobraCmd *cobra.Command, args []string) error {
-			return mintCredentialsWithFlags(voidkeyClient, cobraCmd, localOidcToken, localOutputFormat, localIdpName, localKeys, localDuration, localAll)
+			return mintCredentialsWithFlags(voidkeyClient, cobraCmd, opts)
 		},
 	}
 
 	// Flags for the mint command
-	cmd.Flags().StringVar(&localOidcToken, "token", "", "OIDC token for authentication (uses dummy token if not provided)")
-	cmd.Flags().StringVarP(&localOutputFormat, "output", "o", "env", "Output format (env|json)")
-	cmd.Flags().StringVar(&localIdpName, "idp", "", "IdP provider name to use (uses server default if not specified)")
-	cmd.Flags().StringSliceVar(&localKeys, "keys", nil, "Comma-separated list of key names to mint (e.g. MINIO_CREDENTIALS,AWS_CREDENTIALS)")
-	cmd.Flags().IntVar(&localDuration, "duration", 0, "Duration in seconds to override default credential lifetime")
-	cmd.Flags().BoolVar(&localAll, "all", false, "Mint all available keys for the identity")
+	cmd.Flags().StringVar(&opts.token, "token", "", "OIDC token for authentication (uses dummy token if not provided)")
+	cmd.Flags().StringVarP(&opts.format, "output", "o", "env", "Output format (env|json)")
+	cmd.Flags().StringVar(&opts.idpName, "idp", "", "IdP provider name to use (uses server default if not specified)")
+	cmd.Flags().StringSliceVar(&opts.keys, "keys", nil, "Comma-separated list of key names to mint (e.g. MINIO_CREDENTIALS,AWS_CREDENTIALS)")
+	cmd.Flags().IntVar(&opts.duration, "duration", 0, "Duration in seconds to override default credential lifetime")
+	cmd.Flags().BoolVar(&opts.all, "all", false, "Mint all available keys for the identity")
+	cmd.Flags().StringVar(&opts.keyOrder, "key-order", keyOrderName, "Order of keys in the output (name|request)")
+	cmd.Flags().StringVar(&opts.collision, "on-collision", collisionError, "How to handle environment variables set by more than one key (error|prefix)")
+	cmd.Flags().StringVar(&opts.outFile, "out-file", "", "Write the formatted output to this file (mode 0600) instead of stdout")
+	cmd.Flags().StringVar(&opts.outDir, "out-dir", "", "Write one file per credential variable into this directory (mode 0600) instead of stdout")
+	cmd.Flags().StringVar(&opts.failOn, "fail-on", failOnAny, "When refused keys fail the command: any (output nothing), partial (output minted keys, exit non-zero) or none")
+	cmd.MarkFlagsMutuallyExclusive("out-file", "out-dir")
 
 	return cmd
 }
 
-func mintCredentialsWithFlags(client *VoidkeyClient, cmd *cobra.Command, token, format, idpName string, keys []string, duration int, all bool) error {
-	// Check for token from environment variable if not provided via flag
-	if token == "" {
-		token = os.Getenv("OIDC_TOKEN")
-		if token == "" {
-			// Also check for GitHub Actions token as a common case
-			token = os.Getenv("GITHUB_TOKEN")
-			if token != "" {
-				_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "🔍 Using GITHUB_TOKEN environment variable\n")
-			}
-		} else {
-			_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "🔍 Using OIDC_TOKEN environment variable\n")
-		}
-	}
-
-	// Special case for hello-world IdP - provide default token
-	if token == "" && idpName == "hello-world" {
-		token = "cli-hello-world-token"
-		_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "🎭 Using hello-world IdP with default token\n")
-	}
+func mintCredentialsWithFlags(client *VoidkeyClient, cmd *cobra.Command, opts mintOptions) error {
+	token, idpName, keys := opts.token, opts.idpName, opts.keys
 
-	// Require a valid OIDC token
-	if token == "" {
-		return fmt.Errorf("OIDC token is required. Provide via:\n" +
-			"  --token flag: voidkey mint --token \"your.jwt.token\"\n" +
-			"  OIDC_TOKEN env var: export OIDC_TOKEN=\"your.jwt.token\"\n" +
-			"  GITHUB_TOKEN env var (for GitHub Actions IdP)\n\n" +
-			"To obtain an OIDC token:\n" +
-			"  - Auth0: Use the Auth0 CLI or obtain from your application\n" +
-			"  - GitHub Actions: Available as ${{ github.token }}\n" +
-			"  - Other IdPs: Consult your identity provider's documentation\n" +
-			"  - Hello World: Use --idp hello-world for testing (no token required)")
+	token, err := resolveClientToken(client, cmd, token, idpName)
+	if err != nil {
+		return err
 	}
 
 	// Show IdP selection info
 	if idpName != "" {
-		_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "🔍 Using IdP provider: %s\n", idpName)
+		progressf(cmd, "🔍 Using IdP provider: %s", idpName)
 	} else {
-		_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "🔍 Using server default IdP provider\n")
+		progressf(cmd, "🔍 Using server default IdP provider")
 	}
 
 	// Validate that at least one approach is specified
-	if len(keys) == 0 && !all {
-		return fmt.Errorf("must specify either specific keys (--keys) or all keys (--all)")
+	if len(keys) == 0 && !opts.all {
+		return usageErrorf("must specify either specific keys (--keys) or all keys (--all)")
+	}
+
+	switch opts.keyOrder {
+	case "", keyOrderName, keyOrderRequest:
+	default:
+		return usageErrorf("invalid --key-order %q (must be %s or %s)", opts.keyOrder, keyOrderName, keyOrderRequest)
+	}
+
+	switch opts.collision {
+	case "", collisionError, collisionPrefix:
+	default:
+		return usageErrorf("invalid --on-collision %q (must be %s or %s)", opts.collision, collisionError, collisionPrefix)
+	}
+
+	switch opts.failOn {
+	case "", failOnAny, failOnPartial, failOnNone:
+	default:
+		return usageErrorf("invalid --fail-on %q (must be %s, %s or %s)", opts.failOn, failOnAny, failOnPartial, failOnNone)
 	}
 
 	// Use key-based minting
-	if all {
-		_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "🔑 Minting all available keys\n")
+	if opts.all {
+		progressf(cmd, "🔑 Minting all available keys")
 	} else {
-		_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "🔑 Minting keys: %v\n", keys)
+		progressf(cmd, "🔑 Minting keys: %v", keys)
 	}
 
-	if duration > 0 {
-		_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "⏱️ Duration override: %d seconds\n", duration)
+	if opts.duration > 0 {
+		progressf(cmd, "⏱️ Duration override: %d seconds", opts.duration)
 	}
 
-	keyResponses, err := client.MintKeys(token, idpName, keys, duration, all)
+	keyResponses, err := client.MintKeys(commandContext(cmd), token, idpName, keys, opts.duration, opts.all)
 	if err != nil {
 		return err
 	}
 
-	// Output credentials in requested format
+	keyNames := orderKeyNames(keyResponses, keys, opts.keyOrder)
+	envOpts := envOptions{collision: opts.collision, keys: cfg.Keys, now: client.Now()}
+
+	// Keys the broker refused fail the whole command unless partial results
+	// were asked for; with none minted there is nothing to output either way
+	var partialErr error
+	minted, failures := splitKeyResults(keyResponses, keyNames)
+	if len(failures) > 0 {
+		switch {
+		case len(minted) == 0, opts.failOn == "", opts.failOn == failOnAny:
+			return newMintError(len(keyNames), failures, false)
+		case opts.failOn == failOnPartial:
+			partialErr = newMintError(len(keyNames), failures, true)
+		default:
+			for _, failure := range failures {
+				logFor(cmd).Warn(fmt.Sprintf("⚠️ Key %s was not minted: %s", failure.Key, failure.Reason))
+			}
+		}
+	}
+
+	// Judged by the broker's clock, so a skewed local clock does not matter
+	for _, keyName := range minted {
+		if _, ok := keyResponses[keyName].Expiry(); ok && !keyResponses[keyName].FreshAt(envOpts.now, 0) {
+			logFor(cmd).Warn(fmt.Sprintf("⚠️ Credentials for %s expired at %s, before they were received", keyName, keyResponses[keyName].ExpiresAt))
+		}
+	}
+
+	if opts.outDir != "" {
+		if err := writeKeysToDir(opts.outDir, keyResponses, minted, envOpts, cmd); err != nil {
+			return err
+		}
+		return partialErr
+	}
+
+	if opts.outFile != "" {
+		var buf bytes.Buffer
+		if err := outputKeys(&buf, opts.format, keyResponses, keyNames, envOpts, cmd); err != nil {
+			return err
+		}
+		writer := newCredentialWriter()
+		if err := writer.run(func() error { return writer.writeFile(opts.outFile, buf.Bytes()) }); err != nil {
+			return err
+		}
+		progressf(cmd, "📁 Credentials written to %s", opts.outFile)
+		return partialErr
+	}
+
+	if err := outputKeys(cmd.OutOrStdout(), opts.format, keyResponses, keyNames, envOpts, cmd); err != nil {
+		return err
+	}
+	if opts.format != "json" {
+		progressf(cmd, "💡 To use: eval \"$(voidkey mint --all)\" or eval \"$(voidkey mint --keys KEY_NAME)\"")
+	}
+
+	return partialErr
+}
+
+// outputKeys writes the credentials to out in the requested format. Keys
+// that were not minted are only included in JSON output.
+func outputKeys(out io.Writer, format string, keyResponses map[string]KeyCredentialResponse, keyNames []string, envOpts envOptions, cmd *cobra.Command) error {
+	minted, _ := splitKeyResults(keyResponses, keyNames)
+
 	switch format {
 	case "env":
-		outputKeysAsEnvVars(keyResponses, cmd)
+		return outputKeysAsEnvVars(out, keyResponses, minted, envOpts, cmd)
 	case "json":
-		outputKeysAsJSON(keyResponses, cmd)
+		outputKeysAsJSON(out, keyResponses, keyNames)
+		return nil
 	default:
-		outputKeysAsEnvVars(keyResponses, cmd) // default format
+		return outputKeysAsEnvVars(out, keyResponses, minted, envOpts, cmd) // default format
 	}
-
-	return nil
 }
 
-// Key-based output functions
-func outputKeysAsEnvVars(keyResponses map[string]KeyCredentialResponse, cmd *cobra.Command) {
-	totalVars := 0
+// credentialFileName matches the variable names --out-dir accepts as file
+// names, which excludes path separators and ..
+var credentialFileName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// writeKeysToDir writes one file per credential variable into dir, named
+// after the environment variable it would be exported as, the same layout
+// Kubernetes uses for mounted secrets. No file is replaced unless all of them
+// could be written.
+func writeKeysToDir(dir string, keyResponses map[string]KeyCredentialResponse, keyNames []string, envOpts envOptions, cmd *cobra.Command) error {
+	vars, err := resolveEnvVars(keyResponses, keyNames, envOpts)
+	if err != nil {
+		return err
+	}
 
-	for keyName, response := range keyResponses {
-		_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "🔑 Key: %s (expires: %s)\n", keyName, response.ExpiresAt)
+	if err := prepareOutputDir(dir); err != nil {
+		return err
+	}
 
-		for envVar, value := range response.Credentials {
-			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "export %s=%s\n", envVar, value)
-			totalVars++
+	// Variable names come from the broker, so they must not be able to
+	// name files outside dir
+	for _, v := range vars {
+		if !credentialFileName.MatchString(v.name) {
+			return fmt.Errorf("refusing to write credential %q: not a valid file name", v.name)
 		}
 	}
 
-	// Print success message to stderr so it doesn't interfere with sourcing
-	_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "✅ Successfully minted %d keys with %d environment variables\n", len(keyResponses), totalVars)
-	_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "💡 To use: eval \"$(voidkey mint --all)\" or eval \"$(voidkey mint --keys KEY_NAME)\"\n")
-}
+	writer := newCredentialWriter()
+	err = writer.run(func() error {
+		for _, v := range vars {
+			if err := writer.stageFile(filepath.Join(dir, v.name), []byte(v.value)); err != nil {
+				return err
+			}
+		}
+		return writer.commit()
+	})
+	if err != nil {
+		return err
+	}
 
-func outputKeysAsJSON(keyResponses map[string]KeyCredentialResponse, cmd *cobra.Command) {
-	output, _ := json.MarshalIndent(keyResponses, "", "  ")
-	_, _ = fmt.Fprintf(cmd.OutOrStdout(), "%s\n", string(output))
+	progressf(cmd, "📁 Wrote %d credential files for %d keys to %s", len(vars), len(keyNames), dir)
+	return nil
 }
-
-// init function removed - commands are now initialized in root.go
@@ -6,12 +6,12 @@ import (
 	"io"
 	"net/http"
 	"os"
-	"strings"
+	"path/filepath"
 	"testing"
 
 	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
-	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMintCreds_CommandCreation(t *testing.T) {
@@ -77,7 +77,7 @@ func TestMintCredentialsWithFlags_KeyBasedApproach(t *testing.T) {
 		Body:       io.NopCloser(bytes.NewReader(responseBody)),
 	}
 
-	mockClient.On("Post", "http://localhost:3000/credentials/mint", "application/json", mock.Anything).Return(resp, nil)
+	mockClient.On("Do", requestMatching(http.MethodPost, "http://localhost:3000/credentials/mint")).Return(resp, nil)
 
 	var stdout, stderr bytes.Buffer
 	cmd := &cobra.Command{}
@@ -85,7 +85,7 @@ func TestMintCredentialsWithFlags_KeyBasedApproach(t *testing.T) {
 	cmd.SetErr(&stderr)
 
 	keys := []string{"MINIO_CREDENTIALS", "AWS_CREDENTIALS"}
-	err := mintCredentialsWithFlags(client, cmd, "test-token", "env", "test-idp", keys, 0, false)
+	err := mintCredentialsWithFlags(client, cmd, mintOptions{token: "test-token", format: "env", idpName: "test-idp", keys: keys})
 
 	assert.NoError(t, err)
 
@@ -119,14 +119,14 @@ func TestMintCredentialsWithFlags_AllFlag(t *testing.T) {
 		Body:       io.NopCloser(bytes.NewReader(responseBody)),
 	}
 
-	mockClient.On("Post", "http://localhost:3000/credentials/mint", "application/json", mock.Anything).Return(resp, nil)
+	mockClient.On("Do", requestMatching(http.MethodPost, "http://localhost:3000/credentials/mint")).Return(resp, nil)
 
 	var stdout, stderr bytes.Buffer
 	cmd := &cobra.Command{}
 	cmd.SetOut(&stdout)
 	cmd.SetErr(&stderr)
 
-	err := mintCredentialsWithFlags(client, cmd, "test-token", "env", "test-idp", nil, 0, true)
+	err := mintCredentialsWithFlags(client, cmd, mintOptions{token: "test-token", format: "env", idpName: "test-idp", all: true})
 
 	assert.NoError(t, err)
 
@@ -146,7 +146,7 @@ func TestMintCredentialsWithFlags_NoToken(t *testing.T) {
 	_ = os.Unsetenv("OIDC_TOKEN")
 	_ = os.Unsetenv("GITHUB_TOKEN")
 
-	err := mintCredentialsWithFlags(client, cmd, "", "env", "", nil, 0, false)
+	err := mintCredentialsWithFlags(client, cmd, mintOptions{format: "env"})
 
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "OIDC token is required")
@@ -171,7 +171,7 @@ func TestMintCredentialsWithFlags_JSONOutput(t *testing.T) {
 		Body:       io.NopCloser(bytes.NewReader(responseBody)),
 	}
 
-	mockClient.On("Post", "http://localhost:3000/credentials/mint", "application/json", mock.Anything).Return(resp, nil)
+	mockClient.On("Do", requestMatching(http.MethodPost, "http://localhost:3000/credentials/mint")).Return(resp, nil)
 
 	var stdout, stderr bytes.Buffer
 	cmd := &cobra.Command{}
@@ -179,7 +179,7 @@ func TestMintCredentialsWithFlags_JSONOutput(t *testing.T) {
 	cmd.SetErr(&stderr)
 
 	keys := []string{"MINIO_CREDENTIALS"}
-	err := mintCredentialsWithFlags(client, cmd, "test-token", "json", "test-idp", keys, 0, false)
+	err := mintCredentialsWithFlags(client, cmd, mintOptions{token: "test-token", format: "json", idpName: "test-idp", keys: keys})
 
 	assert.NoError(t, err)
 
@@ -213,7 +213,7 @@ func TestMintCredentialsWithFlags_WithDuration(t *testing.T) {
 		Body:       io.NopCloser(bytes.NewReader(responseBody)),
 	}
 
-	mockClient.On("Post", "http://localhost:3000/credentials/mint", "application/json", mock.Anything).Return(resp, nil)
+	mockClient.On("Do", requestMatching(http.MethodPost, "http://localhost:3000/credentials/mint")).Return(resp, nil)
 
 	var stdout, stderr bytes.Buffer
 	cmd := &cobra.Command{}
@@ -222,7 +222,7 @@ func TestMintCredentialsWithFlags_WithDuration(t *testing.T) {
 
 	keys := []string{"MINIO_CREDENTIALS"}
 	duration := 1800 // 30 minutes
-	err := mintCredentialsWithFlags(client, cmd, "test-token", "env", "test-idp", keys, duration, false)
+	err := mintCredentialsWithFlags(client, cmd, mintOptions{token: "test-token", format: "env", idpName: "test-idp", keys: keys, duration: duration})
 
 	assert.NoError(t, err)
 
@@ -278,14 +278,14 @@ func TestMintCredentialsWithFlags_EnvironmentTokens(t *testing.T) {
 				Body:       io.NopCloser(bytes.NewReader(responseBody)),
 			}
 
-			mockClient.On("Post", "http://localhost:3000/credentials/mint", "application/json", mock.Anything).Return(resp, nil)
+			mockClient.On("Do", requestMatching(http.MethodPost, "http://localhost:3000/credentials/mint")).Return(resp, nil)
 
 			var stdout, stderr bytes.Buffer
 			cmd := &cobra.Command{}
 			cmd.SetOut(&stdout)
 			cmd.SetErr(&stderr)
 
-			err := mintCredentialsWithFlags(client, cmd, "", "env", "", []string{"MINIO_CREDENTIALS"}, 0, false)
+			err := mintCredentialsWithFlags(client, cmd, mintOptions{format: "env", keys: []string{"MINIO_CREDENTIALS"}})
 
 			assert.NoError(t, err)
 
@@ -298,66 +298,162 @@ func TestMintCredentialsWithFlags_EnvironmentTokens(t *testing.T) {
 	}
 }
 
-func TestOutputKeysAsEnvVars(t *testing.T) {
-	keyResponses := map[string]KeyCredentialResponse{
-		"MINIO_CREDENTIALS": {
-			Credentials: map[string]string{
-				"MINIO_ACCESS_KEY_ID":     "AKIAMINIO123",
-				"MINIO_SECRET_ACCESS_KEY": "miniosecret123",
-				"MINIO_ENDPOINT":          "http://localhost:9000",
-			},
-			ExpiresAt: "2025-01-01T12:00:00Z",
-		},
-		"AWS_CREDENTIALS": {
-			Credentials: map[string]string{
-				"AWS_ACCESS_KEY_ID":     "AKIAAWS123",
-				"AWS_SECRET_ACCESS_KEY": "awssecret123",
-			},
-			ExpiresAt: "2025-01-01T12:00:00Z",
-		},
-	}
+func TestMintCredentialsWithFlags_OutFile(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client := NewVoidkeyClient(mockClient, "http://localhost:3000")
+	MockSuccessfulMintResponse(mockClient, "http://localhost:3000", CreateTestKeyCredentials())
 
-	var stdout, stderr bytes.Buffer
-	cmd := &cobra.Command{}
-	cmd.SetOut(&stdout)
-	cmd.SetErr(&stderr)
+	cmd, stdout, stderr := SetupTestCommand()
+	path := filepath.Join(t.TempDir(), "creds.env")
 
-	outputKeysAsEnvVars(keyResponses, cmd)
+	err := mintCredentialsWithFlags(client, cmd, mintOptions{token: "test-token", format: "env", keys: []string{"MINIO_CREDENTIALS"}, outFile: path})
 
-	output := stdout.String()
-	assert.Contains(t, output, "export MINIO_ACCESS_KEY_ID=AKIAMINIO123")
-	assert.Contains(t, output, "export MINIO_SECRET_ACCESS_KEY=miniosecret123")
-	assert.Contains(t, output, "export MINIO_ENDPOINT=http://localhost:9000")
-	assert.Contains(t, output, "export AWS_ACCESS_KEY_ID=AKIAAWS123")
-	assert.Contains(t, output, "export AWS_SECRET_ACCESS_KEY=awssecret123")
+	assert.NoError(t, err)
+	assert.Empty(t, stdout.String())
+	assert.Contains(t, stderr.String(), "Credentials written to "+path)
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "export MINIO_ACCESS_KEY_ID=AKIATEST123456789\n")
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestMintCredentialsWithFlags_OutDir(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client := NewVoidkeyClient(mockClient, "http://localhost:3000")
+	MockSuccessfulMintResponse(mockClient, "http://localhost:3000", CreateTestKeyCredentials())
+
+	cmd, stdout, _ := SetupTestCommand()
+	dir := filepath.Join(t.TempDir(), "secrets")
+
+	err := mintCredentialsWithFlags(client, cmd, mintOptions{token: "test-token", format: "env", keys: []string{"MINIO_CREDENTIALS"}, outDir: dir})
+
+	assert.NoError(t, err)
+	assert.Empty(t, stdout.String())
+
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 4)
+
+	data, err := os.ReadFile(filepath.Join(dir, "MINIO_SECRET_ACCESS_KEY"))
+	assert.NoError(t, err)
+	assert.Equal(t, "wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY", string(data))
+
+	mockClient.AssertExpectations(t)
 }
 
-func TestOutputKeysAsJSON(t *testing.T) {
+func TestWriteKeysToDir_UnsafeNames(t *testing.T) {
+	for _, name := range []string{"../../home/u/.bashrc", "a/b", "..", "1ABC", "A.B"} {
+		t.Run(name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "secrets")
+			keyResponses := map[string]KeyCredentialResponse{
+				"KEY": {Credentials: map[string]string{"SAFE": "1", name: "secret"}},
+			}
+			cmd, _, _ := SetupTestCommand()
+
+			err := writeKeysToDir(dir, keyResponses, []string{"KEY"}, envOptions{}, cmd)
+
+			assert.ErrorContains(t, err, "not a valid file name")
+			entries, _ := os.ReadDir(dir)
+			assert.Empty(t, entries)
+		})
+	}
+}
+
+func TestWriteKeysToDir_FailureKeepsExistingFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "A"), []byte("old"), 0600))
+	// B cannot be replaced, so nothing is
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "B"), 0700))
 	keyResponses := map[string]KeyCredentialResponse{
-		"MINIO_CREDENTIALS": {
-			Credentials: map[string]string{
-				"MINIO_ACCESS_KEY_ID": "AKIAMINIO123",
-			},
-			ExpiresAt: "2025-01-01T12:00:00Z",
-		},
+		"KEY": {Credentials: map[string]string{"A": "new", "B": "new", "C": "new"}},
 	}
+	cmd, _, _ := SetupTestCommand()
 
-	var stdout bytes.Buffer
-	cmd := &cobra.Command{}
-	cmd.SetOut(&stdout)
+	err := writeKeysToDir(dir, keyResponses, []string{"KEY"}, envOptions{}, cmd)
 
-	outputKeysAsJSON(keyResponses, cmd)
+	assert.ErrorContains(t, err, "not a regular file")
+	data, err := os.ReadFile(filepath.Join(dir, "A"))
+	require.NoError(t, err)
+	assert.Equal(t, "old", string(data))
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 2)
+}
 
-	output := stdout.String()
+// partialMintBody is a 207 response where MINIO_CREDENTIALS was minted and
+// AWS_PROD was refused
+const partialMintBody = `{
+	"MINIO_CREDENTIALS": {"credentials": {"MINIO_ACCESS_KEY_ID": "AKIATEST123456789"}, "expiresAt": "2025-01-01T12:00:00Z"},
+	"AWS_PROD": {"error": {"code": "key_not_permitted", "message": "not allowed for this subject"}}
+}`
 
-	// Parse JSON to verify it's valid
-	var parsedResponse map[string]KeyCredentialResponse
-	err := json.Unmarshal([]byte(output), &parsedResponse)
-	assert.NoError(t, err)
-	assert.Contains(t, parsedResponse, "MINIO_CREDENTIALS")
-	assert.Equal(t, "AKIAMINIO123", parsedResponse["MINIO_CREDENTIALS"].Credentials["MINIO_ACCESS_KEY_ID"])
+func TestMintCredentialsWithFlags_FailOn(t *testing.T) {
+	tests := []struct {
+		name           string
+		failOn         string
+		format         string
+		expectedKind   error
+		expectedStdout []string
+		expectedStderr string
+	}{
+		{name: "any", failOn: failOnAny, format: "env", expectedKind: ErrForbiddenKey},
+		{name: "default", format: "env", expectedKind: ErrForbiddenKey},
+		{name: "partial", failOn: failOnPartial, format: "env", expectedKind: ErrPartialMint, expectedStdout: []string{"export MINIO_ACCESS_KEY_ID=AKIATEST123456789\n"}},
+		{name: "none", failOn: failOnNone, format: "env", expectedStdout: []string{"export MINIO_ACCESS_KEY_ID=AKIATEST123456789\n"}, expectedStderr: "Key AWS_PROD was not minted: not allowed for this subject"},
+		{name: "none json", failOn: failOnNone, format: "json", expectedStdout: []string{`"AKIATEST123456789"`, `"message": "not allowed for this subject"`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := &MockHTTPClient{}
+			client := NewVoidkeyClient(mockClient, "http://localhost:3000")
+			MockErrorResponse(mockClient, "POST", "http://localhost:3000/credentials/mint", http.StatusMultiStatus, partialMintBody)
+
+			cmd, stdout, stderr := SetupTestCommand()
+
+			err := mintCredentialsWithFlags(client, cmd, mintOptions{token: "test-token", format: tt.format, keys: []string{"MINIO_CREDENTIALS", "AWS_PROD"}, failOn: tt.failOn})
+
+			if tt.expectedKind != nil {
+				assert.ErrorIs(t, err, tt.expectedKind)
+				assert.Contains(t, err.Error(), "failed to mint 1 of 2 keys")
+				assert.Contains(t, err.Error(), "AWS_PROD: not allowed for this subject")
+			} else {
+				assert.NoError(t, err)
+			}
+			if len(tt.expectedStdout) == 0 {
+				assert.Empty(t, stdout.String())
+			}
+			for _, expected := range tt.expectedStdout {
+				assert.Contains(t, stdout.String(), expected)
+			}
+			assert.NotContains(t, stdout.String(), "export AWS_PROD")
+			assert.Contains(t, stderr.String(), tt.expectedStderr)
+
+			mockClient.AssertExpectations(t)
+		})
+	}
+}
+
+func TestMintCredentialsWithFlags_AllKeysFailed(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client := NewVoidkeyClient(mockClient, "http://localhost:3000")
+	MockErrorResponse(mockClient, "POST", "http://localhost:3000/credentials/mint", http.StatusMultiStatus,
+		`{"AWS_PROD": {"error": "not allowed for this subject"}}`)
+
+	cmd, stdout, _ := SetupTestCommand()
 
-	// Check that output is properly formatted JSON
-	assert.True(t, strings.Contains(output, "{\n"))
-	assert.True(t, strings.Contains(output, "  \"MINIO_CREDENTIALS\":"))
-}
\ No newline at end of file
+	err := mintCredentialsWithFlags(client, cmd, mintOptions{token: "test-token", format: "env", keys: []string{"AWS_PROD"}, failOn: failOnNone})
+
+	assert.ErrorIs(t, err, ErrRequestRejected)
+	assert.Contains(t, err.Error(), "AWS_PROD: not allowed for this subject")
+	assert.Empty(t, stdout.String())
+}
+
+func TestMintCredentialsWithFlags_InvalidFailOn(t *testing.T) {
+	cmd, _, _ := SetupTestCommand()
+
+	err := mintCredentialsWithFlags(nil, cmd, mintOptions{token: "test-token", keys: []string{"AWS_PROD"}, failOn: "some"})
+
+	assert.ErrorIs(t, err, ErrUsage)
+}
@@ -0,0 +1,220 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// Credential files are only ever readable by the user who minted them
+const (
+	credentialFileMode = 0600
+	credentialDirMode  = 0700
+)
+
+// credentialWriter writes credential files atomically and removes anything
+// it left behind when the process is interrupted half way through. Files are
+// staged as temporary files first and only renamed into place once all of
+// them were written, so a failed write never replaces any of them.
+type credentialWriter struct {
+	mu sync.Mutex
+	// pending holds temporary files that have not been renamed yet
+	pending map[string]struct{}
+	// staged holds the files to rename into place on commit
+	staged []stagedFile
+	// written holds the files completed by this writer that did not exist
+	// before. Files it replaced are kept on cleanup, as their old contents
+	// are gone.
+	written []string
+}
+
+// stagedFile is a temporary file waiting to replace path
+type stagedFile struct {
+	tmp     string
+	path    string
+	existed bool
+}
+
+func newCredentialWriter() *credentialWriter {
+	return &credentialWriter{pending: make(map[string]struct{})}
+}
+
+// run calls fn and, if SIGINT or SIGTERM arrives before fn returns, removes
+// every temporary and completed file before exiting, so an interrupted run
+// never leaves a partial set of secrets on disk
+func (w *credentialWriter) run(fn func() error) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			w.cleanup()
+			code := 130
+			if s, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(s)
+			}
+			os.Exit(code)
+		case <-done:
+		}
+	}()
+
+	err := fn()
+	if err != nil {
+		w.cleanup()
+	}
+	return err
+}
+
+// cleanup removes all pending files and the files this writer created
+func (w *credentialWriter) cleanup() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for path := range w.pending {
+		_ = os.Remove(path)
+	}
+	for _, path := range w.written {
+		_ = os.Remove(path)
+	}
+	w.pending = make(map[string]struct{})
+	w.staged = nil
+	w.written = nil
+}
+
+// writeFile atomically replaces path with data using credentialFileMode.
+// Existing files are only replaced when they are regular files owned by the
+// current user.
+func (w *credentialWriter) writeFile(path string, data []byte) error {
+	if err := w.stageFile(path, data); err != nil {
+		return err
+	}
+	return w.commit()
+}
+
+// stageFile writes data to a temporary file next to path, which commit
+// renames into place. The same checks as for writeFile apply.
+func (w *credentialWriter) stageFile(path string, data []byte) error {
+	if err := checkReplaceable(path); err != nil {
+		return err
+	}
+	_, err := os.Lstat(path)
+	existed := err == nil
+
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+base+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %s: %w", path, err)
+	}
+	tmpPath := tmp.Name()
+	w.track(tmpPath)
+
+	if err := w.fill(tmp, data); err != nil {
+		_ = os.Remove(tmpPath)
+		w.untrack(tmpPath)
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	w.mu.Lock()
+	w.staged = append(w.staged, stagedFile{tmp: tmpPath, path: path, existed: existed})
+	w.mu.Unlock()
+	return nil
+}
+
+// commit renames every staged file into place
+func (w *credentialWriter) commit() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for len(w.staged) > 0 {
+		file := w.staged[0]
+		if err := os.Rename(file.tmp, file.path); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.path, err)
+		}
+		delete(w.pending, file.tmp)
+		if !file.existed {
+			w.written = append(w.written, file.path)
+		}
+		w.staged = w.staged[1:]
+	}
+	return nil
+}
+
+// fill restricts the permissions of tmp, then writes and syncs data
+func (w *credentialWriter) fill(tmp *os.File, data []byte) error {
+	defer func() {
+		_ = tmp.Close()
+	}()
+
+	if err := tmp.Chmod(credentialFileMode); err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	return tmp.Close()
+}
+
+func (w *credentialWriter) track(path string) {
+	w.mu.Lock()
+	w.pending[path] = struct{}{}
+	w.mu.Unlock()
+}
+
+func (w *credentialWriter) untrack(path string) {
+	w.mu.Lock()
+	delete(w.pending, path)
+	w.mu.Unlock()
+}
+
+// checkReplaceable returns an error if path exists and must not be replaced
+func checkReplaceable(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s: %w", path, err)
+	}
+
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("refusing to overwrite %s: not a regular file", path)
+	}
+
+	return checkOwner(path, info)
+}
+
+// prepareOutputDir creates dir with credentialDirMode if needed and verifies
+// that an existing directory belongs to the current user
+func prepareOutputDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, credentialDirMode); err != nil {
+			return fmt.Errorf("failed to create output directory %s: %w", dir, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s: %w", dir, err)
+	}
+
+	if !info.IsDir() {
+		return fmt.Errorf("output directory %s is not a directory", dir)
+	}
+
+	return checkOwner(dir, info)
+}
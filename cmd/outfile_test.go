@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentialWriter_WriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "creds.env")
+	writer := newCredentialWriter()
+
+	err := writer.writeFile(path, []byte("export A=1\n"))
+
+	assert.NoError(t, err)
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "export A=1\n", string(data))
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(credentialFileMode), info.Mode().Perm())
+	}
+}
+
+func TestCredentialWriter_ReplacesOwnFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "creds.env")
+	assert.NoError(t, os.WriteFile(path, []byte("old"), 0644))
+
+	writer := newCredentialWriter()
+	assert.NoError(t, writer.writeFile(path, []byte("new")))
+
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, "new", string(data))
+
+	if runtime.GOOS != "windows" {
+		info, _ := os.Stat(path)
+		assert.Equal(t, os.FileMode(credentialFileMode), info.Mode().Perm())
+	}
+
+	// No temporary files are left behind
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 1)
+}
+
+func TestCredentialWriter_RefusesNonRegularFiles(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target")
+	assert.NoError(t, os.WriteFile(target, []byte("keep"), 0600))
+
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	writer := newCredentialWriter()
+	err := writer.writeFile(link, []byte("secret"))
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not a regular file")
+
+	data, _ := os.ReadFile(target)
+	assert.Equal(t, "keep", string(data))
+
+	err = writer.writeFile(dir, []byte("secret"))
+	assert.Error(t, err)
+}
+
+func TestCredentialWriter_RefusesForeignOwner(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Getuid() != 0 {
+		t.Skip("requires root to create a file owned by another user")
+	}
+
+	path := filepath.Join(t.TempDir(), "creds.env")
+	assert.NoError(t, os.WriteFile(path, []byte("theirs"), 0600))
+	assert.NoError(t, os.Chown(path, 65534, 65534))
+
+	writer := newCredentialWriter()
+	err := writer.writeFile(path, []byte("secret"))
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "owned by uid 65534")
+}
+
+func TestCredentialWriter_CleansUpOnError(t *testing.T) {
+	dir := t.TempDir()
+	writer := newCredentialWriter()
+
+	err := writer.run(func() error {
+		if err := writer.writeFile(filepath.Join(dir, "A"), []byte("1")); err != nil {
+			return err
+		}
+		// Writing into a missing directory fails half way through
+		return writer.writeFile(filepath.Join(dir, "missing", "B"), []byte("2"))
+	})
+
+	assert.Error(t, err)
+	entries, _ := os.ReadDir(dir)
+	assert.Empty(t, entries)
+}
+
+func TestPrepareOutputDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "secrets", "aws")
+
+	assert.NoError(t, prepareOutputDir(dir))
+
+	info, err := os.Stat(dir)
+	assert.NoError(t, err)
+	assert.True(t, info.IsDir())
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, os.FileMode(credentialDirMode), info.Mode().Perm())
+	}
+
+	// Existing directories are reused
+	assert.NoError(t, prepareOutputDir(dir))
+
+	file := filepath.Join(dir, "file")
+	assert.NoError(t, os.WriteFile(file, nil, 0600))
+	err = prepareOutputDir(file)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "is not a directory")
+}
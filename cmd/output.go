@@ -0,0 +1,240 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Key ordering modes for --key-order
+const (
+	keyOrderName    = "name"
+	keyOrderRequest = "request"
+)
+
+// Collision handling modes for --on-collision
+const (
+	collisionError  = "error"
+	collisionPrefix = "prefix"
+)
+
+// envOptions controls how credential variables are turned into environment
+// variable names
+type envOptions struct {
+	// collision is the collision handling mode, collisionError when empty
+	collision string
+	// keys holds the per-key configuration, used for variable renames
+	keys map[string]KeyConfig
+	// now is the current time by the broker's clock, used to show how long
+	// credentials remain valid; unset to not show it
+	now time.Time
+}
+
+// envVar is a single environment variable produced by a minted key
+type envVar struct {
+	key   string
+	name  string
+	value string
+}
+
+// orderKeyNames returns the key names of keyResponses in output order.
+// Keys are sorted by name unless order is keyOrderRequest, in which case the
+// requested order is kept and any keys the broker returned on top of the
+// request (e.g. with --all) follow sorted by name.
+func orderKeyNames(keyResponses map[string]KeyCredentialResponse, requested []string, order string) []string {
+	names := make([]string, 0, len(keyResponses))
+	seen := make(map[string]bool, len(keyResponses))
+
+	if order == keyOrderRequest {
+		for _, name := range requested {
+			if _, ok := keyResponses[name]; ok && !seen[name] {
+				names = append(names, name)
+				seen[name] = true
+			}
+		}
+	}
+
+	rest := make([]string, 0, len(keyResponses))
+	for name := range keyResponses {
+		if !seen[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+
+	return append(names, rest...)
+}
+
+// splitKeyResults splits keyNames into the keys that were minted and the
+// failures of the keys the broker reported an error for
+func splitKeyResults(keyResponses map[string]KeyCredentialResponse, keyNames []string) ([]string, []KeyFailure) {
+	var minted []string
+	var failures []KeyFailure
+	for _, name := range keyNames {
+		keyErr := keyResponses[name].Error
+		if keyErr == nil {
+			minted = append(minted, name)
+			continue
+		}
+		failures = append(failures, KeyFailure{
+			Key:    name,
+			Code:   keyErr.Code,
+			Reason: redactor.Redact(keyErr.Message),
+		})
+	}
+	return minted, failures
+}
+
+// sortedVarNames returns the variable names of a credential set sorted by name
+func sortedVarNames(credentials map[string]string) []string {
+	names := make([]string, 0, len(credentials))
+	for name := range credentials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveEnvVars flattens the credentials of keyNames into environment
+// variables, applying configured renames and detecting variables that more
+// than one key would set. Identical values are not a collision and are only
+// emitted once. Colliding variables are either reported as an error or, in
+// prefix mode, prefixed with the name of the key that produced them.
+func resolveEnvVars(keyResponses map[string]KeyCredentialResponse, keyNames []string, opts envOptions) ([]envVar, error) {
+	var vars []envVar
+	for _, keyName := range keyNames {
+		credentials := keyResponses[keyName].Credentials
+		renames := opts.keys[keyName].Env
+		for _, name := range sortedVarNames(credentials) {
+			exported := name
+			if renamed, ok := renames[name]; ok && renamed != "" {
+				exported = renamed
+			}
+			vars = append(vars, envVar{key: keyName, name: exported, value: credentials[name]})
+		}
+	}
+
+	owners := make(map[string][]envVar)
+	for _, v := range vars {
+		owners[v.name] = append(owners[v.name], v)
+	}
+
+	resolved := make([]envVar, 0, len(vars))
+	emitted := make(map[string]bool, len(vars))
+	for _, v := range vars {
+		if !collides(owners[v.name]) {
+			if !emitted[v.name] {
+				resolved = append(resolved, v)
+				emitted[v.name] = true
+			}
+			continue
+		}
+
+		switch opts.collision {
+		case "", collisionError:
+			return nil, collisionErr(v.name, owners[v.name])
+		case collisionPrefix:
+			v.name = v.key + "_" + v.name
+			resolved = append(resolved, v)
+		default:
+			return nil, fmt.Errorf("invalid --on-collision %q (must be %s or %s)", opts.collision, collisionError, collisionPrefix)
+		}
+	}
+
+	// Prefixing can itself produce a name that another key already exports
+	seen := make(map[string]string, len(resolved))
+	for _, v := range resolved {
+		if other, ok := seen[v.name]; ok {
+			return nil, fmt.Errorf("environment variable %s is set by keys %s and %s even after prefixing; rename one of them under keys.<KEY>.env in the config file", v.name, other, v.key)
+		}
+		seen[v.name] = v.key
+	}
+
+	return resolved, nil
+}
+
+// collides reports whether the variables sharing one name carry different values
+func collides(vars []envVar) bool {
+	for _, v := range vars[1:] {
+		if v.value != vars[0].value {
+			return true
+		}
+	}
+	return false
+}
+
+func collisionErr(name string, vars []envVar) error {
+	keys := make([]string, 0, len(vars))
+	for _, v := range vars {
+		keys = append(keys, v.key)
+	}
+	return fmt.Errorf("environment variable %s is set by multiple keys (%s); use --on-collision prefix or rename it under keys.<KEY>.env in the config file",
+		name, strings.Join(keys, ", "))
+}
+
+// Key-based output functions
+
+// outputKeysAsEnvVars writes export statements for the credentials to out and
+// progress information to the command's stderr
+func outputKeysAsEnvVars(out io.Writer, keyResponses map[string]KeyCredentialResponse, keyNames []string, opts envOptions, cmd *cobra.Command) error {
+	vars, err := resolveEnvVars(keyResponses, keyNames, opts)
+	if err != nil {
+		return err
+	}
+
+	totalVars := 0
+
+	for _, keyName := range keyNames {
+		response := keyResponses[keyName]
+		if expiry, ok := response.Expiry(); ok && !opts.now.IsZero() {
+			progressf(cmd, "🔑 Key: %s (expires: %s, in %s)", keyName, response.ExpiresAt, expiry.Sub(opts.now).Round(time.Second))
+		} else {
+			progressf(cmd, "🔑 Key: %s (expires: %s)", keyName, response.ExpiresAt)
+		}
+
+		for _, v := range vars {
+			if v.key != keyName {
+				continue
+			}
+			_, _ = fmt.Fprintf(out, "export %s=%s\n", v.name, v.value)
+			totalVars++
+		}
+	}
+
+	// Print success message to stderr so it doesn't interfere with sourcing
+	progressf(cmd, "✅ Successfully minted %d keys with %d environment variables", len(keyNames), totalVars)
+
+	return nil
+}
+
+// outputKeysAsJSON writes the credentials to out as a JSON object indexed by
+// key name. Keys that were not minted are included with their error.
+func outputKeysAsJSON(out io.Writer, keyResponses map[string]KeyCredentialResponse, keyNames []string) {
+	// encoding/json sorts map keys, so the top-level object is assembled by
+	// hand to honour the requested key order
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i, keyName := range keyNames {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		name, _ := json.Marshal(keyName)
+		value, _ := json.Marshal(keyResponses[keyName])
+		buf.WriteString("\n  ")
+		buf.Write(name)
+		buf.WriteString(": ")
+		_ = json.Indent(&buf, value, "  ", "  ")
+	}
+	if len(keyNames) > 0 {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}")
+
+	_, _ = fmt.Fprintf(out, "%s\n", buf.String())
+}
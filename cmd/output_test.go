@@ -0,0 +1,341 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputKeysAsEnvVars(t *testing.T) {
+	keyResponses := map[string]KeyCredentialResponse{
+		"MINIO_CREDENTIALS": {
+			Credentials: map[string]string{
+				"MINIO_ACCESS_KEY_ID":     "AKIAMINIO123",
+				"MINIO_SECRET_ACCESS_KEY": "miniosecret123",
+				"MINIO_ENDPOINT":          "http://localhost:9000",
+			},
+			ExpiresAt: "2025-01-01T12:00:00Z",
+		},
+		"AWS_CREDENTIALS": {
+			Credentials: map[string]string{
+				"AWS_ACCESS_KEY_ID":     "AKIAAWS123",
+				"AWS_SECRET_ACCESS_KEY": "awssecret123",
+			},
+			ExpiresAt: "2025-01-01T12:00:00Z",
+		},
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+
+	err := outputKeysAsEnvVars(cmd.OutOrStdout(), keyResponses, orderKeyNames(keyResponses, nil, keyOrderName), envOptions{}, cmd)
+	assert.NoError(t, err)
+
+	output := stdout.String()
+	assert.Contains(t, output, "export MINIO_ACCESS_KEY_ID=AKIAMINIO123")
+	assert.Contains(t, output, "export MINIO_SECRET_ACCESS_KEY=miniosecret123")
+	assert.Contains(t, output, "export MINIO_ENDPOINT=http://localhost:9000")
+	assert.Contains(t, output, "export AWS_ACCESS_KEY_ID=AKIAAWS123")
+	assert.Contains(t, output, "export AWS_SECRET_ACCESS_KEY=awssecret123")
+}
+
+func TestOutputKeysAsJSON(t *testing.T) {
+	keyResponses := map[string]KeyCredentialResponse{
+		"MINIO_CREDENTIALS": {
+			Credentials: map[string]string{
+				"MINIO_ACCESS_KEY_ID": "AKIAMINIO123",
+			},
+			ExpiresAt: "2025-01-01T12:00:00Z",
+		},
+	}
+
+	var stdout bytes.Buffer
+	cmd := &cobra.Command{}
+	cmd.SetOut(&stdout)
+
+	outputKeysAsJSON(cmd.OutOrStdout(), keyResponses, orderKeyNames(keyResponses, nil, keyOrderName))
+
+	output := stdout.String()
+
+	// Parse JSON to verify it's valid
+	var parsedResponse map[string]KeyCredentialResponse
+	err := json.Unmarshal([]byte(output), &parsedResponse)
+	assert.NoError(t, err)
+	assert.Contains(t, parsedResponse, "MINIO_CREDENTIALS")
+	assert.Equal(t, "AKIAMINIO123", parsedResponse["MINIO_CREDENTIALS"].Credentials["MINIO_ACCESS_KEY_ID"])
+
+	// Check that output is properly formatted JSON
+	assert.True(t, strings.Contains(output, "{\n"))
+	assert.True(t, strings.Contains(output, "  \"MINIO_CREDENTIALS\":"))
+}
+func TestOrderKeyNames(t *testing.T) {
+	keyResponses := map[string]KeyCredentialResponse{
+		"MINIO_CREDENTIALS": {},
+		"AWS_CREDENTIALS":   {},
+		"GCP_CREDENTIALS":   {},
+	}
+
+	tests := []struct {
+		name      string
+		requested []string
+		order     string
+		expected  []string
+	}{
+		{
+			name:      "sorted by name",
+			requested: []string{"MINIO_CREDENTIALS", "AWS_CREDENTIALS", "GCP_CREDENTIALS"},
+			order:     keyOrderName,
+			expected:  []string{"AWS_CREDENTIALS", "GCP_CREDENTIALS", "MINIO_CREDENTIALS"},
+		},
+		{
+			name:      "empty order sorts by name",
+			requested: []string{"MINIO_CREDENTIALS", "AWS_CREDENTIALS"},
+			order:     "",
+			expected:  []string{"AWS_CREDENTIALS", "GCP_CREDENTIALS", "MINIO_CREDENTIALS"},
+		},
+		{
+			name:      "request order",
+			requested: []string{"MINIO_CREDENTIALS", "AWS_CREDENTIALS", "GCP_CREDENTIALS"},
+			order:     keyOrderRequest,
+			expected:  []string{"MINIO_CREDENTIALS", "AWS_CREDENTIALS", "GCP_CREDENTIALS"},
+		},
+		{
+			name:      "request order with unrequested and missing keys",
+			requested: []string{"MINIO_CREDENTIALS", "UNKNOWN", "MINIO_CREDENTIALS"},
+			order:     keyOrderRequest,
+			expected:  []string{"MINIO_CREDENTIALS", "AWS_CREDENTIALS", "GCP_CREDENTIALS"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, orderKeyNames(keyResponses, tt.requested, tt.order))
+		})
+	}
+}
+
+func TestOutputKeysAsEnvVars_SortedOutput(t *testing.T) {
+	keyResponses := map[string]KeyCredentialResponse{
+		"MINIO_CREDENTIALS": {
+			Credentials: map[string]string{
+				"MINIO_SECRET_ACCESS_KEY": "miniosecret123",
+				"MINIO_ACCESS_KEY_ID":     "AKIAMINIO123",
+			},
+		},
+		"AWS_CREDENTIALS": {
+			Credentials: map[string]string{
+				"AWS_SECRET_ACCESS_KEY": "awssecret123",
+				"AWS_ACCESS_KEY_ID":     "AKIAAWS123",
+			},
+		},
+	}
+
+	expected := "export AWS_ACCESS_KEY_ID=AKIAAWS123\n" +
+		"export AWS_SECRET_ACCESS_KEY=awssecret123\n" +
+		"export MINIO_ACCESS_KEY_ID=AKIAMINIO123\n" +
+		"export MINIO_SECRET_ACCESS_KEY=miniosecret123\n"
+
+	cmd, stdout, _ := SetupTestCommand()
+	assert.NoError(t, outputKeysAsEnvVars(cmd.OutOrStdout(), keyResponses, orderKeyNames(keyResponses, nil, keyOrderName), envOptions{}, cmd))
+	assert.Equal(t, expected, stdout.String())
+
+	// Request order keeps keys as passed to --keys, variables stay sorted
+	expected = "export MINIO_ACCESS_KEY_ID=AKIAMINIO123\n" +
+		"export MINIO_SECRET_ACCESS_KEY=miniosecret123\n" +
+		"export AWS_ACCESS_KEY_ID=AKIAAWS123\n" +
+		"export AWS_SECRET_ACCESS_KEY=awssecret123\n"
+
+	cmd, stdout, _ = SetupTestCommand()
+	requested := []string{"MINIO_CREDENTIALS", "AWS_CREDENTIALS"}
+	assert.NoError(t, outputKeysAsEnvVars(cmd.OutOrStdout(), keyResponses, orderKeyNames(keyResponses, requested, keyOrderRequest), envOptions{}, cmd))
+	assert.Equal(t, expected, stdout.String())
+}
+
+func TestOutputFormatters_Deterministic(t *testing.T) {
+	keyResponses := map[string]KeyCredentialResponse{
+		"MINIO_CREDENTIALS": CreateTestKeyCredentials()["MINIO_CREDENTIALS"],
+		"AWS_CREDENTIALS": {
+			Credentials: map[string]string{
+				"AWS_ACCESS_KEY_ID":     "AKIAAWS123",
+				"AWS_SECRET_ACCESS_KEY": "awssecret123",
+				"AWS_SESSION_TOKEN":     "awssession123",
+				"AWS_REGION":            "eu-west-1",
+			},
+			ExpiresAt: "2025-01-01T12:00:00Z",
+		},
+		"GCP_CREDENTIALS": {
+			Credentials: map[string]string{
+				"GOOGLE_OAUTH_ACCESS_TOKEN": "ya29.token",
+				"GOOGLE_CLOUD_PROJECT":      "test-project",
+			},
+			ExpiresAt: "2025-01-01T12:00:00Z",
+		},
+	}
+	requested := []string{"MINIO_CREDENTIALS", "GCP_CREDENTIALS", "AWS_CREDENTIALS"}
+
+	formatters := map[string]func(map[string]KeyCredentialResponse, []string, *cobra.Command){
+		"env": func(keyResponses map[string]KeyCredentialResponse, keyNames []string, cmd *cobra.Command) {
+			assert.NoError(t, outputKeysAsEnvVars(cmd.OutOrStdout(), keyResponses, keyNames, envOptions{}, cmd))
+		},
+		"json": func(keyResponses map[string]KeyCredentialResponse, keyNames []string, cmd *cobra.Command) {
+			outputKeysAsJSON(cmd.OutOrStdout(), keyResponses, keyNames)
+		},
+	}
+
+	for name, formatter := range formatters {
+		for _, order := range []string{keyOrderName, keyOrderRequest} {
+			t.Run(name+"/"+order, func(t *testing.T) {
+				var first []byte
+				for i := 0; i < 50; i++ {
+					cmd, stdout, _ := SetupTestCommand()
+					formatter(keyResponses, orderKeyNames(keyResponses, requested, order), cmd)
+
+					if i == 0 {
+						first = stdout.Bytes()
+						continue
+					}
+					if !bytes.Equal(first, stdout.Bytes()) {
+						t.Fatalf("Output differs on run %d:\n%s\nvs\n%s", i, first, stdout.Bytes())
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestOutputKeysAsJSON_RequestOrder(t *testing.T) {
+	keyResponses := map[string]KeyCredentialResponse{
+		"MINIO_CREDENTIALS": {Credentials: map[string]string{"MINIO_ACCESS_KEY_ID": "AKIAMINIO123"}},
+		"AWS_CREDENTIALS":   {Credentials: map[string]string{"AWS_ACCESS_KEY_ID": "AKIAAWS123"}},
+	}
+
+	cmd, stdout, _ := SetupTestCommand()
+	requested := []string{"MINIO_CREDENTIALS", "AWS_CREDENTIALS"}
+	outputKeysAsJSON(cmd.OutOrStdout(), keyResponses, orderKeyNames(keyResponses, requested, keyOrderRequest))
+
+	output := stdout.String()
+	assert.Less(t, strings.Index(output, "MINIO_CREDENTIALS"), strings.Index(output, "AWS_CREDENTIALS"))
+
+	var parsedResponse map[string]KeyCredentialResponse
+	assert.NoError(t, json.Unmarshal(stdout.Bytes(), &parsedResponse))
+	assert.Len(t, parsedResponse, 2)
+
+	// Name order matches what encoding/json produces for the whole map
+	cmd, stdout, _ = SetupTestCommand()
+	outputKeysAsJSON(cmd.OutOrStdout(), keyResponses, orderKeyNames(keyResponses, requested, keyOrderName))
+	expected, _ := json.MarshalIndent(keyResponses, "", "  ")
+	assert.Equal(t, string(expected)+"\n", stdout.String())
+}
+
+func TestResolveEnvVars_Collisions(t *testing.T) {
+	keyResponses := map[string]KeyCredentialResponse{
+		"AWS_PROD": {
+			Credentials: map[string]string{
+				"AWS_ACCESS_KEY_ID": "AKIAPROD",
+				"AWS_REGION":        "eu-west-1",
+			},
+		},
+		"AWS_STAGING": {
+			Credentials: map[string]string{
+				"AWS_ACCESS_KEY_ID": "AKIASTAGING",
+				"AWS_REGION":        "eu-west-1",
+			},
+		},
+	}
+	keyNames := orderKeyNames(keyResponses, nil, keyOrderName)
+
+	tests := []struct {
+		name        string
+		opts        envOptions
+		expected    []envVar
+		expectedErr string
+	}{
+		{
+			name:        "error by default",
+			opts:        envOptions{},
+			expectedErr: "environment variable AWS_ACCESS_KEY_ID is set by multiple keys (AWS_PROD, AWS_STAGING)",
+		},
+		{
+			name: "prefix colliding variables",
+			opts: envOptions{collision: collisionPrefix},
+			expected: []envVar{
+				{key: "AWS_PROD", name: "AWS_PROD_AWS_ACCESS_KEY_ID", value: "AKIAPROD"},
+				{key: "AWS_PROD", name: "AWS_REGION", value: "eu-west-1"},
+				{key: "AWS_STAGING", name: "AWS_STAGING_AWS_ACCESS_KEY_ID", value: "AKIASTAGING"},
+			},
+		},
+		{
+			name: "rename through key config",
+			opts: envOptions{keys: map[string]KeyConfig{
+				"AWS_STAGING": {Env: map[string]string{"AWS_ACCESS_KEY_ID": "STAGING_AWS_ACCESS_KEY_ID"}},
+			}},
+			expected: []envVar{
+				{key: "AWS_PROD", name: "AWS_ACCESS_KEY_ID", value: "AKIAPROD"},
+				{key: "AWS_PROD", name: "AWS_REGION", value: "eu-west-1"},
+				{key: "AWS_STAGING", name: "STAGING_AWS_ACCESS_KEY_ID", value: "AKIASTAGING"},
+			},
+		},
+		{
+			name: "rename into a collision",
+			opts: envOptions{keys: map[string]KeyConfig{
+				"AWS_STAGING": {Env: map[string]string{
+					"AWS_ACCESS_KEY_ID": "STAGING_AWS_ACCESS_KEY_ID",
+					"AWS_REGION":        "AWS_ACCESS_KEY_ID",
+				}},
+			}},
+			expectedErr: "environment variable AWS_ACCESS_KEY_ID is set by multiple keys",
+		},
+		{
+			name:        "invalid mode",
+			opts:        envOptions{collision: "last-wins"},
+			expectedErr: "invalid --on-collision",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars, err := resolveEnvVars(keyResponses, keyNames, tt.opts)
+
+			if tt.expectedErr != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectedErr)
+				assert.Nil(t, vars)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, vars)
+		})
+	}
+}
+
+func TestResolveEnvVars_PrefixStillCollides(t *testing.T) {
+	keyResponses := map[string]KeyCredentialResponse{
+		"A": {Credentials: map[string]string{"TOKEN": "one"}},
+		"B": {Credentials: map[string]string{"TOKEN": "two", "A_TOKEN": "three"}},
+	}
+
+	_, err := resolveEnvVars(keyResponses, orderKeyNames(keyResponses, nil, keyOrderName), envOptions{collision: collisionPrefix})
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "even after prefixing")
+}
+
+func TestOutputKeysAsEnvVars_CollisionWritesNothing(t *testing.T) {
+	keyResponses := map[string]KeyCredentialResponse{
+		"AWS_PROD":    {Credentials: map[string]string{"AWS_ACCESS_KEY_ID": "AKIAPROD"}},
+		"AWS_STAGING": {Credentials: map[string]string{"AWS_ACCESS_KEY_ID": "AKIASTAGING"}},
+	}
+
+	cmd, stdout, _ := SetupTestCommand()
+	err := outputKeysAsEnvVars(cmd.OutOrStdout(), keyResponses, orderKeyNames(keyResponses, nil, keyOrderName), envOptions{}, cmd)
+
+	assert.Error(t, err)
+	assert.Empty(t, stdout.String())
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkOwner returns an error if the file described by info is owned by
+// another user
+func checkOwner(path string, info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+
+	if uid := os.Getuid(); stat.Uid != uint32(uid) {
+		return fmt.Errorf("refusing to overwrite %s: owned by uid %d, not the current user (uid %d)", path, stat.Uid, uid)
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// redactedPlaceholder replaces secret values in redacted text
+const redactedPlaceholder = "[REDACTED]"
+
+// minSecretLength is the shortest value the redactor tracks. Shorter values
+// would mostly match unrelated text.
+const minSecretLength = 4
+
+// Redactor scrubs every secret it has been told about from text before it
+// reaches stderr, error messages or logs
+type Redactor struct {
+	mu      sync.RWMutex
+	secrets []string
+}
+
+// redactor is the process-wide redactor. Tokens and credential values are
+// registered as soon as the CLI sees them.
+var redactor = &Redactor{}
+
+// Add registers secrets to be scrubbed from all further output
+func (r *Redactor) Add(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, secret := range secrets {
+		if len(secret) < minSecretLength || r.knows(secret) {
+			continue
+		}
+		r.secrets = append(r.secrets, secret)
+	}
+
+	// Longest first, so a secret containing another one is replaced whole
+	sort.SliceStable(r.secrets, func(i, j int) bool {
+		return len(r.secrets[i]) > len(r.secrets[j])
+	})
+}
+
+// AddCredentials registers every credential value of the key responses
+func (r *Redactor) AddCredentials(keyResponses map[string]KeyCredentialResponse) {
+	for _, response := range keyResponses {
+		for _, value := range response.Credentials {
+			r.Add(value)
+		}
+	}
+}
+
+func (r *Redactor) knows(secret string) bool {
+	for _, known := range r.secrets {
+		if known == secret {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact returns s with every registered secret replaced by a placeholder
+func (r *Redactor) Redact(s string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, redactedPlaceholder)
+	}
+	return s
+}
+
+// RedactError returns an error whose message has every registered secret
+// replaced. errors.Is and errors.As still see the original error.
+func (r *Redactor) RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &redactedError{msg: r.Redact(err.Error()), err: err}
+}
+
+// Writer returns a writer that redacts everything written to w
+func (r *Redactor) Writer(w io.Writer) io.Writer {
+	return &redactingWriter{redactor: r, w: w}
+}
+
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// redactingWriter scrubs secrets from each write. Callers write whole lines,
+// so secrets are never split across writes.
+type redactingWriter struct {
+	redactor *Redactor
+	w        io.Writer
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, w.redactor.Redact(string(p))); err != nil {
+		return 0, err
+	}
+	// Report the original length, the redacted text may differ in size
+	return len(p), nil
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactor_Redact(t *testing.T) {
+	r := &Redactor{}
+	r.Add("super-secret-token", "secret", "abc", "")
+
+	assert.Equal(t, "token=[REDACTED] and [REDACTED]", r.Redact("token=super-secret-token and secret"))
+	// Values shorter than minSecretLength are not tracked
+	assert.Equal(t, "abc", r.Redact("abc"))
+}
+
+func TestRedactor_AddCredentials(t *testing.T) {
+	r := &Redactor{}
+	r.AddCredentials(CreateTestKeyCredentials())
+
+	output := r.Redact("key AKIATEST123456789 secret wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY")
+
+	assert.NotContains(t, output, "AKIATEST123456789")
+	assert.NotContains(t, output, "wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY")
+}
+
+func TestRedactor_RedactError(t *testing.T) {
+	r := &Redactor{}
+	r.Add("super-secret-token")
+
+	sentinel := errors.New("request with super-secret-token failed")
+	err := r.RedactError(sentinel)
+
+	assert.Equal(t, "request with [REDACTED] failed", err.Error())
+	assert.ErrorIs(t, err, sentinel)
+	assert.Nil(t, r.RedactError(nil))
+}
+
+func TestRedactor_Writer(t *testing.T) {
+	r := &Redactor{}
+	r.Add("super-secret-token")
+
+	var buf bytes.Buffer
+	w := r.Writer(&buf)
+	n, err := w.Write([]byte("using super-secret-token\n"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, len("using super-secret-token\n"), n)
+	assert.Equal(t, "using [REDACTED]\n", buf.String())
+}
+
+func TestRedaction_TokenNeverReachesStderr(t *testing.T) {
+	const token = "eyJhbGciOiJSUzI1NiJ9.leaky-test-token.signature"
+
+	mockClient := &MockHTTPClient{}
+	client := NewVoidkeyClient(mockClient, "http://localhost:3000")
+
+	// A broker that echoes the token back in its error message
+	MockErrorResponse(mockClient, "POST", "http://localhost:3000/credentials/mint", http.StatusUnauthorized,
+		`{"error":"invalid_token","message":"invalid token `+token+`"}`)
+
+	root := &cobra.Command{Use: "voidkey"}
+	root.AddCommand(mintCreds(client))
+
+	var stdout, stderr bytes.Buffer
+	root.SetOut(&stdout)
+	root.SetErr(redactor.Writer(&stderr))
+	root.SetArgs([]string{"mint", "--token", token, "--keys", "MINIO_CREDENTIALS"})
+
+	err := root.Execute()
+
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), token)
+	assert.NotContains(t, stderr.String(), token)
+	assert.NotContains(t, stdout.String(), token)
+	assert.Contains(t, stderr.String(), redactedPlaceholder)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestRedaction_ConnectionErrorWithTokenInURL(t *testing.T) {
+	const token = "leaky-query-token"
+
+	mockClient := &MockHTTPClient{}
+	client := NewVoidkeyClient(mockClient, "http://localhost:3000")
+
+	url := "http://localhost:3000/credentials/keys?token=" + token
+	mockClient.On("Do", requestMatching(http.MethodGet, url)).Return((*http.Response)(nil), errors.New(`Get "`+url+`": connection refused`))
+
+	_, err := client.GetAvailableKeys(context.Background(), token)
+
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), token)
+
+	mockClient.AssertExpectations(t)
+}
@@ -0,0 +1,12 @@
+package cmd
+
+import "github.com/voidkey-oss/cli/pkg/voidkey"
+
+// defaultRetries is the number of retries after a failed broker request
+// unless --retries or the config file says otherwise
+const defaultRetries = 3
+
+// newRetryPolicy returns the CLI's retry policy with the given number of retries
+func newRetryPolicy(retries int) RetryPolicy {
+	return voidkey.DefaultRetryPolicy.WithRetries(retries)
+}
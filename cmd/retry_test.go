@@ -0,0 +1,15 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+)
+
+func TestNewRetryPolicy(t *testing.T) {
+	assert.Equal(t, voidkey.DefaultRetryPolicy, newRetryPolicy(defaultRetries))
+	assert.Equal(t, 4, newRetryPolicy(3).MaxAttempts)
+	assert.Equal(t, 1, newRetryPolicy(0).MaxAttempts)
+	assert.Equal(t, 1, newRetryPolicy(-2).MaxAttempts)
+}
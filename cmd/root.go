@@ -1,14 +1,49 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/voidkey-oss/cli/internal/agent"
+	"github.com/voidkey-oss/cli/pkg/voidkey"
 )
 
+// agentAnnotation marks commands that get their credentials from the agent
+// when VOIDKEY_AGENT_SOCK is set
+const agentAnnotation = "voidkey.agent"
+
+// defaultTimeout bounds each broker request unless --timeout or the config
+// file says otherwise
+const defaultTimeout = 30 * time.Second
+
 var (
 	serverURL string
+	timeout   time.Duration
+	retries   int
+
+	errorFormat string
+
+	// TLS flags, see TLSConfig
+	caFile             string
+	clientCert         string
+	clientKey          string
+	pinSHA256          []string
+	insecureSkipVerify bool
+
+	allowInsecureHTTP bool
+
+	proxyURL string
+
+	// brokerClient is shared by all commands and configured once flags are parsed
+	brokerClient *VoidkeyClient
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -17,20 +52,234 @@ var rootCmd = &cobra.Command{
 	Short: "Voidkey zero-trust credential broker CLI",
 	Long: `Voidkey is a zero-trust credential broker that eliminates long-lived secrets 
 in workflows like CI/CD pipelines by dynamically minting short-lived, scoped credentials using OIDC-based authentication.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		switch errorFormat {
+		case errorFormatText:
+		case errorFormatJSON:
+			// Usage text would break the single JSON document on stderr
+			cmd.SilenceUsage = true
+		default:
+			return usageErrorf("invalid --error-format %q (must be %s or %s)", errorFormat, errorFormatText, errorFormatJSON)
+		}
+
+		opts, err := resolveLogOptions(verbose, quiet, logFormat)
+		if err != nil {
+			return err
+		}
+		logOpts = opts
+
+		path, explicit := resolveConfigFile()
+		loaded, err := loadConfig(path, explicit)
+		if err != nil {
+			return err
+		}
+		cfg, err = loaded.withProfile(resolveProfile())
+		if err != nil {
+			return err
+		}
+		logFor(cmd).Debug("loaded configuration", "path", path, "explicit", explicit, "profile", cfg.Profile)
+
+		if brokerClient == nil {
+			return nil
+		}
+		if socket := agentSocket(cmd); socket != "" {
+			brokerClient.UseAgent(socket, resolveTimeout(cmd))
+			brokerClient.SetLogger(logFor(cmd))
+			progressf(cmd, "🔍 Using voidkey agent at %s", socket)
+			return nil
+		}
+		return configureClient(cmd, brokerClient)
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	err := rootCmd.Execute()
+	// Everything written to stderr, including errors printed by cobra,
+	// passes through the redactor so tokens and credentials never leak
+	rootCmd.SetErr(redactor.Writer(os.Stderr))
+
+	// Installed as docker-credential-voidkey or git-credential-voidkey, the
+	// CLI is a Docker or Git credential helper
+	if args, ok := credentialHelperArgs(os.Args[0], os.Args[1:]); ok {
+		rootCmd.SetArgs(args)
+	}
+
+	// Ctrl-C and SIGTERM cancel in-flight broker requests
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, rootCmd)
+	stop()
+	if code != exitOK {
+		os.Exit(code)
+	}
+}
+
+// run executes cmd, prints any error in the format chosen with
+// --error-format and returns the exit code for it
+func run(ctx context.Context, cmd *cobra.Command) int {
+	cmd.SilenceErrors = true
+
+	err := cmd.ExecuteContext(ctx)
+	if err == nil {
+		return exitOK
+	}
+
+	var childErr *childExitError
+	if errors.As(err, &childErr) {
+		return childErr.code
+	}
+	printError(cmd.ErrOrStderr(), err, errorFormat)
+	code, _ := classifyError(err)
+	return code
+}
+
+// credentialHelperArgs returns the arguments of the credential helper command
+// for a CLI invoked as argv0 with args, if argv0 names it a Docker or Git
+// credential helper
+func credentialHelperArgs(argv0 string, args []string) ([]string, bool) {
+	name := strings.TrimSuffix(filepath.Base(argv0), ".exe")
+	for _, helper := range []string{"docker-credential", "git-credential"} {
+		if strings.HasPrefix(name, helper+"-") {
+			return append([]string{helper}, args...), true
+		}
+	}
+	return nil, false
+}
+
+// agentSocket returns the socket of the agent cmd should get credentials
+// from, or "" to talk to the broker. Commands opt in with agentAnnotation;
+// an explicit --server or --token bypasses the agent.
+func agentSocket(cmd *cobra.Command) string {
+	if cmd.Annotations[agentAnnotation] == "" {
+		return ""
+	}
+	for _, name := range []string{"server", "token"} {
+		if flag := cmd.Flags().Lookup(name); flag != nil && flag.Changed {
+			return ""
+		}
+	}
+	return os.Getenv(agent.SocketEnv)
+}
+
+// configureClient applies the parsed flags and configuration file to client.
+// Flags win over the configuration file, which wins over the defaults.
+func configureClient(cmd *cobra.Command, client *VoidkeyClient) error {
+	server := serverURL
+	if !cmd.Flags().Changed("server") && cfg.Server != "" {
+		server = cfg.Server
+	}
+
+	requestTimeout := resolveTimeout(cmd)
+
+	requestRetries := retries
+	if !cmd.Flags().Changed("retries") && cfg.Retries != nil {
+		requestRetries = *cfg.Retries
+	}
+
+	parsed, err := parseServerURL(server)
+	if err != nil {
+		return err
+	}
+	if err := checkServerSecurity(parsed, allowInsecureHTTP); err != nil {
+		return err
+	}
+
+	proxy := proxyURL
+	if !cmd.Flags().Changed("proxy") && cfg.Proxy != "" {
+		proxy = cfg.Proxy
+	}
+
+	tlsConfig := resolveTLSConfig(cmd)
+	transport, err := newTransport(tlsConfig)
 	if err != nil {
-		os.Exit(1)
+		return err
+	}
+	if err := routeTransport(transport, parsed, proxy); err != nil {
+		return err
+	}
+	if tlsConfig.InsecureSkipVerify {
+		logFor(cmd).Warn("⚠️  WARNING: TLS certificate verification is disabled (--insecure-skip-verify). " +
+			"Anyone on the network path can impersonate the broker and steal your token and credentials. Use this for local development only!")
+	}
+
+	client.SetServerURL(server)
+	client.SetHTTPClient(&http.Client{
+		Timeout:       requestTimeout,
+		Transport:     transport,
+		CheckRedirect: voidkey.CheckRedirect,
+	})
+	client.SetRetryPolicy(newRetryPolicy(requestRetries))
+	client.SetLogger(logFor(cmd))
+	client.EnableDiscovery(voidkey.DefaultDiscoveryCacheDir())
+	client.EnableCredentialCache(voidkey.DefaultCredentialCacheDir())
+	logFor(cmd).Debug("configured broker client", "server", server, "timeout", requestTimeout, "retries", requestRetries, "proxy", redactedURL(proxy),
+		"caFile", tlsConfig.CAFile, "clientCert", tlsConfig.ClientCert, "pins", len(tlsConfig.PinSHA256))
+	return nil
+}
+
+// resolveTimeout returns the request timeout from --timeout, falling back to
+// the configuration file if the flag was not given
+func resolveTimeout(cmd *cobra.Command) time.Duration {
+	if !cmd.Flags().Changed("timeout") && cfg.Timeout > 0 {
+		return cfg.Timeout
+	}
+	return timeout
+}
+
+// resolveTLSConfig returns the TLS settings from the flags, falling back to
+// the configuration file for flags that were not given
+func resolveTLSConfig(cmd *cobra.Command) TLSConfig {
+	config := cfg.TLS
+	if cmd.Flags().Changed("ca-file") {
+		config.CAFile = caFile
+	}
+	if cmd.Flags().Changed("client-cert") {
+		config.ClientCert = clientCert
 	}
+	if cmd.Flags().Changed("client-key") {
+		config.ClientKey = clientKey
+	}
+	if cmd.Flags().Changed("pin-sha256") {
+		config.PinSHA256 = pinSHA256
+	}
+	if cmd.Flags().Changed("insecure-skip-verify") {
+		config.InsecureSkipVerify = insecureSkipVerify
+	}
+	return config
+}
+
+// commandContext returns the context of cmd, or a background context for
+// commands that are run without one
+func commandContext(cmd *cobra.Command) context.Context {
+	if ctx := cmd.Context(); ctx != nil {
+		return ctx
+	}
+	return context.Background()
 }
 
 func init() {
 	// Global flags
-	rootCmd.PersistentFlags().StringVar(&serverURL, "server", "http://localhost:3000", "Voidkey broker server URL")
+	rootCmd.PersistentFlags().StringVar(&serverURL, "server", "http://localhost:3000", "Voidkey broker server URL, or unix:///path/to.sock for a broker on a unix socket")
+	rootCmd.PersistentFlags().DurationVar(&timeout, "timeout", defaultTimeout, "Timeout for each request to the broker server")
+	rootCmd.PersistentFlags().IntVar(&retries, "retries", defaultRetries, "Number of retries for failed broker requests (connection errors, 429 and 5xx)")
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "", "Config file (default $HOME/.voidkey/config.yaml, or $VOIDKEY_CONFIG)")
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "Config file profile to use (or $VOIDKEY_PROFILE)")
+	rootCmd.PersistentFlags().StringVar(&caFile, "ca-file", "", "PEM file of CA certificates to trust for the broker, in addition to the system ones")
+	rootCmd.PersistentFlags().StringVar(&clientCert, "client-cert", "", "PEM client certificate for brokers that require mutual TLS")
+	rootCmd.PersistentFlags().StringVar(&clientKey, "client-key", "", "PEM private key of --client-cert")
+	rootCmd.PersistentFlags().StringSliceVar(&pinSHA256, "pin-sha256", nil, "Base64 SHA-256 hash of a public key (SPKI) that must appear in the broker's verified certificate chain")
+	rootCmd.PersistentFlags().StringVar(&proxyURL, "proxy", "", "Proxy URL for broker requests (default from $HTTPS_PROXY, $HTTP_PROXY and $NO_PROXY)")
+	rootCmd.PersistentFlags().BoolVar(&allowInsecureHTTP, "allow-insecure-http", false, "Allow plain HTTP to brokers that are not on loopback (sends tokens and credentials unencrypted)")
+	rootCmd.PersistentFlags().BoolVar(&insecureSkipVerify, "insecure-skip-verify", false, "Disable TLS certificate verification (DANGEROUS, local development only)")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Enable debug logging (same as VOIDKEY_DEBUG=true)")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Suppress progress output, only print warnings and errors")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logFormatText, "Log format for stderr (text|json)")
+	rootCmd.PersistentFlags().StringVar(&errorFormat, "error-format", errorFormatText, "Format of the error printed on failure (text|json)")
+
+	// Flag parsing errors exit with the usage exit code
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return voidkey.WrapKind(ErrUsage, err)
+	})
 
 	// Initialize commands after flags are set up
 	initCommands()
@@ -39,11 +288,26 @@ func init() {
 func initCommands() {
 	// Initialize client
 	client := NewVoidkeyClient(&http.Client{}, serverURL)
+	brokerClient = client
 
 	// Initialize commands with dependency injection
 	mintCmd := mintCreds(client)
 	listIdpsCmd := listIdpProviders(client)
+	listKeysCmd := listKeys(client)
+	doctorCmd := doctor(client)
+	serveCmd := serve(client)
+	execCmd := execCommand(client)
+	agentCmd := agentCommand(client)
+	dockerCredentialCmd := dockerCredential(client)
+	gitCredentialCmd := gitCredential(client)
 
 	rootCmd.AddCommand(mintCmd)
 	rootCmd.AddCommand(listIdpsCmd)
+	rootCmd.AddCommand(listKeysCmd)
+	rootCmd.AddCommand(doctorCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(execCmd)
+	rootCmd.AddCommand(agentCmd)
+	rootCmd.AddCommand(dockerCredentialCmd)
+	rootCmd.AddCommand(gitCredentialCmd)
 }
@@ -2,9 +2,12 @@ package cmd
 
 import (
 	"bytes"
+	"context"
 	"net/http"
 	"testing"
+	"time"
 
+	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -133,3 +136,89 @@ func TestServerURLGlobal(t *testing.T) {
 	// The global variable should be updated
 	assert.Contains(t, []string{"http://localhost:3000", "http://custom.example.com"}, serverURL)
 }
+
+func TestRootCommandTimeoutFlag(t *testing.T) {
+	timeoutFlag := rootCmd.PersistentFlags().Lookup("timeout")
+	assert.NotNil(t, timeoutFlag)
+	assert.Equal(t, defaultTimeout.String(), timeoutFlag.DefValue)
+}
+
+func TestConfigureClient(t *testing.T) {
+	originalCfg, originalServer, originalTimeout := cfg, serverURL, timeout
+	defer func() { cfg, serverURL, timeout = originalCfg, originalServer, originalTimeout }()
+
+	newCommand := func() *cobra.Command {
+		cmd := &cobra.Command{}
+		cmd.Flags().StringVar(&serverURL, "server", "http://localhost:3000", "")
+		cmd.Flags().DurationVar(&timeout, "timeout", defaultTimeout, "")
+		return cmd
+	}
+
+	// Config file values apply when the flags are not set
+	cfg = Config{Server: "https://broker.example.com", Timeout: 5 * time.Second}
+	cmd := newCommand()
+	client := NewVoidkeyClient(nil, "")
+	assert.NoError(t, configureClient(cmd, client))
+
+	assert.Equal(t, "https://broker.example.com", client.serverURL)
+	assert.Equal(t, 5*time.Second, client.client.(*http.Client).Timeout)
+
+	// Flags win over the config file
+	cmd = newCommand()
+	_ = cmd.Flags().Set("server", "https://other.example.com")
+	_ = cmd.Flags().Set("timeout", "2s")
+	assert.NoError(t, configureClient(cmd, client))
+
+	assert.Equal(t, "https://other.example.com", client.serverURL)
+	assert.Equal(t, 2*time.Second, client.client.(*http.Client).Timeout)
+
+	// Defaults apply without either
+	cfg = Config{}
+	cmd = newCommand()
+	assert.NoError(t, configureClient(cmd, client))
+
+	assert.Equal(t, "http://localhost:3000", client.serverURL)
+	assert.Equal(t, defaultTimeout, client.client.(*http.Client).Timeout)
+}
+
+func TestCommandContext(t *testing.T) {
+	cmd := &cobra.Command{}
+	assert.NotNil(t, commandContext(cmd))
+
+	ctx := context.WithValue(context.Background(), struct{}{}, "value")
+	cmd.SetContext(ctx)
+	assert.Equal(t, ctx, commandContext(cmd))
+}
+
+func TestConfigureClient_RefusesInsecureHTTP(t *testing.T) {
+	originalCfg, originalServer, originalAllow := cfg, serverURL, allowInsecureHTTP
+	defer func() { cfg, serverURL, allowInsecureHTTP = originalCfg, originalServer, originalAllow }()
+
+	cfg = Config{Server: "http://broker.corp"}
+	client := NewVoidkeyClient(nil, "")
+
+	err := configureClient(&cobra.Command{}, client)
+	assert.ErrorIs(t, err, ErrUsage)
+	assert.Contains(t, err.Error(), "refusing to use plain HTTP broker http://broker.corp")
+
+	allowInsecureHTTP = true
+	assert.NoError(t, configureClient(&cobra.Command{}, client))
+	assert.Equal(t, "http://broker.corp", client.serverURL)
+}
+
+func TestCredentialHelperArgs(t *testing.T) {
+	args, ok := credentialHelperArgs("/usr/local/bin/docker-credential-voidkey", []string{"get"})
+	assert.True(t, ok)
+	assert.Equal(t, []string{"docker-credential", "get"}, args)
+
+	args, ok = credentialHelperArgs("/opt/voidkey/docker-credential-voidkey.exe", []string{"list"})
+	assert.True(t, ok)
+	assert.Equal(t, []string{"docker-credential", "list"}, args)
+
+	args, ok = credentialHelperArgs("/usr/local/bin/git-credential-voidkey", []string{"get"})
+	assert.True(t, ok)
+	assert.Equal(t, []string{"git-credential", "get"}, args)
+
+	_, ok = credentialHelperArgs("/usr/local/bin/voidkey", []string{"mint"})
+	assert.False(t, ok)
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/voidkey-oss/cli/internal/credserver"
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+)
+
+// serveOptions holds the flag values shared by the serve subcommands
+type serveOptions struct {
+	token         string
+	idpName       string
+	key           string
+	listen        string
+	duration      int
+	refreshBefore time.Duration
+	// allowRemote permits listening on addresses other hosts can reach
+	allowRemote bool
+}
+
+// serve creates the serve command that groups the credential servers
+func serve(voidkeyClient *VoidkeyClient) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve credentials over cloud metadata endpoints",
+		Long: `Serve minted credentials over the metadata endpoints that cloud SDKs query
+by default, so existing tools pick them up without configuration. The
+credentials are refreshed in the background before they expire.`,
+	}
+
+	cmd.AddCommand(serveIMDS(voidkeyClient))
+	cmd.AddCommand(serveECS(voidkeyClient))
+	cmd.AddCommand(serveGCE(voidkeyClient))
+
+	return cmd
+}
+
+// addServeFlags registers the flags shared by the serve subcommands, with
+// defaultListen as the default listen address
+func addServeFlags(cmd *cobra.Command, opts *serveOptions, defaultListen string) {
+	cmd.Flags().StringVar(&opts.token, "token", "", "OIDC token for authentication (default from $OIDC_TOKEN or $GITHUB_TOKEN)")
+	cmd.Flags().StringVar(&opts.idpName, "idp", "", "IdP provider name to use (uses server default if not specified)")
+	cmd.Flags().StringVar(&opts.key, "key", "", "Name of the key whose credentials are served")
+	cmd.Flags().StringVar(&opts.listen, "listen", defaultListen, "Address to listen on")
+	cmd.Flags().IntVar(&opts.duration, "duration", 0, "Duration in seconds to override default credential lifetime")
+	cmd.Flags().DurationVar(&opts.refreshBefore, "refresh-before", voidkey.DefaultRefreshBefore, "How long before they expire credentials are refreshed")
+	cmd.Flags().BoolVar(&opts.allowRemote, "allow-remote", false, "Allow listening on addresses other hosts can reach (serves credentials to anyone who can connect)")
+	_ = cmd.MarkFlagRequired("key")
+}
+
+// prepareServe returns the source for the key of opts, minted once to fail
+// early, and the listener to serve its credentials on
+func prepareServe(client *VoidkeyClient, cmd *cobra.Command, opts serveOptions) (*KeySource, net.Listener, error) {
+	if !opts.allowRemote && !credserver.IsLoopback(opts.listen) {
+		return nil, nil, usageErrorf("refusing to serve credentials on %s, which other hosts can reach; listen on a loopback address or pass --allow-remote", opts.listen)
+	}
+	if opts.refreshBefore < 0 {
+		return nil, nil, usageErrorf("invalid --refresh-before %s (must not be negative)", opts.refreshBefore)
+	}
+
+	token, err := resolveClientToken(client, cmd, opts.token, opts.idpName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	source, err := client.KeySource(token, opts.key,
+		voidkey.WithIdpName(opts.idpName),
+		voidkey.WithDuration(time.Duration(opts.duration)*time.Second),
+		voidkey.WithRefreshBefore(opts.refreshBefore))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	progressf(cmd, "🔑 Minting key: %s", opts.key)
+	response, err := source.Credentials(commandContext(cmd))
+	if err != nil {
+		return nil, nil, err
+	}
+	if response.ExpiresAt != "" {
+		progressf(cmd, "⏱️ Credentials expire at %s and are refreshed %s before", response.ExpiresAt, opts.refreshBefore)
+	}
+
+	listener, err := net.Listen("tcp", opts.listen)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to listen on %s: %w", opts.listen, err)
+	}
+	return source, listener, nil
+}
+
+// serveListener serves handler on listener until the command is cancelled
+func serveListener(cmd *cobra.Command, listener net.Listener, handler http.Handler, source credserver.Source) error {
+	// Serving stops on Ctrl-C, which is not a failure
+	cmd.SilenceUsage = true
+	progressf(cmd, "🚀 Serving credentials on %s (Ctrl-C to stop)", listener.Addr())
+
+	if err := credserver.Serve(commandContext(cmd), listener, handler, source, logFor(cmd)); err != nil {
+		return fmt.Errorf("server failed: %w", err)
+	}
+	progressf(cmd, "👋 Stopped serving credentials")
+	return nil
+}
@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/voidkey-oss/cli/internal/credserver"
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+	"github.com/voidkey-oss/cli/pkg/voidkey/voidkeytest"
+)
+
+// startServer serves handler for source on a random loopback port until the
+// test ends, and returns the server's base URL and a function that stops
+// it and returns the error the command returned
+func startServer(t *testing.T, handler func(source credserver.Source) http.Handler, source credserver.Source) (string, func() error) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd, _, _ := SetupTestCommand()
+	cmd.SetContext(ctx)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- serveListener(cmd, listener, handler(source), source)
+	}()
+
+	stop := func() error {
+		cancel()
+		select {
+		case err := <-errs:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("server did not shut down")
+			return nil
+		}
+	}
+	t.Cleanup(cancel)
+	return "http://" + listener.Addr().String(), stop
+}
+
+func TestServeIMDS(t *testing.T) {
+	broker, client := newServeBroker(t)
+	source, err := client.KeySource(voidkeytest.Token, "AWS_CREDENTIALS")
+	require.NoError(t, err)
+
+	baseURL, stop := startServer(t, func(source credserver.Source) http.Handler {
+		return credserver.NewIMDS(source, "deploy", discardLogger())
+	}, source)
+
+	req, err := http.NewRequest(http.MethodPut, baseURL+"/latest/api/token", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	token, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	req, err = http.NewRequest(http.MethodGet, baseURL+"/latest/meta-data/iam/security-credentials/deploy", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-aws-ec2-metadata-token", string(token))
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var credentials map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&credentials))
+	assert.Equal(t, "AKIA-1", credentials["AccessKeyId"])
+	assert.Equal(t, "secret-1", credentials["SecretAccessKey"])
+	assert.Equal(t, "session-1", credentials["Token"])
+	assert.Equal(t, 1, broker.Mints("AWS_CREDENTIALS"))
+
+	assert.NoError(t, stop())
+}
+
+func TestVoidkeyClient_KeySource(t *testing.T) {
+	_, client := newServeBroker(t)
+
+	source, err := client.KeySource(voidkeytest.Token, "AWS_CREDENTIALS")
+	require.NoError(t, err)
+	response, err := source.Credentials(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, redactedPlaceholder, redactor.Redact(response.Credentials["AWS_SECRET_ACCESS_KEY"]))
+	assert.Equal(t, redactedPlaceholder, redactor.Redact(voidkeytest.Token))
+
+	source, err = client.KeySource("wrong-token", "AWS_CREDENTIALS")
+	require.NoError(t, err)
+	_, err = source.Credentials(context.Background())
+
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.Contains(t, err.Error(), "Hint: "+hintUnauthorized)
+}
+
+func TestPrepareServe_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     serveOptions
+		expected error
+		message  string
+	}{
+		{
+			name:     "remote listen address",
+			opts:     serveOptions{token: voidkeytest.Token, key: "AWS_CREDENTIALS", listen: "0.0.0.0:1338"},
+			expected: ErrUsage,
+			message:  "refusing to serve credentials on 0.0.0.0:1338",
+		},
+		{
+			name:     "negative refresh window",
+			opts:     serveOptions{token: voidkeytest.Token, key: "AWS_CREDENTIALS", listen: "127.0.0.1:0", refreshBefore: -time.Minute},
+			expected: ErrUsage,
+			message:  "invalid --refresh-before",
+		},
+		{
+			name:     "unknown key",
+			opts:     serveOptions{token: voidkeytest.Token, key: "UNKNOWN", listen: "127.0.0.1:0"},
+			expected: voidkey.ErrRequestRejected,
+			message:  "failed to mint key UNKNOWN: key not configured",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, client := newServeBroker(t)
+			cmd, _, _ := SetupTestCommand()
+
+			_, listener, err := prepareServe(client, cmd, tt.opts)
+
+			assert.Nil(t, listener)
+			assert.ErrorIs(t, err, tt.expected)
+			assert.Contains(t, err.Error(), tt.message)
+		})
+	}
+}
+
+func TestServeIMDS_InvalidRole(t *testing.T) {
+	_, client := newServeBroker(t)
+	cmd := serveIMDS(client)
+	cmd.SetArgs([]string{"--key", "AWS_CREDENTIALS", "--role", "a/b"})
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+
+	err := cmd.Execute()
+
+	assert.ErrorIs(t, err, ErrUsage)
+	assert.Contains(t, err.Error(), `invalid --role "a/b"`)
+}
+
+func TestServeECS(t *testing.T) {
+	_, client := newServeBroker(t)
+	source, err := client.KeySource(voidkeytest.Token, "AWS_CREDENTIALS")
+	require.NoError(t, err)
+
+	baseURL, stop := startServer(t, func(source credserver.Source) http.Handler {
+		return credserver.NewECS(source, "auth-token", discardLogger())
+	}, source)
+
+	req, err := http.NewRequest(http.MethodGet, baseURL+credserver.ECSCredentialsPath, nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "auth-token")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var credentials map[string]string
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&credentials))
+	assert.Equal(t, "AKIA-1", credentials["AccessKeyId"])
+
+	assert.NoError(t, stop())
+}
+
+func TestServeGCE(t *testing.T) {
+	broker, client := newServeBroker(t)
+	broker.SetKey("GCP_CREDENTIALS", map[string]string{"GOOGLE_OAUTH_ACCESS_TOKEN": "ya29.token"})
+	source, err := client.KeySource(voidkeytest.Token, "GCP_CREDENTIALS")
+	require.NoError(t, err)
+
+	baseURL, stop := startServer(t, func(source credserver.Source) http.Handler {
+		return credserver.NewGCE(source, "default", discardLogger())
+	}, source)
+
+	req, err := http.NewRequest(http.MethodGet, baseURL+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	require.NoError(t, err)
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var token map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&token))
+	assert.Equal(t, "ya29.token-1", token["access_token"])
+	assert.Equal(t, "Bearer", token["token_type"])
+
+	assert.NoError(t, stop())
+}
+
+func TestECSEndpointURL(t *testing.T) {
+	tests := []struct {
+		addr     net.Addr
+		expected string
+	}{
+		{addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4100}, expected: "http://127.0.0.1:4100/credentials"},
+		{addr: &net.TCPAddr{IP: net.IPv4zero, Port: 4100}, expected: "http://127.0.0.1:4100/credentials"},
+		{addr: &net.TCPAddr{IP: net.IPv6loopback, Port: 4100}, expected: "http://[::1]:4100/credentials"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, ecsEndpointURL(tt.addr))
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/mock"
+	"github.com/voidkey-oss/cli/pkg/voidkey/voidkeytest"
 )
 
 // TestHelpers provides common testing utilities for CLI tests
@@ -94,16 +96,23 @@ func AssertCommandOutput(t *testing.T, stdout, stderr *bytes.Buffer, expectedStd
 	}
 }
 
+// requestMatching matches requests with the given method and URL
+func requestMatching(method, url string) interface{} {
+	return mock.MatchedBy(func(req *http.Request) bool {
+		return req.Method == method && req.URL.String() == url
+	})
+}
+
 // MockSuccessfulMintResponse sets up a mock for successful credential minting
 func MockSuccessfulMintResponse(mockClient *MockHTTPClient, serverURL string, credentials map[string]KeyCredentialResponse) {
 	resp := CreateMockHTTPResponse(http.StatusOK, credentials)
-	mockClient.On("Post", serverURL+"/credentials/mint", "application/json", mock.Anything).Return(resp, nil)
+	mockClient.On("Do", requestMatching(http.MethodPost, serverURL+"/credentials/mint")).Return(resp, nil)
 }
 
 // MockSuccessfulListResponse sets up a mock for successful provider listing
 func MockSuccessfulListResponse(mockClient *MockHTTPClient, serverURL string, providers []IdpProvider) {
 	resp := CreateMockHTTPResponse(http.StatusOK, providers)
-	mockClient.On("Get", serverURL+"/credentials/idp-providers").Return(resp, nil)
+	mockClient.On("Do", requestMatching(http.MethodGet, serverURL+"/credentials/idp-providers")).Return(resp, nil)
 }
 
 // MockErrorResponse sets up a mock for error responses
@@ -111,22 +120,56 @@ func MockErrorResponse(mockClient *MockHTTPClient, method, url string, statusCod
 	resp := CreateMockHTTPResponse(statusCode, errorMessage)
 
 	switch method {
-	case "POST":
-		mockClient.On("Post", url, "application/json", mock.Anything).Return(resp, nil)
-	case "GET":
-		mockClient.On("Get", url).Return(resp, nil)
+	case "POST", "GET":
+		mockClient.On("Do", requestMatching(method, url)).Return(resp, nil)
 	}
 }
 
+// newServeBroker starts a broker with an AWS_CREDENTIALS key and returns it
+// with a client for it
+func newServeBroker(t *testing.T) (*voidkeytest.Broker, *VoidkeyClient) {
+	broker := voidkeytest.NewBroker(t)
+	broker.SetKey("AWS_CREDENTIALS", map[string]string{
+		"AWS_ACCESS_KEY_ID":     "AKIA",
+		"AWS_SECRET_ACCESS_KEY": "secret",
+		"AWS_SESSION_TOKEN":     "session",
+	})
+	return broker, NewVoidkeyClient(broker.Server.Client(), broker.URL())
+}
+
+// setConfig replaces the configuration with config until the test ends
+func setConfig(t *testing.T, config Config) {
+	originalCfg := cfg
+	t.Cleanup(func() { cfg = originalCfg })
+	cfg = config
+}
+
+// runHelperCommand runs action of the credential helper command newCommand
+// creates for client, with stdin and OIDC_TOKEN set to the fake broker's
+// token, and returns what it printed to stdout
+func runHelperCommand(t *testing.T, newCommand func(*VoidkeyClient) *cobra.Command, client *VoidkeyClient, action, stdin string) (string, error) {
+	t.Setenv("OIDC_TOKEN", voidkeytest.Token)
+	cmd := newCommand(client)
+	_, stdout, _ := SetupTestCommand()
+	cmd.SetOut(stdout)
+	cmd.SetErr(&strings.Builder{})
+	cmd.SetIn(strings.NewReader(stdin))
+	cmd.SetArgs([]string{action})
+
+	err := cmd.Execute()
+	return stdout.String(), err
+}
+
 // TestMockHTTPClient_Implementation verifies the mock implementation works correctly
 func TestMockHTTPClient_Implementation(t *testing.T) {
 	mockClient := &MockHTTPClient{}
 
 	// Test POST method
 	resp := CreateMockHTTPResponse(200, "test body")
-	mockClient.On("Post", "http://test.com", "application/json", mock.Anything).Return(resp, nil)
+	mockClient.On("Do", requestMatching(http.MethodPost, "http://test.com")).Return(resp, nil)
 
-	result, err := mockClient.Post("http://test.com", "application/json", bytes.NewReader([]byte("test")))
+	req, _ := http.NewRequest(http.MethodPost, "http://test.com", bytes.NewReader([]byte("test")))
+	result, err := mockClient.Do(req)
 	if err != nil {
 		t.Errorf("Unexpected error: %v", err)
 	}
@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// Token sources reported in debug logs
+const (
+	tokenSourceFlag       = "flag"
+	tokenSourceOIDCEnv    = "OIDC_TOKEN"
+	tokenSourceGitHubEnv  = "GITHUB_TOKEN"
+	tokenSourceHelloWorld = "hello-world-default"
+)
+
+// resolveToken returns the OIDC token to authenticate with, taken from the
+// --token flag, the OIDC_TOKEN or GITHUB_TOKEN environment variables, or the
+// built-in hello-world token, in that order. The token is registered with the
+// redactor before it is returned.
+func resolveToken(cmd *cobra.Command, token, idpName string) (string, error) {
+	token, source := lookupToken(token, idpName)
+	switch source {
+	case tokenSourceOIDCEnv:
+		progressf(cmd, "🔍 Using OIDC_TOKEN environment variable")
+	case tokenSourceGitHubEnv:
+		progressf(cmd, "🔍 Using GITHUB_TOKEN environment variable")
+	case tokenSourceHelloWorld:
+		progressf(cmd, "🎭 Using hello-world IdP with default token")
+	}
+
+	// Require a valid OIDC token
+	if token == "" {
+		return "", usageErrorf("OIDC token is required. Provide via:\n" +
+			"  --token flag: voidkey mint --token \"your.jwt.token\"\n" +
+			"  OIDC_TOKEN env var: export OIDC_TOKEN=\"your.jwt.token\"\n" +
+			"  GITHUB_TOKEN env var (for GitHub Actions IdP)\n\n" +
+			"To obtain an OIDC token:\n" +
+			"  - Auth0: Use the Auth0 CLI or obtain from your application\n" +
+			"  - GitHub Actions: Available as ${{ github.token }}\n" +
+			"  - Other IdPs: Consult your identity provider's documentation\n" +
+			"  - Hello World: Use --idp hello-world for testing (no token required)")
+	}
+
+	redactor.Add(token)
+	logFor(cmd).Debug("resolved OIDC token", "source", source, "length", len(token))
+
+	return token, nil
+}
+
+// resolveClientToken is resolveToken for commands the agent may serve. The
+// agent authenticates with its own token, so none is needed when client
+// talks to it.
+func resolveClientToken(client *VoidkeyClient, cmd *cobra.Command, token, idpName string) (string, error) {
+	if client != nil && client.UsesAgent() {
+		return "", nil
+	}
+	return resolveToken(cmd, token, idpName)
+}
+
+// lookupToken returns the OIDC token and where it came from, following the
+// order of resolveToken. Both are empty if there is no token.
+func lookupToken(token, idpName string) (string, string) {
+	if token != "" {
+		return token, tokenSourceFlag
+	}
+	if token := os.Getenv("OIDC_TOKEN"); token != "" {
+		return token, tokenSourceOIDCEnv
+	}
+	// Also check for GitHub Actions token as a common case
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		return token, tokenSourceGitHubEnv
+	}
+	// Special case for hello-world IdP - provide default token
+	if idpName == "hello-world" {
+		return "cli-hello-world-token", tokenSourceHelloWorld
+	}
+	return "", ""
+}
@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+)
+
+// errPinMismatch is returned when the broker's verified certificate chain
+// contains none of the pinned public keys
+var errPinMismatch = errors.New("broker certificate does not match any pinned public key")
+
+// newTransport builds the HTTP transport for broker requests from the TLS
+// settings. Certificate and key files are read once, up front, so a
+// misconfiguration fails before any request is made.
+func newTransport(config TLSConfig) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if config.CAFile != "" {
+		pool, err := loadCAPool(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.ClientCert != "" || config.ClientKey != "" {
+		if config.ClientCert == "" || config.ClientKey == "" {
+			return nil, usageErrorf("--client-cert and --client-key must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(config.PinSHA256) > 0 {
+		pins, err := parsePins(config.PinSHA256)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.VerifyConnection = verifyPins(pins)
+	}
+
+	tlsConfig.InsecureSkipVerify = config.InsecureSkipVerify
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// routeTransport points transport at the broker. unix:// server URLs dial
+// the socket directly; other requests go through the proxy, or the one from
+// HTTPS_PROXY, HTTP_PROXY and NO_PROXY when proxy is empty.
+func routeTransport(transport *http.Transport, server *url.URL, proxy string) error {
+	if server.Scheme == "unix" {
+		voidkey.DialUnixSocket(transport, server.Path)
+		return nil
+	}
+
+	transport.Proxy = http.ProxyFromEnvironment
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Host == "" {
+			return usageErrorf("invalid --proxy %q (must be a URL such as http://proxy.example.com:3128)", redactedURL(proxy))
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return usageErrorf("invalid --proxy %q (scheme must be http, https, socks5 or socks5h)", redactedURL(proxy))
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	return nil
+}
+
+// loadCAPool returns the system certificate pool with the CAs in path added
+func loadCAPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in CA file %s", path)
+	}
+	return pool, nil
+}
+
+// parsePins decodes base64 SHA-256 SPKI pins, accepting the "sha256/" prefix
+// used by curl's --pinnedpubkey
+func parsePins(values []string) ([][]byte, error) {
+	pins := make([][]byte, 0, len(values))
+	for _, value := range values {
+		encoded := strings.TrimPrefix(strings.TrimPrefix(value, "sha256//"), "sha256/")
+		pin, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(pin) != sha256.Size {
+			return nil, usageErrorf("invalid --pin-sha256 %q (must be a base64 SHA-256 hash)", value)
+		}
+		pins = append(pins, pin)
+	}
+	return pins, nil
+}
+
+// verifyPins returns a connection check that requires one of the
+// certificates that verified the broker's certificate to have a pinned public
+// key. Certificates the broker merely sent along are not trusted, and without
+// verification, as with InsecureSkipVerify, only the leaf is.
+func verifyPins(pins [][]byte) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		var certs []*x509.Certificate
+		for _, chain := range state.VerifiedChains {
+			certs = append(certs, chain...)
+		}
+		if len(state.VerifiedChains) == 0 && len(state.PeerCertificates) > 0 {
+			certs = state.PeerCertificates[:1]
+		}
+
+		for _, cert := range certs {
+			hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range pins {
+				if string(hash[:]) == string(pin) {
+					return nil
+				}
+			}
+		}
+		return errPinMismatch
+	}
+}
+
+// redactedURL returns rawURL with any password replaced, for logs and errors
+func redactedURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return parsed.Redacted()
+}
+
+// checkServerSecurity refuses plain HTTP broker URLs, over which tokens and
+// credentials would travel in cleartext, unless the host is loopback or
+// allowInsecureHTTP is set
+func checkServerSecurity(server *url.URL, allowInsecureHTTP bool) error {
+	if server.Scheme != "http" || allowInsecureHTTP || isLoopback(server.Hostname()) {
+		return nil
+	}
+	return usageErrorf("refusing to use plain HTTP broker %s: tokens and credentials would be sent unencrypted; use https:// or pass --allow-insecure-http", server.Redacted())
+}
+
+// isLoopback reports whether host names the local machine
+func isLoopback(host string) bool {
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
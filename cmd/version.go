@@ -1,11 +1,17 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
+// serverVersionTimeout bounds the broker lookup of the version command, so
+// it stays quick when the broker is unreachable
+const serverVersionTimeout = 5 * time.Second
+
 var (
 	versionInfo = struct {
 		version string
@@ -25,18 +31,40 @@ func SetVersionInfo(version, commit, date string) {
 	versionInfo.date = date
 }
 
+// userAgent returns the User-Agent header sent to the broker
+func userAgent() string {
+	return "voidkey-cli/" + versionInfo.version
+}
+
 // versionCmd represents the version command
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Show version information",
-	Long:  `Display version, commit, and build date information for the voidkey CLI.`,
+	Long: `Display version, commit, and build date information for the voidkey CLI,
+and the version of the broker server if it can be reached.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		_, _ = fmt.Fprintf(cmd.OutOrStdout(), "voidkey version %s\n", versionInfo.version)
 		_, _ = fmt.Fprintf(cmd.OutOrStdout(), "commit: %s\n", versionInfo.commit)
 		_, _ = fmt.Fprintf(cmd.OutOrStdout(), "built: %s\n", versionInfo.date)
+		if brokerClient != nil {
+			_, _ = fmt.Fprintf(cmd.OutOrStdout(), "server: %s\n", serverVersion(commandContext(cmd), brokerClient))
+		}
 	},
 }
 
+// serverVersion returns the broker's version for display
+func serverVersion(ctx context.Context, client *VoidkeyClient) string {
+	ctx, cancel := context.WithTimeout(ctx, serverVersionTimeout)
+	defer cancel()
+
+	capabilities, err := client.Capabilities(ctx)
+	if err != nil {
+		client.logger.Debug("failed to get server version", "error", err)
+		return "unavailable"
+	}
+	return capabilities.DisplayVersion()
+}
+
 func init() {
 	rootCmd.AddCommand(versionCmd)
 }
@@ -0,0 +1,319 @@
+// Package agent implements the voidkey agent, a daemon in the style of
+// ssh-agent. It holds an OIDC token, mints credentials with it, keeps them
+// fresh and hands them to local clients over a unix socket. The agent
+// speaks the broker's mint API, so clients reach it like a broker at
+// unix://<socket>.
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/voidkey-oss/cli/internal/credserver"
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+)
+
+// SocketEnv points clients at the socket of a running agent
+const SocketEnv = "VOIDKEY_AGENT_SOCK"
+
+// ClientToken is the token clients of the agent authenticate with. The
+// agent ignores it; clients are authenticated by their user ID instead, and
+// the agent mints with its own token.
+const ClientToken = "voidkey-agent"
+
+// keysTTL is how long the list of available keys is cached for --all
+const keysTTL = 5 * time.Minute
+
+// sourceIdleTTL is how long a source no client asked for is kept fresh
+// before it is dropped, checked every sourceSweepInterval. Clients may ask
+// for any duration, so sources would otherwise pile up.
+const (
+	sourceIdleTTL       = 15 * time.Minute
+	sourceSweepInterval = time.Minute
+)
+
+// Paths of the broker API served by the agent
+const (
+	mintPath = "/credentials/mint"
+	keysPath = "/credentials/keys"
+)
+
+// SourceFunc returns a source minting key with the agent's token, with
+// duration overriding the default lifetime unless zero
+type SourceFunc func(key string, duration time.Duration) (credserver.Source, error)
+
+// KeysFunc returns the keys the agent's token is permitted to mint
+type KeysFunc func(ctx context.Context) ([]string, error)
+
+// mintRequest is the body of a mint request, as sent to the broker
+type mintRequest struct {
+	IdpName  string   `json:"idpName"`
+	Keys     []string `json:"keys"`
+	Duration int      `json:"duration"`
+	All      bool     `json:"all"`
+}
+
+// errorBody is the body of error responses, in the broker's shape
+type errorBody struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// sourceKey identifies a cached source
+type sourceKey struct {
+	key      string
+	duration time.Duration
+}
+
+// cachedSource is a source kept fresh in the background until cancelled
+type cachedSource struct {
+	source credserver.Source
+	cancel context.CancelFunc
+	// usedAt is when a client last asked for the source's credentials
+	usedAt time.Time
+}
+
+// Agent serves the credentials of its token to clients. A source is created
+// for every key and duration clients ask for, and kept fresh in the
+// background once it minted until no client asked for it for sourceIdleTTL.
+type Agent struct {
+	idpName   string
+	newSource SourceFunc
+	listKeys  KeysFunc
+	logger    *slog.Logger
+	now       func() time.Time
+
+	mu      sync.Mutex
+	ctx     context.Context
+	sources map[sourceKey]*cachedSource
+	keys    []string
+	keysAt  time.Time
+}
+
+// New returns an agent minting with the token behind newSource and
+// listKeys, which was issued by the IdP idpName (empty for the broker's
+// default)
+func New(idpName string, newSource SourceFunc, listKeys KeysFunc, logger *slog.Logger) *Agent {
+	return &Agent{
+		idpName:   idpName,
+		newSource: newSource,
+		listKeys:  listKeys,
+		logger:    logger,
+		now:       time.Now,
+		ctx:       context.Background(),
+		sources:   make(map[sourceKey]*cachedSource),
+	}
+}
+
+// Serve serves clients on listener until ctx is done. Credentials are kept
+// fresh until then too.
+func (a *Agent) Serve(ctx context.Context, listener net.Listener) error {
+	a.mu.Lock()
+	a.ctx = ctx
+	a.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(sourceSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				a.dropIdleSources()
+			}
+		}
+	}()
+	return credserver.Serve(ctx, listener, a, nil, a.logger)
+}
+
+// ServeHTTP implements http.Handler
+func (a *Agent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.logger.Debug("agent request", "method", r.Method, "path", r.URL.Path)
+
+	switch {
+	case r.URL.Path == mintPath && r.Method == http.MethodPost:
+		a.serveMint(w, r)
+	case r.URL.Path == keysPath && r.Method == http.MethodGet:
+		keys, err := a.availableKeys(r.Context())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, keys)
+	case r.URL.Path == mintPath || r.URL.Path == keysPath:
+		writeJSON(w, http.StatusMethodNotAllowed, errorBody{Error: "method_not_allowed", Message: "method not allowed"})
+	default:
+		writeJSON(w, http.StatusNotFound, errorBody{Error: "not_found", Message: "not supported by the agent"})
+	}
+}
+
+// serveMint returns the credentials of the requested keys. Keys the broker
+// refuses are reported per key, like the broker does.
+func (a *Agent) serveMint(w http.ResponseWriter, r *http.Request) {
+	var req mintRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorBody{Error: "invalid_request", Message: fmt.Sprintf("invalid request body: %v", err)})
+		return
+	}
+	if req.IdpName != "" && req.IdpName != a.idpName {
+		writeJSON(w, http.StatusBadRequest, errorBody{Error: "idp_mismatch", Message: fmt.Sprintf("the agent mints with %s, not IdP %q", a.idpDescription(), req.IdpName)})
+		return
+	}
+
+	keys := req.Keys
+	if req.All {
+		var err error
+		if keys, err = a.availableKeys(r.Context()); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	if len(keys) == 0 {
+		writeJSON(w, http.StatusBadRequest, errorBody{Error: "invalid_request", Message: "no keys requested"})
+		return
+	}
+
+	status := http.StatusOK
+	responses := make(map[string]voidkey.KeyCredentialResponse, len(keys))
+	for _, key := range keys {
+		response, err := a.credentials(r.Context(), sourceKey{key: key, duration: time.Duration(req.Duration) * time.Second})
+		var keyErr *voidkey.KeyError
+		switch {
+		case errors.As(err, &keyErr):
+			responses[key] = voidkey.KeyCredentialResponse{Error: keyErr}
+			status = http.StatusMultiStatus
+		case err != nil:
+			a.logger.Warn("failed to mint key", "key", key, "error", err)
+			writeError(w, err)
+			return
+		default:
+			responses[key] = response
+		}
+	}
+	writeJSON(w, status, responses)
+}
+
+// credentials returns the credentials of a key from its cached source.
+// Sources are only cached, and kept fresh from then on, once they minted
+// successfully, so refused keys are not retried in the background.
+func (a *Agent) credentials(ctx context.Context, id sourceKey) (voidkey.KeyCredentialResponse, error) {
+	a.mu.Lock()
+	cached, ok := a.sources[id]
+	if ok {
+		cached.usedAt = a.now()
+	}
+	a.mu.Unlock()
+	if ok {
+		return cached.source.Credentials(ctx)
+	}
+
+	source, err := a.newSource(id.key, id.duration)
+	if err != nil {
+		return voidkey.KeyCredentialResponse{}, err
+	}
+	response, err := source.Credentials(ctx)
+	if err != nil {
+		return response, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.sources[id]; !ok {
+		freshCtx, cancel := context.WithCancel(a.ctx)
+		a.sources[id] = &cachedSource{source: source, cancel: cancel, usedAt: a.now()}
+		go credserver.KeepFresh(freshCtx, source, credserver.RefreshInterval, a.logger)
+	}
+	return response, nil
+}
+
+// dropIdleSources stops refreshing and forgets the sources no client asked
+// for within sourceIdleTTL
+func (a *Agent) dropIdleSources() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for id, cached := range a.sources {
+		if a.now().Sub(cached.usedAt) >= sourceIdleTTL {
+			a.logger.Debug("dropping idle source", "key", id.key, "duration", id.duration)
+			cached.cancel()
+			delete(a.sources, id)
+		}
+	}
+}
+
+// availableKeys returns the keys the agent's token is permitted to mint,
+// cached for keysTTL
+func (a *Agent) availableKeys(ctx context.Context) ([]string, error) {
+	a.mu.Lock()
+	if a.keys != nil && a.now().Sub(a.keysAt) < keysTTL {
+		keys := a.keys
+		a.mu.Unlock()
+		return keys, nil
+	}
+	a.mu.Unlock()
+
+	keys, err := a.listKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keys = keys
+	a.keysAt = a.now()
+	return keys, nil
+}
+
+// idpDescription names the agent's IdP in messages
+func (a *Agent) idpDescription() string {
+	if a.idpName == "" {
+		return "the broker's default IdP"
+	}
+	return fmt.Sprintf("IdP %q", a.idpName)
+}
+
+// writeError writes err as an error response. Broker errors are passed
+// through as they are; other errors get the status the client maps back to
+// the same error kind.
+func writeError(w http.ResponseWriter, err error) {
+	var brokerErr *voidkey.BrokerError
+	if errors.As(err, &brokerErr) {
+		body := errorBody{Error: brokerErr.Code, Message: brokerErr.Message}
+		switch {
+		case len(brokerErr.KeyFailures) > 0:
+			body.Details = brokerErr.KeyFailures
+		case brokerErr.Details != "":
+			body.Details = brokerErr.Details
+		}
+		writeJSON(w, brokerErr.StatusCode, body)
+		return
+	}
+
+	status, code := http.StatusInternalServerError, "internal_error"
+	switch {
+	case errors.Is(err, voidkey.ErrUnauthorized):
+		status, code = http.StatusUnauthorized, "unauthorized"
+	case errors.Is(err, voidkey.ErrForbiddenKey):
+		status, code = http.StatusForbidden, "forbidden"
+	case errors.Is(err, voidkey.ErrBrokerUnavailable):
+		status, code = http.StatusServiceUnavailable, "broker_unavailable"
+	case errors.Is(err, voidkey.ErrRequestRejected):
+		status, code = http.StatusBadRequest, "request_rejected"
+	}
+	writeJSON(w, status, errorBody{Error: code, Message: err.Error()})
+}
+
+// writeJSON writes v as a JSON response with status
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
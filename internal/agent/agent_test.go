@@ -0,0 +1,214 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/voidkey-oss/cli/internal/credserver"
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+	"github.com/voidkey-oss/cli/pkg/voidkey/voidkeytest"
+)
+
+// discardLogger returns a logger that drops everything
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// testAgent is an agent on an httptest server, minting from a fake broker
+type testAgent struct {
+	broker *voidkeytest.Broker
+	agent  *Agent
+	server *httptest.Server
+	// sources counts the sources the agent created
+	sources atomic.Int32
+}
+
+// newTestAgent starts an agent for a broker with an AWS_CREDENTIALS and a
+// MINIO_CREDENTIALS key. The agent authenticates with token.
+func newTestAgent(t *testing.T, idpName, token string) *testAgent {
+	t.Helper()
+	ta := &testAgent{broker: voidkeytest.NewBroker(t)}
+	ta.broker.SetKey("AWS_CREDENTIALS", map[string]string{"AWS_ACCESS_KEY_ID": "AKIA"})
+	ta.broker.SetKey("MINIO_CREDENTIALS", map[string]string{"MINIO_ACCESS_KEY_ID": "minio"})
+
+	brokerClient := ta.broker.Client(t, voidkey.WithTokenSource(voidkey.StaticToken(token)))
+	ta.agent = New(idpName,
+		func(key string, duration time.Duration) (credserver.Source, error) {
+			ta.sources.Add(1)
+			return voidkey.NewKeySource(brokerClient, key, voidkey.WithDuration(duration)), nil
+		},
+		func(ctx context.Context) ([]string, error) {
+			return brokerClient.ListKeys(ctx, "")
+		},
+		discardLogger())
+	ta.server = httptest.NewServer(ta.agent)
+	t.Cleanup(ta.server.Close)
+	return ta
+}
+
+// client returns a client for the agent, as the CLI creates it
+func (ta *testAgent) client(t *testing.T) *voidkey.Client {
+	t.Helper()
+	client, err := voidkey.New(
+		voidkey.WithBaseURL(ta.server.URL),
+		voidkey.WithTokenSource(voidkey.StaticToken(ClientToken)),
+		voidkey.WithRetryPolicy(voidkey.NoRetry))
+	require.NoError(t, err)
+	return client
+}
+
+func TestAgent_Mint(t *testing.T) {
+	ta := newTestAgent(t, "", voidkeytest.Token)
+	client := ta.client(t)
+
+	for i := 0; i < 2; i++ {
+		responses, err := client.MintKeys(context.Background(), voidkey.MintRequest{Keys: []string{"AWS_CREDENTIALS"}})
+
+		require.NoError(t, err)
+		require.Contains(t, responses, "AWS_CREDENTIALS")
+		assert.Equal(t, "AKIA-1", responses["AWS_CREDENTIALS"].Credentials["AWS_ACCESS_KEY_ID"])
+		_, ok := responses["AWS_CREDENTIALS"].Expiry()
+		assert.True(t, ok)
+	}
+
+	assert.Equal(t, 1, ta.broker.Mints("AWS_CREDENTIALS"))
+	assert.Equal(t, int32(1), ta.sources.Load())
+}
+
+func TestAgent_MintDurations(t *testing.T) {
+	ta := newTestAgent(t, "", voidkeytest.Token)
+	client := ta.client(t)
+
+	_, err := client.MintKeys(context.Background(), voidkey.MintRequest{Keys: []string{"AWS_CREDENTIALS"}})
+	require.NoError(t, err)
+	responses, err := client.MintKeys(context.Background(), voidkey.MintRequest{Keys: []string{"AWS_CREDENTIALS"}, Duration: 15 * time.Minute})
+	require.NoError(t, err)
+
+	assert.Equal(t, "AKIA-2", responses["AWS_CREDENTIALS"].Credentials["AWS_ACCESS_KEY_ID"])
+	expiry, ok := responses["AWS_CREDENTIALS"].Expiry()
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), expiry, 5*time.Second)
+}
+
+func TestAgent_DropsIdleSources(t *testing.T) {
+	ta := newTestAgent(t, "", voidkeytest.Token)
+	client := ta.client(t)
+	now := time.Now()
+	ta.agent.now = func() time.Time { return now }
+
+	for _, duration := range []time.Duration{15 * time.Minute, 20 * time.Minute} {
+		_, err := client.MintKeys(context.Background(), voidkey.MintRequest{Keys: []string{"AWS_CREDENTIALS"}, Duration: duration})
+		require.NoError(t, err)
+	}
+	now = now.Add(sourceIdleTTL / 2)
+	_, err := client.MintKeys(context.Background(), voidkey.MintRequest{Keys: []string{"AWS_CREDENTIALS"}, Duration: 15 * time.Minute})
+	require.NoError(t, err)
+
+	// Only the source asked for again is kept
+	now = now.Add(sourceIdleTTL / 2)
+	ta.agent.dropIdleSources()
+	assert.Len(t, ta.agent.sources, 1)
+
+	now = now.Add(sourceIdleTTL)
+	ta.agent.dropIdleSources()
+	assert.Empty(t, ta.agent.sources)
+
+	// Dropped sources are created again when asked for
+	_, err = client.MintKeys(context.Background(), voidkey.MintRequest{Keys: []string{"AWS_CREDENTIALS"}, Duration: 15 * time.Minute})
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), ta.sources.Load())
+}
+
+func TestAgent_MintAll(t *testing.T) {
+	ta := newTestAgent(t, "", voidkeytest.Token)
+	client := ta.client(t)
+
+	responses, err := client.MintKeys(context.Background(), voidkey.MintRequest{All: true})
+	require.NoError(t, err)
+	assert.Len(t, responses, 2)
+	assert.Equal(t, "minio-1", responses["MINIO_CREDENTIALS"].Credentials["MINIO_ACCESS_KEY_ID"])
+
+	keys, err := client.ListKeys(context.Background(), "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"AWS_CREDENTIALS", "MINIO_CREDENTIALS"}, keys)
+}
+
+func TestAgent_KeyRefused(t *testing.T) {
+	ta := newTestAgent(t, "", voidkeytest.Token)
+	client := ta.client(t)
+
+	for i := 0; i < 2; i++ {
+		responses, err := client.MintKeys(context.Background(), voidkey.MintRequest{Keys: []string{"AWS_CREDENTIALS", "UNKNOWN"}})
+
+		require.NoError(t, err)
+		assert.Nil(t, responses["AWS_CREDENTIALS"].Error)
+		require.NotNil(t, responses["UNKNOWN"].Error)
+		assert.Equal(t, "key_not_found", responses["UNKNOWN"].Error.Code)
+	}
+
+	// Refused keys are not cached, so they are not refreshed either
+	assert.Equal(t, int32(3), ta.sources.Load())
+}
+
+func TestAgent_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		idpName  string
+		request  voidkey.MintRequest
+		setup    func(broker *voidkeytest.Broker)
+		expected error
+		message  string
+	}{
+		{
+			name:     "token rejected",
+			token:    "expired-token",
+			request:  voidkey.MintRequest{Keys: []string{"AWS_CREDENTIALS"}},
+			expected: voidkey.ErrUnauthorized,
+			message:  "token rejected",
+		},
+		{
+			name:     "broker unavailable",
+			token:    voidkeytest.Token,
+			request:  voidkey.MintRequest{Keys: []string{"AWS_CREDENTIALS"}},
+			setup:    func(broker *voidkeytest.Broker) { broker.SetUnavailable(true) },
+			expected: voidkey.ErrBrokerUnavailable,
+		},
+		{
+			name:     "other IdP",
+			token:    voidkeytest.Token,
+			idpName:  "github",
+			request:  voidkey.MintRequest{IdpName: "auth0", Keys: []string{"AWS_CREDENTIALS"}},
+			expected: voidkey.ErrRequestRejected,
+			message:  `the agent mints with IdP "github", not IdP "auth0"`,
+		},
+		{
+			name:     "no keys",
+			token:    voidkeytest.Token,
+			request:  voidkey.MintRequest{},
+			expected: voidkey.ErrRequestRejected,
+			message:  "no keys requested",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ta := newTestAgent(t, tt.idpName, tt.token)
+			if tt.setup != nil {
+				tt.setup(ta.broker)
+			}
+
+			_, err := ta.client(t).MintKeys(context.Background(), tt.request)
+
+			assert.ErrorIs(t, err, tt.expected)
+			assert.Contains(t, err.Error(), tt.message)
+		})
+	}
+}
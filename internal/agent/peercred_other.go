@@ -0,0 +1,21 @@
+//go:build !linux && !darwin
+
+package agent
+
+import (
+	"errors"
+	"net"
+)
+
+// peerCredSupported is set on platforms where peerUID works
+const peerCredSupported = false
+
+// peerUID is not implemented on this platform
+func peerUID(conn net.Conn) (int, error) {
+	return -1, errors.ErrUnsupported
+}
+
+// checkDir is not implemented on this platform
+func checkDir(dir string) error {
+	return nil
+}
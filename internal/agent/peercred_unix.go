@@ -0,0 +1,30 @@
+//go:build linux || darwin
+
+package agent
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// peerCredSupported is set on platforms where peerUID works
+const peerCredSupported = true
+
+// checkDir returns an error unless the socket directory dir is owned by the
+// current user and inaccessible to others, who could replace the socket
+func checkDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if uid := os.Getuid(); stat.Uid != uint32(uid) {
+			return fmt.Errorf("refusing to use socket directory %s: owned by uid %d, not the current user (uid %d)", dir, stat.Uid, uid)
+		}
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		return fmt.Errorf("refusing to use socket directory %s: accessible by other users (mode %s)", dir, info.Mode().Perm())
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// socketName is the file name of the agent's socket
+const socketName = "agent.sock"
+
+// DefaultSocketPath returns where the agent listens unless told otherwise:
+// in $XDG_RUNTIME_DIR if set, else in a per-user directory in the temp dir
+func DefaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "voidkey", socketName)
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("voidkey-%d", os.Getuid()), socketName)
+}
+
+// Listen creates the agent's socket at path and returns a listener for it.
+// The socket is only accessible to the current user, and connections from
+// processes of other users are dropped by checking their peer credentials.
+// A stale socket left behind by an agent that died is replaced.
+func Listen(path string, logger *slog.Logger) (net.Listener, error) {
+	if !peerCredSupported {
+		return nil, fmt.Errorf("the agent is not supported on %s, which cannot check the peer credentials of socket connections", runtime.GOOS)
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if err := checkDir(dir); err != nil {
+		return nil, err
+	}
+	if err := removeStale(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("failed to restrict access to %s: %w", path, err)
+	}
+	return &peerListener{Listener: listener, uid: os.Getuid(), logger: logger}, nil
+}
+
+// removeStale removes the socket at path if no agent listens on it anymore
+func removeStale(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("refusing to replace %s, which is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("an agent is already listening on %s", path)
+	}
+	return os.Remove(path)
+}
+
+// peerListener drops connections from processes of other users
+type peerListener struct {
+	net.Listener
+	uid    int
+	logger *slog.Logger
+}
+
+// Accept waits for and returns the next connection of the current user
+func (l *peerListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		uid, err := peerUID(conn)
+		if err == nil && uid == l.uid {
+			return conn, nil
+		}
+		l.logger.Warn("refused agent connection from another user", "uid", uid, "error", err)
+		_ = conn.Close()
+	}
+}
@@ -0,0 +1,134 @@
+//go:build linux || darwin
+
+package agent
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+	"github.com/voidkey-oss/cli/pkg/voidkey/voidkeytest"
+)
+
+// socketPath returns a socket path in a private temporary directory. Unix
+// socket paths are short, so it is not under t.TempDir.
+func socketPath(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "voidkey-agent")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "agent", socketName)
+}
+
+// serveAgent serves ta's agent on listener until the test ends
+func serveAgent(t *testing.T, ta *testAgent, listener net.Listener) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- ta.agent.Serve(ctx, listener) }()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+	})
+}
+
+func TestListen(t *testing.T) {
+	path := socketPath(t)
+	ta := newTestAgent(t, "", voidkeytest.Token)
+
+	listener, err := Listen(path, discardLogger())
+	require.NoError(t, err)
+	serveAgent(t, ta, listener)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	info, err = os.Stat(filepath.Dir(path))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+
+	client, err := voidkey.New(
+		voidkey.WithBaseURL("unix://"+path),
+		voidkey.WithTokenSource(voidkey.StaticToken(ClientToken)),
+		voidkey.WithRetryPolicy(voidkey.NoRetry))
+	require.NoError(t, err)
+	responses, err := client.MintKeys(context.Background(), voidkey.MintRequest{Keys: []string{"AWS_CREDENTIALS"}})
+	require.NoError(t, err)
+	assert.Equal(t, "AKIA-1", responses["AWS_CREDENTIALS"].Credentials["AWS_ACCESS_KEY_ID"])
+}
+
+func TestListen_ExistingSocket(t *testing.T) {
+	path := socketPath(t)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+
+	// A socket left behind by an agent that died is replaced
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	require.NoError(t, err)
+	stale.SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+
+	listener, err := Listen(path, discardLogger())
+	require.NoError(t, err)
+	defer listener.Close()
+
+	// A running agent is not
+	_, err = Listen(path, discardLogger())
+	assert.ErrorContains(t, err, "an agent is already listening on "+path)
+}
+
+func TestListen_Refused(t *testing.T) {
+	t.Run("not a socket", func(t *testing.T) {
+		path := socketPath(t)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o700))
+		require.NoError(t, os.WriteFile(path, nil, 0o600))
+
+		_, err := Listen(path, discardLogger())
+
+		assert.ErrorContains(t, err, "which is not a socket")
+	})
+
+	t.Run("shared directory", func(t *testing.T) {
+		path := socketPath(t)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.Chmod(filepath.Dir(path), 0o755))
+
+		_, err := Listen(path, discardLogger())
+
+		assert.ErrorContains(t, err, "accessible by other users")
+	})
+}
+
+func TestPeerListener_OtherUser(t *testing.T) {
+	path := socketPath(t)
+	ta := newTestAgent(t, "", voidkeytest.Token)
+	listener, err := Listen(path, discardLogger())
+	require.NoError(t, err)
+
+	// Pretend the agent runs as another user
+	listener.(*peerListener).uid = os.Getuid() + 1
+	serveAgent(t, ta, listener)
+
+	transport := &http.Transport{}
+	voidkey.DialUnixSocket(transport, path)
+	client := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+	_, err = client.Get("http://unix/credentials/keys")
+
+	assert.Error(t, err)
+	assert.Equal(t, 0, ta.broker.Mints("AWS_CREDENTIALS"))
+}
+
+func TestDefaultSocketPath(t *testing.T) {
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	assert.Equal(t, "/run/user/1000/voidkey/agent.sock", DefaultSocketPath())
+
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	assert.Equal(t, filepath.Join(os.TempDir(), "voidkey-"+strconv.Itoa(os.Getuid()), "agent.sock"), DefaultSocketPath())
+}
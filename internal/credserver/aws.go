@@ -0,0 +1,50 @@
+package credserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Credential fields the broker returns for AWS keys
+const (
+	awsAccessKeyIDField     = "AWS_ACCESS_KEY_ID"
+	awsSecretAccessKeyField = "AWS_SECRET_ACCESS_KEY"
+	awsSessionTokenField    = "AWS_SESSION_TOKEN"
+)
+
+// errNotAWSKey is returned for keys without AWS credentials
+var errNotAWSKey = errors.New("key has no AWS credentials")
+
+// awsCredentials are the credentials of an AWS key in the JSON shape the
+// AWS metadata endpoints share
+type awsCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+	Expiration      string `json:"Expiration,omitempty"`
+}
+
+// getAWSCredentials returns the credentials of source's key, which must be
+// an AWS key
+func getAWSCredentials(ctx context.Context, source Source) (awsCredentials, error) {
+	response, err := source.Credentials(ctx)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+
+	credentials := awsCredentials{
+		AccessKeyID:     response.Credentials[awsAccessKeyIDField],
+		SecretAccessKey: response.Credentials[awsSecretAccessKeyField],
+		Token:           response.Credentials[awsSessionTokenField],
+	}
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return awsCredentials{}, fmt.Errorf("%w: %s", errNotAWSKey, source.Key())
+	}
+	// SDKs compare the expiration against the local clock
+	if expiry, ok := source.LocalExpiry(response); ok {
+		credentials.Expiration = expiry.UTC().Format(time.RFC3339)
+	}
+	return credentials, nil
+}
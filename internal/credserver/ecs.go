@@ -0,0 +1,74 @@
+package credserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+)
+
+// ECSCredentialsPath is the path the ECS endpoint serves credentials on
+const ECSCredentialsPath = "/credentials"
+
+// Environment variables that point AWS SDKs at an ECS credentials endpoint
+const (
+	ECSFullURIEnv   = "AWS_CONTAINER_CREDENTIALS_FULL_URI"
+	ECSAuthTokenEnv = "AWS_CONTAINER_AUTHORIZATION_TOKEN"
+)
+
+// ecsError is the error response of the ECS endpoint
+type ecsError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ECS emulates the ECS container credentials endpoint that AWS SDKs query
+// when AWS_CONTAINER_CREDENTIALS_FULL_URI is set. Every request must carry
+// the auth token in its Authorization header, which SDKs take from
+// AWS_CONTAINER_AUTHORIZATION_TOKEN.
+type ECS struct {
+	source    Source
+	authToken string
+	logger    *slog.Logger
+}
+
+// NewECS returns an ECS serving the credentials of source to clients that
+// present authToken, see NewAuthToken
+func NewECS(source Source, authToken string, logger *slog.Logger) *ECS {
+	return &ECS{source: source, authToken: authToken, logger: logger}
+}
+
+// ServeHTTP implements http.Handler
+func (s *ECS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.logger.Debug("container credentials request", "method", r.Method, "path", r.URL.Path)
+
+	if r.URL.Path != ECSCredentialsPath {
+		writeECSError(w, http.StatusNotFound, "NotFound", "not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeECSError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "method not allowed")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(s.authToken)) != 1 {
+		writeECSError(w, http.StatusUnauthorized, "Unauthorized", "missing or invalid authorization token")
+		return
+	}
+
+	credentials, err := getAWSCredentials(r.Context(), s.source)
+	if err != nil {
+		s.logger.Warn("failed to get credentials", "key", s.source.Key(), "error", err)
+		writeECSError(w, http.StatusInternalServerError, "InternalError", "failed to get credentials")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(credentials)
+}
+
+// writeECSError writes an error response in the shape SDKs log
+func writeECSError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(ecsError{Code: code, Message: message})
+}
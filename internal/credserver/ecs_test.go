@@ -0,0 +1,102 @@
+package credserver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/voidkey-oss/cli/pkg/voidkey/voidkeytest"
+)
+
+// ecsRequest sends a request to server with the given Authorization header
+func ecsRequest(t *testing.T, server *httptest.Server, method, path, authToken string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, nil)
+	require.NoError(t, err)
+	if authToken != "" {
+		req.Header.Set("Authorization", authToken)
+	}
+	resp, err := server.Client().Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestECS_Credentials(t *testing.T) {
+	broker, source := newAWSSource(t)
+	server := httptest.NewServer(NewECS(source, "auth-token", discardLogger()))
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		resp := ecsRequest(t, server, http.MethodGet, ECSCredentialsPath, "auth-token")
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var credentials awsCredentials
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&credentials))
+		assert.Equal(t, "AKIA-1", credentials.AccessKeyID)
+		assert.Equal(t, "secret-1", credentials.SecretAccessKey)
+		assert.Equal(t, "session-1", credentials.Token)
+		expiration, err := time.Parse(time.RFC3339, credentials.Expiration)
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now().Add(voidkeytest.DefaultLifetime), expiration, 2*time.Second)
+	}
+
+	assert.Equal(t, 1, broker.Mints("AWS_CREDENTIALS"))
+}
+
+func TestECS_Errors(t *testing.T) {
+	tests := []struct {
+		name      string
+		method    string
+		path      string
+		authToken string
+		expected  int
+		code      string
+	}{
+		{name: "no token", method: http.MethodGet, path: ECSCredentialsPath, expected: http.StatusUnauthorized, code: "Unauthorized"},
+		{name: "wrong token", method: http.MethodGet, path: ECSCredentialsPath, authToken: "auth-tokem", expected: http.StatusUnauthorized, code: "Unauthorized"},
+		{name: "token prefix", method: http.MethodGet, path: ECSCredentialsPath, authToken: "auth", expected: http.StatusUnauthorized, code: "Unauthorized"},
+		{name: "other path", method: http.MethodGet, path: "/", authToken: "auth-token", expected: http.StatusNotFound, code: "NotFound"},
+		{name: "POST", method: http.MethodPost, path: ECSCredentialsPath, authToken: "auth-token", expected: http.StatusMethodNotAllowed, code: "MethodNotAllowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, source := newAWSSource(t)
+			server := httptest.NewServer(NewECS(source, "auth-token", discardLogger()))
+			defer server.Close()
+
+			resp := ecsRequest(t, server, tt.method, tt.path, tt.authToken)
+
+			assert.Equal(t, tt.expected, resp.StatusCode)
+			var body ecsError
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			assert.Equal(t, tt.code, body.Code)
+		})
+	}
+}
+
+func TestECS_BrokerUnavailable(t *testing.T) {
+	broker, source := newAWSSource(t)
+	broker.SetUnavailable(true)
+	server := httptest.NewServer(NewECS(source, "auth-token", discardLogger()))
+	defer server.Close()
+
+	resp := ecsRequest(t, server, http.MethodGet, ECSCredentialsPath, "auth-token")
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
+
+func TestNewAuthToken(t *testing.T) {
+	first, err := NewAuthToken()
+	require.NoError(t, err)
+	second, err := NewAuthToken()
+	require.NoError(t, err)
+
+	assert.Len(t, first, 43)
+	assert.NotEqual(t, first, second)
+}
@@ -0,0 +1,165 @@
+package credserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Credential fields the broker returns for GCP keys
+const (
+	gcpAccessTokenField = "GOOGLE_OAUTH_ACCESS_TOKEN"
+	gcpProjectField     = "GOOGLE_CLOUD_PROJECT"
+)
+
+// gceFlavorHeader must be "Google" on every request and is set on every
+// response of the metadata server
+const gceFlavorHeader = "Metadata-Flavor"
+
+// Paths of the GCE metadata endpoints
+const (
+	gceRootPath           = "/computeMetadata/v1/"
+	gceServiceAccountPath = gceRootPath + "instance/service-accounts/default/"
+	gceTokenPath          = gceServiceAccountPath + "token"
+	gceEmailPath          = gceServiceAccountPath + "email"
+	gceProjectIDPath      = gceRootPath + "project/project-id"
+)
+
+// errNotGCPKey is returned for keys without a GCP access token
+var errNotGCPKey = errors.New("key has no GCP access token")
+
+// gceToken is the response of the access token endpoint
+type gceToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+	TokenType   string `json:"token_type"`
+}
+
+// gceServiceAccount is the recursive listing of the default service
+// account, which some libraries read the account's email from
+type gceServiceAccount struct {
+	Aliases []string `json:"aliases"`
+	Email   string   `json:"email"`
+	Scopes  []string `json:"scopes"`
+}
+
+// GCE emulates the service account endpoints of the GCE metadata server.
+// The access token of source is served as the token of the default service
+// account. Like on GCE, requests must carry the Metadata-Flavor: Google
+// header, which browsers cannot be tricked into sending.
+type GCE struct {
+	source Source
+	email  string
+	logger *slog.Logger
+	now    func() time.Time
+}
+
+// NewGCE returns a GCE serving the access token of source as the default
+// service account, which is reported to have the given email
+func NewGCE(source Source, email string, logger *slog.Logger) *GCE {
+	return &GCE{source: source, email: email, logger: logger, now: time.Now}
+}
+
+// ServeHTTP implements http.Handler
+func (s *GCE) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.logger.Debug("metadata request", "method", r.Method, "path", r.URL.Path)
+
+	// Clients detect the metadata server by this header
+	w.Header().Set(gceFlavorHeader, "Google")
+
+	if r.Header.Get("X-Forwarded-For") != "" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if r.Header.Get(gceFlavorHeader) != "Google" {
+		http.Error(w, "Missing required header: "+gceFlavorHeader, http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/", gceRootPath, strings.TrimSuffix(gceRootPath, "/"):
+		// Libraries probe the root to check the server is up, Python's
+		// google-auth the server's root rather than the metadata root
+		w.Header().Set("Content-Type", "application/text")
+	case gceServiceAccountPath:
+		writeJSON(w, gceServiceAccount{
+			Aliases: []string{"default"},
+			Email:   s.email,
+			Scopes:  []string{},
+		})
+	case gceEmailPath:
+		w.Header().Set("Content-Type", "application/text")
+		_, _ = w.Write([]byte(s.email))
+	case gceTokenPath:
+		s.serveToken(w, r)
+	case gceProjectIDPath:
+		s.serveProjectID(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveToken returns the key's access token. The scopes clients ask for
+// are ignored; they are fixed by the key on the broker.
+func (s *GCE) serveToken(w http.ResponseWriter, r *http.Request) {
+	token, err := s.getToken(r.Context())
+	if err != nil {
+		s.logger.Warn("failed to get credentials", "key", s.source.Key(), "error", err)
+		http.Error(w, "failed to get credentials", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, token)
+}
+
+// serveProjectID returns the project of the key, if the broker returns one
+func (s *GCE) serveProjectID(w http.ResponseWriter, r *http.Request) {
+	response, err := s.source.Credentials(r.Context())
+	if err != nil {
+		s.logger.Warn("failed to get credentials", "key", s.source.Key(), "error", err)
+		http.Error(w, "failed to get credentials", http.StatusInternalServerError)
+		return
+	}
+	project := response.Credentials[gcpProjectField]
+	if project == "" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/text")
+	_, _ = w.Write([]byte(project))
+}
+
+// getToken returns the access token of source's key, which must be a GCP
+// key
+func (s *GCE) getToken(ctx context.Context) (gceToken, error) {
+	response, err := s.source.Credentials(ctx)
+	if err != nil {
+		return gceToken{}, err
+	}
+
+	token := gceToken{
+		AccessToken: response.Credentials[gcpAccessTokenField],
+		TokenType:   "Bearer",
+	}
+	if token.AccessToken == "" {
+		return gceToken{}, fmt.Errorf("%w: %s", errNotGCPKey, s.source.Key())
+	}
+	if expiry, ok := s.source.LocalExpiry(response); ok {
+		token.ExpiresIn = max(int(expiry.Sub(s.now()).Seconds()), 0)
+	}
+	return token, nil
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
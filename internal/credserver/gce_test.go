@@ -0,0 +1,143 @@
+package credserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/voidkey-oss/cli/pkg/voidkey/voidkeytest"
+)
+
+// newGCPSource returns a source for a GCP_CREDENTIALS key and its broker
+func newGCPSource(t *testing.T) (*voidkeytest.Broker, Source) {
+	t.Helper()
+	return newSource(t, "GCP_CREDENTIALS", map[string]string{
+		gcpAccessTokenField: "ya29.token",
+		gcpProjectField:     "test-project",
+	})
+}
+
+// gceRequest sends a request to server, with the Metadata-Flavor header
+// unless header overrides it
+func gceRequest(t *testing.T, server *httptest.Server, method, path string, header http.Header) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, nil)
+	require.NoError(t, err)
+	req.Header.Set(gceFlavorHeader, "Google")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	resp, err := server.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "Google", resp.Header.Get(gceFlavorHeader))
+	return resp, string(body)
+}
+
+func TestGCE_Token(t *testing.T) {
+	broker, source := newGCPSource(t)
+	server := httptest.NewServer(NewGCE(source, "deploy@example.iam.gserviceaccount.com", discardLogger()))
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, body := gceRequest(t, server, http.MethodGet, gceTokenPath+"?scopes=https://www.googleapis.com/auth/cloud-platform", nil)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var token gceToken
+		require.NoError(t, json.Unmarshal([]byte(body), &token))
+		assert.Equal(t, "ya29.token-1", token.AccessToken)
+		assert.Equal(t, "Bearer", token.TokenType)
+		assert.InDelta(t, voidkeytest.DefaultLifetime.Seconds(), token.ExpiresIn, 5)
+	}
+
+	assert.Equal(t, 1, broker.Mints("GCP_CREDENTIALS"))
+}
+
+func TestGCE_Metadata(t *testing.T) {
+	_, source := newGCPSource(t)
+	server := httptest.NewServer(NewGCE(source, "deploy@example.iam.gserviceaccount.com", discardLogger()))
+	defer server.Close()
+
+	resp, _ := gceRequest(t, server, http.MethodGet, gceRootPath, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// google-auth pings the server's root
+	resp, _ = gceRequest(t, server, http.MethodGet, "/", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "Google", resp.Header.Get(gceFlavorHeader))
+
+	resp, body := gceRequest(t, server, http.MethodGet, gceEmailPath, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "deploy@example.iam.gserviceaccount.com", body)
+
+	resp, body = gceRequest(t, server, http.MethodGet, gceServiceAccountPath+"?recursive=true", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var account gceServiceAccount
+	require.NoError(t, json.Unmarshal([]byte(body), &account))
+	assert.Equal(t, "deploy@example.iam.gserviceaccount.com", account.Email)
+	assert.Equal(t, []string{"default"}, account.Aliases)
+
+	resp, body = gceRequest(t, server, http.MethodGet, gceProjectIDPath, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "test-project-1", body)
+}
+
+func TestGCE_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		header   http.Header
+		expected int
+	}{
+		{name: "no flavor header", method: http.MethodGet, path: gceTokenPath, header: http.Header{gceFlavorHeader: nil}, expected: http.StatusForbidden},
+		{name: "wrong flavor header", method: http.MethodGet, path: gceTokenPath, header: http.Header{gceFlavorHeader: {"google"}}, expected: http.StatusForbidden},
+		{name: "forwarded", method: http.MethodGet, path: gceTokenPath, header: http.Header{"X-Forwarded-For": {"10.0.0.1"}}, expected: http.StatusForbidden},
+		{name: "POST", method: http.MethodPost, path: gceTokenPath, expected: http.StatusMethodNotAllowed},
+		{name: "other account", method: http.MethodGet, path: "/computeMetadata/v1/instance/service-accounts/other/token", expected: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, source := newGCPSource(t)
+			server := httptest.NewServer(NewGCE(source, "default", discardLogger()))
+			defer server.Close()
+
+			resp, _ := gceRequest(t, server, tt.method, tt.path, tt.header)
+
+			assert.Equal(t, tt.expected, resp.StatusCode)
+		})
+	}
+}
+
+func TestGCE_NotGCPKey(t *testing.T) {
+	_, source := newAWSSource(t)
+	server := httptest.NewServer(NewGCE(source, "default", discardLogger()))
+	defer server.Close()
+
+	resp, _ := gceRequest(t, server, http.MethodGet, gceTokenPath, nil)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	resp, _ = gceRequest(t, server, http.MethodGet, gceProjectIDPath, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestGCE_ExpiresIn(t *testing.T) {
+	_, source := newGCPSource(t)
+	gce := NewGCE(source, "default", discardLogger())
+	gce.now = func() time.Time { return time.Now().Add(2 * voidkeytest.DefaultLifetime) }
+
+	token, err := gce.getToken(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 0, token.ExpiresIn)
+}
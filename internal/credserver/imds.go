@@ -0,0 +1,189 @@
+package credserver
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IMDSv2 headers
+const (
+	imdsTokenHeader    = "X-Aws-Ec2-Metadata-Token"
+	imdsTokenTTLHeader = "X-Aws-Ec2-Metadata-Token-Ttl-Seconds"
+)
+
+// Paths of the IMDS endpoints
+const (
+	imdsTokenPath       = "/latest/api/token"
+	imdsCredentialsPath = "/latest/meta-data/iam/security-credentials/"
+)
+
+// maxIMDSTokenTTL is the longest session token lifetime EC2 grants
+const maxIMDSTokenTTL = 6 * time.Hour
+
+// maxIMDSTokens bounds the number of unexpired session tokens kept, so
+// clients that never reuse their token cannot exhaust memory
+const maxIMDSTokens = 10000
+
+// imdsCredentials is the response of the security credentials endpoint
+type imdsCredentials struct {
+	Code        string `json:"Code"`
+	LastUpdated string `json:"LastUpdated"`
+	Type        string `json:"Type"`
+	awsCredentials
+}
+
+// IMDS emulates the credential endpoints of the EC2 instance metadata
+// service, version 2 only: every request needs a session token from
+// PUT /latest/api/token. The credentials of source are served as the
+// instance profile role.
+type IMDS struct {
+	source Source
+	role   string
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu     sync.Mutex
+	tokens map[string]time.Time
+}
+
+// NewIMDS returns an IMDS serving the credentials of source under role
+func NewIMDS(source Source, role string, logger *slog.Logger) *IMDS {
+	return &IMDS{
+		source: source,
+		role:   role,
+		logger: logger,
+		now:    time.Now,
+		tokens: make(map[string]time.Time),
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (s *IMDS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.logger.Debug("metadata request", "method", r.Method, "path", r.URL.Path)
+
+	if r.URL.Path == imdsTokenPath {
+		s.serveToken(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.validToken(r.Header.Get(imdsTokenHeader)) {
+		// IMDSv1 requests without a token are refused like on instances
+		// that require IMDSv2
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.URL.Path {
+	case imdsCredentialsPath, strings.TrimSuffix(imdsCredentialsPath, "/"):
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte(s.role))
+	case imdsCredentialsPath + s.role:
+		s.serveCredentials(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveToken issues a session token for the TTL the client asks for
+func (s *IMDS) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// EC2 refuses token requests that went through a proxy, so the
+	// token cannot leak beyond the instance
+	if r.Header.Get("X-Forwarded-For") != "" {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	seconds, err := strconv.Atoi(r.Header.Get(imdsTokenTTLHeader))
+	ttl := time.Duration(seconds) * time.Second
+	if err != nil || ttl <= 0 || ttl > maxIMDSTokenTTL {
+		http.Error(w, "invalid "+imdsTokenTTLHeader, http.StatusBadRequest)
+		return
+	}
+
+	token, err := s.newToken(ttl)
+	if err != nil {
+		s.logger.Error("failed to create metadata session token", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set(imdsTokenTTLHeader, strconv.Itoa(seconds))
+	_, _ = w.Write([]byte(token))
+}
+
+// serveCredentials returns the key's credentials in the IMDS format
+func (s *IMDS) serveCredentials(w http.ResponseWriter, r *http.Request) {
+	credentials, err := getAWSCredentials(r.Context(), s.source)
+	if err != nil {
+		s.logger.Warn("failed to get credentials", "key", s.source.Key(), "error", err)
+		http.Error(w, "failed to get credentials", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(imdsCredentials{
+		Code:           "Success",
+		LastUpdated:    s.now().UTC().Format(time.RFC3339),
+		Type:           "AWS-HMAC",
+		awsCredentials: credentials,
+	})
+}
+
+// newToken creates a session token valid for ttl
+func (s *IMDS) newToken(ttl time.Duration) (string, error) {
+	token, err := NewAuthToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if len(s.tokens) >= maxIMDSTokens {
+		for t, expiry := range s.tokens {
+			if !now.Before(expiry) {
+				delete(s.tokens, t)
+			}
+		}
+	}
+	if len(s.tokens) >= maxIMDSTokens {
+		for t := range s.tokens {
+			delete(s.tokens, t)
+			break
+		}
+	}
+	s.tokens[token] = now.Add(ttl)
+	return token, nil
+}
+
+// validToken reports whether token is an unexpired session token
+func (s *IMDS) validToken(token string) bool {
+	if token == "" {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expiry, ok := s.tokens[token]
+	if !ok {
+		return false
+	}
+	if !s.now().Before(expiry) {
+		delete(s.tokens, token)
+		return false
+	}
+	return true
+}
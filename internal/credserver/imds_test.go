@@ -0,0 +1,166 @@
+package credserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/voidkey-oss/cli/pkg/voidkey/voidkeytest"
+)
+
+// imdsRequest sends a request to server with the given session token
+func imdsRequest(t *testing.T, server *httptest.Server, method, path, token string, header http.Header) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+path, nil)
+	require.NoError(t, err)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if token != "" {
+		req.Header.Set(imdsTokenHeader, token)
+	}
+
+	resp, err := server.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp, string(body)
+}
+
+// imdsToken fetches a session token from server
+func imdsToken(t *testing.T, server *httptest.Server) string {
+	t.Helper()
+	resp, token := imdsRequest(t, server, http.MethodPut, imdsTokenPath, "", http.Header{imdsTokenTTLHeader: {"21600"}})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "21600", resp.Header.Get(imdsTokenTTLHeader))
+	return token
+}
+
+func TestIMDS_Credentials(t *testing.T) {
+	broker, source := newAWSSource(t)
+	server := httptest.NewServer(NewIMDS(source, "voidkey", discardLogger()))
+	defer server.Close()
+	token := imdsToken(t, server)
+
+	resp, role := imdsRequest(t, server, http.MethodGet, imdsCredentialsPath, token, nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "voidkey", role)
+
+	resp, body := imdsRequest(t, server, http.MethodGet, imdsCredentialsPath+"voidkey", token, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var credentials imdsCredentials
+	require.NoError(t, json.Unmarshal([]byte(body), &credentials))
+	assert.Equal(t, "Success", credentials.Code)
+	assert.Equal(t, "AWS-HMAC", credentials.Type)
+	assert.Equal(t, "AKIA-1", credentials.AccessKeyID)
+	assert.Equal(t, "secret-1", credentials.SecretAccessKey)
+	assert.Equal(t, "session-1", credentials.Token)
+	expiration, err := time.Parse(time.RFC3339, credentials.Expiration)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(voidkeytest.DefaultLifetime), expiration, 2*time.Second)
+
+	// Credentials are cached between requests
+	_, _ = imdsRequest(t, server, http.MethodGet, imdsCredentialsPath+"voidkey", token, nil)
+	assert.Equal(t, 1, broker.Mints("AWS_CREDENTIALS"))
+}
+
+func TestIMDS_RequiresSessionToken(t *testing.T) {
+	_, source := newAWSSource(t)
+	server := httptest.NewServer(NewIMDS(source, "voidkey", discardLogger()))
+	defer server.Close()
+
+	resp, _ := imdsRequest(t, server, http.MethodGet, imdsCredentialsPath+"voidkey", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	resp, _ = imdsRequest(t, server, http.MethodGet, imdsCredentialsPath+"voidkey", "made-up", nil)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestIMDS_TokenRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		header   http.Header
+		expected int
+	}{
+		{name: "valid", method: http.MethodPut, header: http.Header{imdsTokenTTLHeader: {"60"}}, expected: http.StatusOK},
+		{name: "GET", method: http.MethodGet, header: http.Header{imdsTokenTTLHeader: {"60"}}, expected: http.StatusMethodNotAllowed},
+		{name: "missing TTL", method: http.MethodPut, expected: http.StatusBadRequest},
+		{name: "TTL too long", method: http.MethodPut, header: http.Header{imdsTokenTTLHeader: {"21601"}}, expected: http.StatusBadRequest},
+		{name: "zero TTL", method: http.MethodPut, header: http.Header{imdsTokenTTLHeader: {"0"}}, expected: http.StatusBadRequest},
+		{name: "proxied", method: http.MethodPut, header: http.Header{imdsTokenTTLHeader: {"60"}, "X-Forwarded-For": {"10.0.0.1"}}, expected: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, source := newAWSSource(t)
+			server := httptest.NewServer(NewIMDS(source, "voidkey", discardLogger()))
+			defer server.Close()
+
+			resp, _ := imdsRequest(t, server, tt.method, imdsTokenPath, "", tt.header)
+
+			assert.Equal(t, tt.expected, resp.StatusCode)
+		})
+	}
+}
+
+func TestIMDS_TokenExpires(t *testing.T) {
+	_, source := newAWSSource(t)
+	imds := NewIMDS(source, "voidkey", discardLogger())
+	now := time.Now()
+	imds.now = func() time.Time { return now }
+	server := httptest.NewServer(imds)
+	defer server.Close()
+
+	resp, token := imdsRequest(t, server, http.MethodPut, imdsTokenPath, "", http.Header{imdsTokenTTLHeader: {"60"}})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, imds.validToken(token))
+
+	now = now.Add(time.Minute)
+	assert.False(t, imds.validToken(token))
+}
+
+func TestIMDS_UnknownPaths(t *testing.T) {
+	_, source := newAWSSource(t)
+	server := httptest.NewServer(NewIMDS(source, "voidkey", discardLogger()))
+	defer server.Close()
+	token := imdsToken(t, server)
+
+	resp, _ := imdsRequest(t, server, http.MethodGet, imdsCredentialsPath+"other-role", token, nil)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = imdsRequest(t, server, http.MethodPost, imdsCredentialsPath+"voidkey", token, nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestIMDS_BrokerErrors(t *testing.T) {
+	broker, source := newAWSSource(t)
+	broker.SetUnavailable(true)
+	server := httptest.NewServer(NewIMDS(source, "voidkey", discardLogger()))
+	defer server.Close()
+	token := imdsToken(t, server)
+
+	resp, body := imdsRequest(t, server, http.MethodGet, imdsCredentialsPath+"voidkey", token, nil)
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.False(t, strings.Contains(body, "broker unavailable"))
+}
+
+func TestIMDS_NotAnAWSKey(t *testing.T) {
+	_, source := newSource(t, "MINIO_CREDENTIALS", map[string]string{"MINIO_ACCESS_KEY_ID": "minio"})
+	server := httptest.NewServer(NewIMDS(source, "voidkey", discardLogger()))
+	defer server.Close()
+	token := imdsToken(t, server)
+
+	resp, _ := imdsRequest(t, server, http.MethodGet, imdsCredentialsPath+"voidkey", token, nil)
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+}
@@ -0,0 +1,117 @@
+// Package credserver serves Voidkey credentials over the metadata endpoints
+// cloud SDKs already know how to query, so existing tools pick them up
+// without configuration.
+package credserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+)
+
+// shutdownTimeout bounds how long in-flight requests may take once the
+// server is stopped
+const shutdownTimeout = 5 * time.Second
+
+// RefreshInterval is how often the servers check whether credentials are
+// due to be refreshed
+const RefreshInterval = 30 * time.Second
+
+// Source supplies the credentials of a key. *voidkey.KeySource implements
+// it; it caches the credentials and refreshes them before they expire.
+type Source interface {
+	// Key returns the name of the key
+	Key() string
+	// Credentials returns valid credentials for the key
+	Credentials(ctx context.Context) (voidkey.KeyCredentialResponse, error)
+	// LocalExpiry converts the expiry time of credentials to the local clock
+	LocalExpiry(response voidkey.KeyCredentialResponse) (time.Time, bool)
+}
+
+// Serve serves handler on listener until ctx is done, then shuts the server
+// down gracefully. Credentials of source, if not nil, are refreshed in the
+// background while it runs, so requests never wait for the broker.
+func Serve(ctx context.Context, listener net.Listener, handler http.Handler, source Source, logger *slog.Logger) error {
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelDebug),
+	}
+
+	refreshCtx, stopRefresh := context.WithCancel(ctx)
+	defer stopRefresh()
+	if source != nil {
+		go KeepFresh(refreshCtx, source, RefreshInterval, logger)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// KeepFresh asks source for credentials every interval until ctx is done,
+// so they are refreshed ahead of expiry even while nobody requests them.
+// Failures are logged; the next request retries.
+func KeepFresh(ctx context.Context, source Source, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := source.Credentials(ctx); err != nil && ctx.Err() == nil {
+			logger.Warn("failed to refresh credentials", "key", source.Key(), "error", err)
+		}
+	}
+}
+
+// NewAuthToken returns a random token for clients to authenticate with
+func NewAuthToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// IsLoopback reports whether addr, a host:port listen address, only
+// accepts connections from the local machine
+func IsLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
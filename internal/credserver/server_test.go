@@ -0,0 +1,75 @@
+package credserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+)
+
+func TestServe(t *testing.T) {
+	_, source := newAWSSource(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- Serve(ctx, listener, NewIMDS(source, "voidkey", discardLogger()), source, discardLogger())
+	}()
+
+	resp, err := http.Get("http://" + listener.Addr().String() + imdsCredentialsPath)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	cancel()
+	select {
+	case err := <-errs:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+}
+
+func TestKeepFresh(t *testing.T) {
+	broker, _ := newAWSSource(t)
+	// Credentials valid for a minute are always within the refresh window
+	source := voidkey.NewKeySource(broker.Client(t), "AWS_CREDENTIALS", voidkey.WithDuration(time.Minute))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		KeepFresh(ctx, source, 10*time.Millisecond, discardLogger())
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return broker.Mints("AWS_CREDENTIALS") >= 2 }, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestIsLoopback(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1:1338":  true,
+		"[::1]:1338":      true,
+		"localhost:1338":  true,
+		"127.1.2.3:80":    true,
+		"0.0.0.0:1338":    false,
+		":1338":           false,
+		"10.0.0.1:1338":   false,
+		"example.com:80":  false,
+		"127.0.0.1":       false,
+		"[::]:1338":       false,
+		"169.254.169.254": false,
+	}
+
+	for addr, expected := range tests {
+		assert.Equal(t, expected, IsLoopback(addr), addr)
+	}
+}
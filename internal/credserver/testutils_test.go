@@ -0,0 +1,34 @@
+package credserver
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+	"github.com/voidkey-oss/cli/pkg/voidkey/voidkeytest"
+)
+
+// discardLogger returns a logger that drops everything
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// newSource starts a broker with a key of the given credentials and returns
+// it with a source for the key
+func newSource(t *testing.T, key string, credentials map[string]string) (*voidkeytest.Broker, *voidkey.KeySource) {
+	t.Helper()
+	broker := voidkeytest.NewBroker(t)
+	broker.SetKey(key, credentials)
+	return broker, voidkey.NewKeySource(broker.Client(t), key)
+}
+
+// newAWSSource returns a source for an AWS_CREDENTIALS key and its broker
+func newAWSSource(t *testing.T) (*voidkeytest.Broker, *voidkey.KeySource) {
+	t.Helper()
+	return newSource(t, "AWS_CREDENTIALS", map[string]string{
+		awsAccessKeyIDField:     "AKIA",
+		awsSecretAccessKeyField: "secret",
+		awsSessionTokenField:    "session",
+	})
+}
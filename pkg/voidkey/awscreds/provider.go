@@ -0,0 +1,75 @@
+// Package awscreds provides AWS SDK for Go v2 credentials backed by a
+// Voidkey key.
+//
+//	client, err := voidkey.New(
+//		voidkey.WithBaseURL("https://broker.example.com"),
+//		voidkey.WithTokenSource(voidkey.EnvToken()),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	cfg, err := config.LoadDefaultConfig(ctx,
+//		config.WithCredentialsProvider(awscreds.New(client, "AWS_CREDENTIALS")),
+//	)
+package awscreds
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+)
+
+// Source is reported as the source of the credentials
+const Source = "VoidkeyProvider"
+
+// Credential fields the broker returns for AWS keys
+const (
+	AccessKeyIDField     = "AWS_ACCESS_KEY_ID"
+	SecretAccessKeyField = "AWS_SECRET_ACCESS_KEY"
+	SessionTokenField    = "AWS_SESSION_TOKEN"
+)
+
+// Provider is an aws.CredentialsProvider that mints the credentials of a
+// Voidkey key. Credentials are cached and refreshed before they expire by
+// a voidkey.KeySource, so the provider can be used on its own or wrapped in
+// aws.NewCredentialsCache.
+type Provider struct {
+	source *voidkey.KeySource
+}
+
+var _ aws.CredentialsProvider = (*Provider)(nil)
+
+// New returns a provider for the key with the given name, see
+// voidkey.NewKeySource for the options
+func New(client *voidkey.Client, key string, opts ...voidkey.KeySourceOption) *Provider {
+	return &Provider{source: voidkey.NewKeySource(client, key, opts...)}
+}
+
+// Retrieve returns the key's credentials, minting new ones if the cached
+// ones are about to expire
+func (p *Provider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	response, err := p.source.Credentials(ctx)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+
+	credentials := aws.Credentials{
+		AccessKeyID:     response.Credentials[AccessKeyIDField],
+		SecretAccessKey: response.Credentials[SecretAccessKeyField],
+		SessionToken:    response.Credentials[SessionTokenField],
+		Source:          Source,
+	}
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return aws.Credentials{}, fmt.Errorf("key %s has no %s and %s credentials: %w",
+			p.source.Key(), AccessKeyIDField, SecretAccessKeyField, voidkey.ErrInvalidResponse)
+	}
+
+	// The SDK compares the expiry time against the local clock
+	if expiry, ok := p.source.LocalExpiry(response); ok {
+		credentials.CanExpire = true
+		credentials.Expires = expiry
+	}
+	return credentials, nil
+}
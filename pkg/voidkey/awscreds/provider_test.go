@@ -0,0 +1,98 @@
+package awscreds
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+	"github.com/voidkey-oss/cli/pkg/voidkey/voidkeytest"
+)
+
+// newBroker starts a broker with an AWS_CREDENTIALS key
+func newBroker(t *testing.T) *voidkeytest.Broker {
+	broker := voidkeytest.NewBroker(t)
+	broker.SetKey("AWS_CREDENTIALS", map[string]string{
+		AccessKeyIDField:     "AKIA",
+		SecretAccessKeyField: "secret",
+		SessionTokenField:    "session",
+	})
+	return broker
+}
+
+func TestProvider_Retrieve(t *testing.T) {
+	broker := newBroker(t)
+	provider := New(broker.Client(t), "AWS_CREDENTIALS")
+
+	credentials, err := provider.Retrieve(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, "AKIA-1", credentials.AccessKeyID)
+	assert.Equal(t, "secret-1", credentials.SecretAccessKey)
+	assert.Equal(t, "session-1", credentials.SessionToken)
+	assert.Equal(t, Source, credentials.Source)
+	assert.True(t, credentials.CanExpire)
+	assert.WithinDuration(t, time.Now().Add(voidkeytest.DefaultLifetime), credentials.Expires, 2*time.Second)
+}
+
+func TestProvider_CachesAndRefreshesEarly(t *testing.T) {
+	broker := newBroker(t)
+	provider := New(broker.Client(t), "AWS_CREDENTIALS", voidkey.WithDuration(10*time.Minute), voidkey.WithRefreshBefore(5*time.Minute))
+
+	for i := 0; i < 3; i++ {
+		credentials, err := provider.Retrieve(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "AKIA-1", credentials.AccessKeyID)
+	}
+	assert.Equal(t, 1, broker.Mints("AWS_CREDENTIALS"))
+
+	// Within the refresh window the credentials are minted again
+	provider = New(broker.Client(t), "AWS_CREDENTIALS", voidkey.WithDuration(4*time.Minute))
+	for i := 0; i < 2; i++ {
+		_, err := provider.Retrieve(context.Background())
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 3, broker.Mints("AWS_CREDENTIALS"))
+}
+
+func TestProvider_ExpiryOnLocalClock(t *testing.T) {
+	broker := newBroker(t)
+	broker.SetClockSkew(2 * time.Hour)
+	provider := New(broker.Client(t), "AWS_CREDENTIALS")
+
+	credentials, err := provider.Retrieve(context.Background())
+
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(voidkeytest.DefaultLifetime), credentials.Expires, 2*time.Second)
+}
+
+func TestProvider_WithCredentialsCache(t *testing.T) {
+	broker := newBroker(t)
+	cache := aws.NewCredentialsCache(New(broker.Client(t), "AWS_CREDENTIALS"))
+
+	for i := 0; i < 2; i++ {
+		credentials, err := cache.Retrieve(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "AKIA-1", credentials.AccessKeyID)
+	}
+	assert.Equal(t, 1, broker.Mints("AWS_CREDENTIALS"))
+}
+
+func TestProvider_Errors(t *testing.T) {
+	broker := newBroker(t)
+	broker.SetKey("MINIO_CREDENTIALS", map[string]string{"MINIO_ACCESS_KEY_ID": "minio"})
+
+	_, err := New(broker.Client(t), "MINIO_CREDENTIALS").Retrieve(context.Background())
+	assert.ErrorIs(t, err, voidkey.ErrInvalidResponse)
+	assert.Contains(t, err.Error(), "key MINIO_CREDENTIALS has no AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY credentials")
+
+	_, err = New(broker.Client(t), "UNKNOWN").Retrieve(context.Background())
+	assert.ErrorIs(t, err, voidkey.ErrRequestRejected)
+
+	broker.SetUnavailable(true)
+	_, err = New(broker.Client(t), "AWS_CREDENTIALS").Retrieve(context.Background())
+	assert.ErrorIs(t, err, voidkey.ErrBrokerUnavailable)
+}
@@ -0,0 +1,402 @@
+package voidkey
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Broker API endpoints, relative to the server URL
+const (
+	idpProvidersPath = "credentials/idp-providers"
+	mintPath         = "credentials/mint"
+	keysPath         = "credentials/keys"
+)
+
+// defaultUserAgent is sent to the broker unless WithUserAgent says otherwise
+const defaultUserAgent = "voidkey-go"
+
+// defaultTimeout bounds each request made with the default HTTP client
+const defaultTimeout = 30 * time.Second
+
+// HTTPClient sends HTTP requests. *http.Client implements it.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Client talks to a Voidkey broker. It is safe for concurrent use.
+type Client struct {
+	httpClient  HTTPClient
+	serverURL   string
+	baseURL     *url.URL
+	userAgent   string
+	tokenSource TokenSource
+	logger      *slog.Logger
+	retry       RetryPolicy
+	// sleep waits between retries; replaced in tests
+	sleep func(ctx context.Context, d time.Duration) error
+
+	// Capability discovery, see WithDiscovery
+	discoveryMu           sync.Mutex
+	discover              bool
+	discoveryCacheDir     string
+	capabilities          *Capabilities
+	capabilitiesFetchedAt time.Time
+
+	// Clock skew measured from the Date header of the last response
+	clockMu    sync.Mutex
+	clockSkew  time.Duration
+	clockKnown bool
+}
+
+// New creates a client for the broker given with WithBaseURL. Without
+// WithHTTPClient requests are sent with a 30 second timeout, refusing
+// redirects to other hosts and from HTTPS to HTTP.
+func New(opts ...Option) (*Client, error) {
+	c := &Client{
+		userAgent: defaultUserAgent,
+		logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+		retry:     DefaultRetryPolicy,
+		sleep:     sleepContext,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.serverURL == "" {
+		return nil, &ServerURLError{Reason: "missing broker URL, use WithBaseURL"}
+	}
+	base, err := ParseServerURL(c.serverURL)
+	if err != nil {
+		return nil, err
+	}
+	c.baseURL = base
+
+	if c.httpClient == nil {
+		c.httpClient = &http.Client{
+			Timeout:       defaultTimeout,
+			Transport:     defaultTransport(base),
+			CheckRedirect: CheckRedirect,
+		}
+	}
+	if c.retry.MaxAttempts < 1 {
+		c.retry.MaxAttempts = 1
+	}
+	return c, nil
+}
+
+// BaseURL returns the broker URL the client was created with
+func (c *Client) BaseURL() string {
+	return c.serverURL
+}
+
+// ParseServerURL parses and validates a broker URL. http and https URLs
+// may include a base path for brokers mounted under a sub-path;
+// unix:///path/to.sock addresses a broker on a unix socket. Invalid URLs are
+// reported as *ServerURLError.
+func ParseServerURL(serverURL string) (*url.URL, error) {
+	invalid := func(format string, args ...any) error {
+		return &ServerURLError{URL: serverURL, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, invalid("%v", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		if u.Host == "" {
+			return nil, invalid("missing host")
+		}
+	case "unix":
+		if u.Host != "" || u.Path == "" {
+			return nil, invalid("unix socket URLs must look like unix:///path/to.sock")
+		}
+	case "":
+		return nil, invalid("missing scheme (e.g. https://%s)", serverURL)
+	default:
+		return nil, invalid("unsupported scheme %q (must be http, https or unix)", u.Scheme)
+	}
+
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, invalid("must not contain a query or fragment")
+	}
+	return u, nil
+}
+
+// endpoint returns the URL of a broker API endpoint. The path is joined to
+// the server URL, so a trailing slash or a base path is handled, and the
+// query values are escaped. Requests to a unix socket broker use
+// unixSocketBase; the transport dials the socket.
+func (c *Client) endpoint(path string, query url.Values) string {
+	base := c.baseURL
+	if base.Scheme == "unix" {
+		base, _ = url.Parse(unixSocketBase)
+	}
+
+	u := base.JoinPath(path)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// logRequest logs the outcome of a broker request at debug level
+func (c *Client) logRequest(method, url string, start time.Time, resp *http.Response, err error) {
+	attrs := []any{"method", method, "url", url, "latency", time.Since(start)}
+	if err != nil {
+		c.logger.Debug("broker request failed", append(attrs, "error", err)...)
+		return
+	}
+	c.logger.Debug("broker request", append(attrs, "status", resp.StatusCode)...)
+}
+
+// request describes a single call to the broker
+type request struct {
+	method string
+	url    string
+	// body, if not nil, is sent as JSON
+	body   []byte
+	header http.Header
+	// secrets are scrubbed from errors, e.g. the OIDC token
+	secrets []string
+}
+
+// doRequest sends a request to the broker and returns the body of a
+// successful response. Failures are retried according to the client's retry
+// policy. The request is cancelled when ctx is done, or once the policy's
+// MaxElapsed has passed if it allows retries.
+func (c *Client) doRequest(ctx context.Context, r request) ([]byte, error) {
+	start := time.Now()
+	if c.retry.MaxAttempts > 1 && c.retry.MaxElapsed > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.retry.MaxElapsed)
+		defer cancel()
+	}
+
+	for attempt := 0; ; attempt++ {
+		respBody, err := c.attempt(ctx, r)
+		if err == nil {
+			return respBody, nil
+		}
+
+		var retryable *retryableError
+		if !errors.As(err, &retryable) {
+			return nil, err
+		}
+		if attempt+1 >= c.retry.MaxAttempts {
+			return nil, retryable.err
+		}
+
+		delay := c.retry.delay(attempt, retryable.retryAfter)
+		if c.retry.MaxElapsed > 0 && time.Since(start)+delay > c.retry.MaxElapsed {
+			c.logger.Debug("retry budget exhausted", "url", r.url, "attempts", attempt+1, "elapsed", time.Since(start))
+			return nil, retryable.err
+		}
+
+		c.logger.Debug("retrying broker request", "method", r.method, "url", r.url, "attempt", attempt+1, "delay", delay, "error", retryable.err)
+		if err := c.sleep(ctx, delay); err != nil {
+			return nil, fmt.Errorf("%w (retry cancelled: %w)", retryable.err, err)
+		}
+	}
+}
+
+// attempt sends a single request. Failures that may succeed when retried
+// are returned as *retryableError.
+func (c *Client) attempt(ctx context.Context, r request) ([]byte, error) {
+	var reqBody io.Reader
+	if r.body != nil {
+		reqBody = bytes.NewReader(r.body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.method, r.url, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", redactError(err, r.secrets))
+	}
+	for name, values := range r.header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	if r.body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+	c.logRequest(r.method, redact(r.url, r.secrets), start, resp, err)
+	if err != nil {
+		err = WrapKind(ErrBrokerUnavailable, fmt.Errorf("failed to connect to broker server at %s: %w", c.serverURL, redactError(err, r.secrets)))
+		if ctx.Err() != nil || errors.Is(err, ErrRedirectRefused) {
+			// Cancelled by the caller or refused by the redirect policy,
+			// retrying would not help
+			return nil, err
+		}
+		return nil, &retryableError{err: err}
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	c.recordClockSkew(resp.Header.Get("Date"), start, time.Now())
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &retryableError{err: WrapKind(ErrBrokerUnavailable, fmt.Errorf("failed to read response: %w", err))}
+	}
+
+	// Brokers answer 207 Multi-Status when only some keys could be minted
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusMultiStatus {
+		err := newBrokerError(resp.StatusCode, respBody, r.secrets)
+		if retryableStatus(resp.StatusCode) {
+			return nil, &retryableError{err: err, retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+		}
+		return nil, err
+	}
+
+	return respBody, nil
+}
+
+// IdpProvider is an identity provider configured on the broker
+type IdpProvider struct {
+	Name      string `json:"name"`
+	IsDefault bool   `json:"isDefault"`
+}
+
+// ListIdpProviders returns the identity providers configured on the broker
+func (c *Client) ListIdpProviders(ctx context.Context) ([]IdpProvider, error) {
+	endpoint, err := c.featureEndpoint(ctx, FeatureIdpProviders, nil)
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.doRequest(ctx, request{method: http.MethodGet, url: endpoint})
+	if err != nil {
+		return nil, err
+	}
+
+	var providers []IdpProvider
+	if err := json.Unmarshal(body, &providers); err != nil {
+		return nil, WrapKind(ErrInvalidResponse, fmt.Errorf("failed to parse providers response: %w", err))
+	}
+	return providers, nil
+}
+
+// MintRequest selects the keys to mint
+type MintRequest struct {
+	// Token is the OIDC token to authenticate with. If empty, it is taken
+	// from the client's TokenSource.
+	Token string
+	// IdpName is the identity provider that issued the token; empty for
+	// the broker's default
+	IdpName string
+	// Keys are the names of the keys to mint
+	Keys []string
+	// All mints every key the identity is permitted to use instead of Keys
+	All bool
+	// Duration overrides the default lifetime of the credentials. It is
+	// sent in whole seconds.
+	Duration time.Duration
+}
+
+// mintRequestBody is the JSON body of a mint request
+type mintRequestBody struct {
+	OidcToken string   `json:"oidcToken"`
+	IdpName   string   `json:"idpName,omitempty"`
+	Keys      []string `json:"keys,omitempty"`
+	Duration  int      `json:"duration,omitempty"`
+	All       bool     `json:"all,omitempty"`
+}
+
+// MintKeys mints credentials for the requested keys. The result has an
+// entry per key; keys the broker refused while minting others have Error set
+// instead of credentials.
+func (c *Client) MintKeys(ctx context.Context, req MintRequest) (map[string]KeyCredentialResponse, error) {
+	token, err := c.token(ctx, req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	jsonData, err := json.Marshal(mintRequestBody{
+		OidcToken: token,
+		IdpName:   req.IdpName,
+		Keys:      req.Keys,
+		Duration:  int(req.Duration / time.Second),
+		All:       req.All,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Minting is not idempotent, so every attempt of this call carries the
+	// same key for the broker to deduplicate retries on
+	header := http.Header{}
+	header.Set("Idempotency-Key", newIdempotencyKey())
+
+	endpoint, err := c.featureEndpoint(ctx, FeatureMint, nil)
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.doRequest(ctx, request{method: http.MethodPost, url: endpoint, body: jsonData, header: header, secrets: []string{token}})
+	if err != nil {
+		return nil, err
+	}
+
+	var keyResponses map[string]KeyCredentialResponse
+	if err := json.Unmarshal(body, &keyResponses); err != nil {
+		return nil, WrapKind(ErrInvalidResponse, fmt.Errorf("failed to parse key responses: %w", err))
+	}
+	return keyResponses, nil
+}
+
+// ListKeys returns the names of the keys the identity of token is permitted
+// to mint. An empty token is taken from the client's TokenSource.
+func (c *Client) ListKeys(ctx context.Context, token string) ([]string, error) {
+	token, err := c.token(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint, err := c.featureEndpoint(ctx, FeatureKeys, url.Values{"token": {token}})
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.doRequest(ctx, request{method: http.MethodGet, url: endpoint, secrets: []string{token}})
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	if err := json.Unmarshal(body, &keys); err != nil {
+		return nil, WrapKind(ErrInvalidResponse, fmt.Errorf("failed to parse keys response: %w", err))
+	}
+	return keys, nil
+}
+
+// KeyCredentialResponse is the broker's answer for a single key. Error is
+// set instead of the credentials when the broker could not mint the key.
+type KeyCredentialResponse struct {
+	Credentials map[string]string `json:"credentials,omitempty"`
+	ExpiresAt   string            `json:"expiresAt,omitempty"`
+	Metadata    map[string]any    `json:"metadata,omitempty"`
+	Error       *KeyError         `json:"error,omitempty"`
+}
+
+// MarshalJSON always includes credentials and expiresAt for minted keys, so
+// consumers can rely on both; keys the broker refused only have an error
+func (r KeyCredentialResponse) MarshalJSON() ([]byte, error) {
+	type response KeyCredentialResponse
+	if r.Error != nil {
+		return json.Marshal(response(r))
+	}
+	return json.Marshal(struct {
+		Credentials map[string]string `json:"credentials"`
+		ExpiresAt   string            `json:"expiresAt"`
+		Metadata    map[string]any    `json:"metadata,omitempty"`
+	}{r.Credentials, r.ExpiresAt, r.Metadata})
+}
@@ -0,0 +1,290 @@
+package voidkey
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	client, err := New(WithBaseURL("https://broker.example.com"))
+
+	assert.NoError(t, err)
+	assert.Equal(t, "https://broker.example.com", client.BaseURL())
+	assert.Equal(t, DefaultRetryPolicy, client.retry)
+	assert.Equal(t, defaultUserAgent, client.userAgent)
+	httpClient, ok := client.httpClient.(*http.Client)
+	require.True(t, ok)
+	assert.Equal(t, defaultTimeout, httpClient.Timeout)
+	assert.NotNil(t, httpClient.CheckRedirect)
+}
+
+func TestNew_InvalidBaseURL(t *testing.T) {
+	_, err := New()
+
+	var urlErr *ServerURLError
+	assert.ErrorAs(t, err, &urlErr)
+	assert.Contains(t, err.Error(), "WithBaseURL")
+
+	_, err = New(WithBaseURL("localhost:3000"))
+
+	assert.ErrorAs(t, err, &urlErr)
+	assert.Equal(t, "localhost:3000", urlErr.URL)
+}
+
+func TestClient_ListIdpProviders(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client := newTestClient(t, mockClient)
+	mockClient.On("Do", requestMatching(http.MethodGet, testServerURL+"/credentials/idp-providers")).Return(newResponse(http.StatusOK, testIdpProviders()), nil)
+
+	providers, err := client.ListIdpProviders(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, testIdpProviders(), providers)
+	mockClient.AssertExpectations(t)
+}
+
+func TestClient_ListIdpProviders_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		response *http.Response
+		expected string
+	}{
+		{name: "server error", response: newResponse(http.StatusInternalServerError, "Internal server error"), expected: "server returned error 500: Internal server error"},
+		{name: "invalid JSON", response: newResponse(http.StatusOK, "invalid json"), expected: "failed to parse providers response"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := &MockHTTPClient{}
+			client := newTestClient(t, mockClient)
+			mockClient.On("Do", requestMatching(http.MethodGet, testServerURL+"/credentials/idp-providers")).Return(tt.response, nil)
+
+			providers, err := client.ListIdpProviders(context.Background())
+
+			assert.Error(t, err)
+			assert.Nil(t, providers)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+}
+
+func TestClient_MintKeys(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client := newTestClient(t, mockClient)
+
+	var body mintRequestBody
+	mockClient.On("Do", requestMatching(http.MethodPost, testServerURL+"/credentials/mint")).Run(func(args mock.Arguments) {
+		data, _ := io.ReadAll(args.Get(0).(*http.Request).Body)
+		_ = json.Unmarshal(data, &body)
+	}).Return(newResponse(http.StatusOK, testKeyCredentials()), nil)
+
+	keyResponses, err := client.MintKeys(context.Background(), MintRequest{
+		Token:    "test-token",
+		IdpName:  "github",
+		Keys:     []string{"MINIO_CREDENTIALS"},
+		Duration: 30 * time.Minute,
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, testKeyCredentials(), keyResponses)
+	assert.Equal(t, mintRequestBody{OidcToken: "test-token", IdpName: "github", Keys: []string{"MINIO_CREDENTIALS"}, Duration: 1800}, body)
+	mockClient.AssertExpectations(t)
+}
+
+func TestClient_MintKeys_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		response *http.Response
+		expected string
+	}{
+		{name: "server error", response: newResponse(http.StatusInternalServerError, "Key not found"), expected: "server returned error 500"},
+		{name: "invalid JSON", response: newResponse(http.StatusOK, "invalid json"), expected: "failed to parse key responses"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := &MockHTTPClient{}
+			client := newTestClient(t, mockClient)
+			mockClient.On("Do", requestMatching(http.MethodPost, testServerURL+"/credentials/mint")).Return(tt.response, nil)
+
+			keyResponses, err := client.MintKeys(context.Background(), MintRequest{Token: "test-token", Keys: []string{"A"}})
+
+			assert.Error(t, err)
+			assert.Nil(t, keyResponses)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+}
+
+func TestClient_ListKeys(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client := newTestClient(t, mockClient)
+	expected := []string{"MINIO_CREDENTIALS", "AWS_CREDENTIALS"}
+	mockClient.On("Do", requestMatching(http.MethodGet, testServerURL+"/credentials/keys?token=test-token")).Return(newResponse(http.StatusOK, expected), nil)
+
+	keys, err := client.ListKeys(context.Background(), "test-token")
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, keys)
+	mockClient.AssertExpectations(t)
+}
+
+func TestClient_ListKeys_InvalidJSON(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client := newTestClient(t, mockClient)
+	mockClient.On("Do", requestMatching(http.MethodGet, testServerURL+"/credentials/keys?token=test-token")).Return(newResponse(http.StatusOK, "invalid json"), nil)
+
+	keys, err := client.ListKeys(context.Background(), "test-token")
+
+	assert.ErrorIs(t, err, ErrInvalidResponse)
+	assert.Nil(t, keys)
+	assert.Contains(t, err.Error(), "failed to parse keys response")
+}
+
+func TestClient_RequestHeaders(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client := newTestClient(t, mockClient, WithUserAgent("my-service/1.0"))
+	mockClient.On("Do", mock.MatchedBy(func(req *http.Request) bool {
+		return req.Header.Get("Content-Type") == "application/json" &&
+			req.Header.Get("Accept") == "application/json" &&
+			req.Header.Get("User-Agent") == "my-service/1.0" &&
+			req.Header.Get("Idempotency-Key") != ""
+	})).Return(newResponse(http.StatusOK, testKeyCredentials()), nil)
+
+	_, err := client.MintKeys(context.Background(), MintRequest{Token: "test-token", Keys: []string{"MINIO_CREDENTIALS"}})
+
+	assert.NoError(t, err)
+	mockClient.AssertExpectations(t)
+}
+
+func TestClient_ContextCancellation(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client, err := New(WithBaseURL(server.URL))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err = client.ListIdpProviders(ctx)
+
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestClient_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client, err := New(WithBaseURL(server.URL), WithHTTPClient(&http.Client{Timeout: 50 * time.Millisecond}), WithRetryPolicy(NoRetry))
+	require.NoError(t, err)
+
+	_, err = client.ListIdpProviders(context.Background())
+
+	assert.ErrorIs(t, err, ErrBrokerUnavailable)
+	assert.Contains(t, err.Error(), "failed to connect to broker server")
+}
+
+func TestParseServerURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		server   string
+		expected string
+	}{
+		{name: "http", server: "http://localhost:3000"},
+		{name: "https with base path", server: "https://host.example.com/voidkey/"},
+		{name: "unix socket", server: "unix:///var/run/voidkey.sock"},
+		{name: "missing scheme", server: "broker.example.com", expected: "missing scheme"},
+		{name: "missing host", server: "https:///credentials", expected: "missing host"},
+		{name: "unsupported scheme", server: "ftp://broker.example.com", expected: "unsupported scheme"},
+		{name: "unix socket with host", server: "unix://host/voidkey.sock", expected: "unix socket URLs must look like"},
+		{name: "unix socket without path", server: "unix://", expected: "unix socket URLs must look like"},
+		{name: "query", server: "https://broker.example.com/?token=x", expected: "must not contain a query"},
+		{name: "fragment", server: "https://broker.example.com/#top", expected: "must not contain a query or fragment"},
+		{name: "unparsable", server: "https://broker example.com", expected: "invalid character"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseServerURL(tt.server)
+
+			if tt.expected == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var urlErr *ServerURLError
+			assert.ErrorAs(t, err, &urlErr)
+			assert.Equal(t, tt.server, urlErr.URL)
+			assert.Contains(t, urlErr.Reason, tt.expected)
+		})
+	}
+}
+
+func TestClient_Endpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		server   string
+		path     string
+		query    url.Values
+		expected string
+	}{
+		{name: "plain", server: "http://localhost:3000", path: mintPath, expected: "http://localhost:3000/credentials/mint"},
+		{name: "trailing slash", server: "http://localhost:3000/", path: mintPath, expected: "http://localhost:3000/credentials/mint"},
+		{name: "base path", server: "https://host.example.com/voidkey", path: mintPath, expected: "https://host.example.com/voidkey/credentials/mint"},
+		{name: "base path with trailing slash", server: "https://host.example.com/voidkey/", path: mintPath, expected: "https://host.example.com/voidkey/credentials/mint"},
+		{name: "nested base path", server: "https://host.example.com/api/v1/voidkey//", path: idpProvidersPath, expected: "https://host.example.com/api/v1/voidkey/credentials/idp-providers"},
+		{name: "escaped query", server: "https://host.example.com", path: keysPath, query: url.Values{"token": {"a+b/c=&d"}}, expected: "https://host.example.com/credentials/keys?token=a%2Bb%2Fc%3D%26d"},
+		{name: "escaped base path", server: "https://host.example.com/void%20key", path: keysPath, expected: "https://host.example.com/void%20key/credentials/keys"},
+		{name: "unix socket", server: "unix:///run/voidkey.sock", path: keysPath, expected: "http://unix/credentials/keys"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := New(WithBaseURL(tt.server))
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.expected, client.endpoint(tt.path, tt.query))
+		})
+	}
+}
+
+func TestKeyCredentialResponse_MarshalJSON(t *testing.T) {
+	minted, err := json.Marshal(KeyCredentialResponse{Credentials: map[string]string{"A": "1"}})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"credentials": {"A": "1"}, "expiresAt": ""}`, string(minted))
+
+	refused, err := json.Marshal(KeyCredentialResponse{Error: &KeyError{Code: "key_not_found", Message: "no such key"}})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"error": {"code": "key_not_found", "message": "no such key"}}`, string(refused))
+
+	var decoded KeyCredentialResponse
+	require.NoError(t, json.Unmarshal(minted, &decoded))
+	assert.Equal(t, KeyCredentialResponse{Credentials: map[string]string{"A": "1"}}, decoded)
+}
@@ -0,0 +1,67 @@
+package voidkey
+
+import (
+	"net/http"
+	"time"
+)
+
+// recordClockSkew measures the difference between the broker's clock, from
+// the Date header of a response, and the local clock at the middle of the
+// request
+func (c *Client) recordClockSkew(date string, sent, received time.Time) {
+	if date == "" {
+		return
+	}
+	serverTime, err := http.ParseTime(date)
+	if err != nil {
+		c.logger.Debug("ignoring invalid Date header", "date", date)
+		return
+	}
+
+	local := sent.Add(received.Sub(sent) / 2)
+	skew := serverTime.Sub(local)
+
+	c.clockMu.Lock()
+	c.clockSkew = skew
+	c.clockKnown = true
+	c.clockMu.Unlock()
+	c.logger.Debug("measured clock skew", "skew", skew)
+}
+
+// ClockSkew returns how far the broker's clock is ahead of the local one,
+// as measured on the last response with a Date header. The Date header has
+// a resolution of one second. ok is false if no skew was measured yet.
+func (c *Client) ClockSkew() (skew time.Duration, ok bool) {
+	c.clockMu.Lock()
+	defer c.clockMu.Unlock()
+	return c.clockSkew, c.clockKnown
+}
+
+// Now returns the current time by the broker's clock, the local time
+// corrected by the measured clock skew. Expiry times from the broker are
+// compared against it.
+func (c *Client) Now() time.Time {
+	skew, _ := c.ClockSkew()
+	return time.Now().Add(skew)
+}
+
+// Expiry returns when the credentials expire, or false if the broker did
+// not send a valid expiry time
+func (r KeyCredentialResponse) Expiry() (time.Time, bool) {
+	if r.ExpiresAt == "" {
+		return time.Time{}, false
+	}
+	expiry, err := time.Parse(time.RFC3339, r.ExpiresAt)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return expiry, true
+}
+
+// FreshAt reports whether the credentials are still valid for at least
+// minRemaining at now, which should come from Client.Now. Credentials
+// without an expiry time are never considered fresh.
+func (r KeyCredentialResponse) FreshAt(now time.Time, minRemaining time.Duration) bool {
+	expiry, ok := r.Expiry()
+	return ok && expiry.Sub(now) >= minRemaining
+}
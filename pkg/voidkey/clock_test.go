@@ -0,0 +1,70 @@
+package voidkey
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_ClockSkew(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(2*time.Hour).UTC().Format(http.TimeFormat))
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+	client, err := New(WithBaseURL(server.URL))
+	require.NoError(t, err)
+
+	_, ok := client.ClockSkew()
+	assert.False(t, ok)
+
+	_, err = client.ListIdpProviders(context.Background())
+
+	assert.NoError(t, err)
+	skew, ok := client.ClockSkew()
+	assert.True(t, ok)
+	assert.InDelta(t, (2 * time.Hour).Seconds(), skew.Seconds(), 2)
+	assert.InDelta(t, time.Now().Add(2*time.Hour).Unix(), client.Now().Unix(), 2)
+}
+
+func TestClient_RecordClockSkew_InvalidDate(t *testing.T) {
+	client := newTestClient(t, &MockHTTPClient{})
+	now := time.Now()
+
+	client.recordClockSkew("", now, now)
+	client.recordClockSkew("yesterday", now, now)
+
+	_, ok := client.ClockSkew()
+	assert.False(t, ok)
+}
+
+func TestKeyCredentialResponse_FreshAt(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		expiresAt    string
+		minRemaining time.Duration
+		expected     bool
+	}{
+		{name: "valid", expiresAt: "2025-01-01T13:00:00Z", expected: true},
+		{name: "valid for long enough", expiresAt: "2025-01-01T13:00:00Z", minRemaining: time.Hour, expected: true},
+		{name: "not valid for long enough", expiresAt: "2025-01-01T12:30:00Z", minRemaining: time.Hour},
+		{name: "expired", expiresAt: "2025-01-01T11:59:59Z"},
+		{name: "time zone offset", expiresAt: "2025-01-01T13:30:00+01:00", expected: true},
+		{name: "no expiry", expiresAt: ""},
+		{name: "invalid expiry", expiresAt: "tomorrow"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := KeyCredentialResponse{ExpiresAt: tt.expiresAt}
+			assert.Equal(t, tt.expected, response.FreshAt(now, tt.minRemaining))
+		})
+	}
+}
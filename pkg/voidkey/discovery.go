@@ -0,0 +1,229 @@
+package voidkey
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// discoveryPath is the well-known endpoint describing a broker's
+// capabilities, relative to the server URL
+const discoveryPath = ".well-known/voidkey"
+
+// discoveryTTL is how long discovered capabilities are cached
+const discoveryTTL = time.Hour
+
+// Broker features, also used as endpoint names in the discovery document
+const (
+	FeatureMint         = "mint"
+	FeatureKeys         = "keys"
+	FeatureIdpProviders = "idp-providers"
+)
+
+// Permissions of the on-disk discovery and credential caches
+const (
+	cacheDirMode  = 0700
+	cacheFileMode = 0600
+)
+
+// legacyEndpoints are the endpoints of brokers without discovery, which all
+// support exactly these features
+var legacyEndpoints = map[string]string{
+	FeatureMint:         mintPath,
+	FeatureKeys:         keysPath,
+	FeatureIdpProviders: idpProvidersPath,
+}
+
+// Capabilities describes what a broker supports, as published at
+// /.well-known/voidkey
+type Capabilities struct {
+	// APIVersion is the version of the broker API
+	APIVersion string `json:"apiVersion"`
+	// ServerVersion is the version of the broker software
+	ServerVersion string `json:"serverVersion"`
+	// Features lists the features the broker supports
+	Features []string `json:"features"`
+	// Endpoints maps features to their paths, relative to the server URL.
+	// Features without an entry use the legacy path.
+	Endpoints map[string]string `json:"endpoints"`
+	// Legacy is set for brokers that predate discovery
+	Legacy bool `json:"legacy,omitempty"`
+}
+
+// legacyCapabilities returns the capabilities assumed for brokers that
+// predate discovery
+func legacyCapabilities() *Capabilities {
+	features := make([]string, 0, len(legacyEndpoints))
+	for feature := range legacyEndpoints {
+		features = append(features, feature)
+	}
+	slices.Sort(features)
+	return &Capabilities{Features: features, Legacy: true}
+}
+
+// Supports reports whether the broker supports feature
+func (c *Capabilities) Supports(feature string) bool {
+	return slices.Contains(c.Features, feature)
+}
+
+// endpointPath returns the path of the endpoint for feature
+func (c *Capabilities) endpointPath(feature string) string {
+	if path, ok := c.Endpoints[feature]; ok && path != "" {
+		return path
+	}
+	return legacyEndpoints[feature]
+}
+
+// DisplayVersion returns the server version for display, saying so when it
+// is unknown
+func (c *Capabilities) DisplayVersion() string {
+	switch {
+	case c.ServerVersion != "":
+		return c.ServerVersion
+	case c.Legacy:
+		return "unknown (broker does not support discovery)"
+	default:
+		return "unknown"
+	}
+}
+
+// discoveryCacheEntry is the on-disk form of cached capabilities
+type discoveryCacheEntry struct {
+	Server       string        `json:"server"`
+	FetchedAt    time.Time     `json:"fetchedAt"`
+	Capabilities *Capabilities `json:"capabilities"`
+}
+
+// DefaultDiscoveryCacheDir returns the per-user directory for WithDiscovery
+// to cache capabilities in, or an empty string if there is none
+func DefaultDiscoveryCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "voidkey", "discovery")
+}
+
+// Capabilities returns the broker's capabilities, discovering them on first
+// use if the client was created WithDiscovery. Brokers that answer the
+// discovery request with 404 are legacy brokers.
+func (c *Client) Capabilities(ctx context.Context) (*Capabilities, error) {
+	c.discoveryMu.Lock()
+	defer c.discoveryMu.Unlock()
+
+	if !c.discover {
+		return legacyCapabilities(), nil
+	}
+	if c.capabilities != nil && time.Since(c.capabilitiesFetchedAt) < discoveryTTL {
+		return c.capabilities, nil
+	}
+
+	if entry := c.readDiscoveryCache(); entry != nil {
+		c.capabilities, c.capabilitiesFetchedAt = entry.Capabilities, entry.FetchedAt
+		return c.capabilities, nil
+	}
+
+	capabilities, err := c.fetchCapabilities(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.capabilities, c.capabilitiesFetchedAt = capabilities, time.Now()
+	c.writeDiscoveryCache()
+	return capabilities, nil
+}
+
+// fetchCapabilities requests the discovery document from the broker
+func (c *Client) fetchCapabilities(ctx context.Context) (*Capabilities, error) {
+	endpoint := c.endpoint(discoveryPath, nil)
+	body, err := c.doRequest(ctx, request{method: http.MethodGet, url: endpoint})
+	if err != nil {
+		var brokerErr *BrokerError
+		if errors.As(err, &brokerErr) && brokerErr.StatusCode == http.StatusNotFound {
+			c.logger.Debug("broker does not support discovery, using legacy endpoints", "url", endpoint)
+			return legacyCapabilities(), nil
+		}
+		return nil, fmt.Errorf("failed to discover broker capabilities: %w", err)
+	}
+
+	var capabilities Capabilities
+	if err := json.Unmarshal(body, &capabilities); err != nil {
+		return nil, WrapKind(ErrInvalidResponse, fmt.Errorf("failed to parse discovery response: %w", err))
+	}
+	capabilities.Legacy = false
+	c.logger.Debug("discovered broker capabilities", "apiVersion", capabilities.APIVersion,
+		"serverVersion", capabilities.ServerVersion, "features", capabilities.Features)
+	return &capabilities, nil
+}
+
+// featureEndpoint returns the URL of the endpoint for feature, failing if
+// the broker does not support it
+func (c *Client) featureEndpoint(ctx context.Context, feature string, query url.Values) (string, error) {
+	capabilities, err := c.Capabilities(ctx)
+	if err != nil {
+		return "", err
+	}
+	if !capabilities.Supports(feature) {
+		return "", WrapKind(ErrUnsupportedFeature, fmt.Errorf("broker too old for feature %q (server version %s); upgrade the broker",
+			feature, capabilities.DisplayVersion()))
+	}
+	return c.endpoint(capabilities.endpointPath(feature), query), nil
+}
+
+// discoveryCacheFile returns the cache file for the client's server URL
+func (c *Client) discoveryCacheFile() string {
+	if c.discoveryCacheDir == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(c.serverURL))
+	return filepath.Join(c.discoveryCacheDir, hex.EncodeToString(sum[:8])+".json")
+}
+
+// readDiscoveryCache returns the cached capabilities for the server URL if
+// they are still fresh
+func (c *Client) readDiscoveryCache() *discoveryCacheEntry {
+	path := c.discoveryCacheFile()
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var entry discoveryCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Capabilities == nil {
+		c.logger.Debug("ignoring invalid discovery cache", "path", path, "error", err)
+		return nil
+	}
+	if entry.Server != c.serverURL || time.Since(entry.FetchedAt) >= discoveryTTL {
+		return nil
+	}
+	return &entry
+}
+
+// writeDiscoveryCache stores the capabilities on disk. The cache is an
+// optimisation, so failures are only logged.
+func (c *Client) writeDiscoveryCache() {
+	path := c.discoveryCacheFile()
+	if path == "" {
+		return
+	}
+
+	data, _ := json.Marshal(discoveryCacheEntry{Server: c.serverURL, FetchedAt: c.capabilitiesFetchedAt, Capabilities: c.capabilities})
+	if err := os.MkdirAll(filepath.Dir(path), cacheDirMode); err != nil {
+		c.logger.Debug("failed to create discovery cache directory", "error", err)
+		return
+	}
+	if err := os.WriteFile(path, data, cacheFileMode); err != nil {
+		c.logger.Debug("failed to write discovery cache", "error", err)
+	}
+}
@@ -0,0 +1,172 @@
+package voidkey
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newDiscoveryBroker starts a broker that publishes the given discovery
+// document, or answers 404 for it when document is empty. It counts the
+// discovery requests it receives.
+func newDiscoveryBroker(t *testing.T, document string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var discoveries atomic.Int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/voidkey", func(w http.ResponseWriter, r *http.Request) {
+		discoveries.Add(1)
+		if document == "" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(document))
+	})
+	providers := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"name":"github","isDefault":true}]`))
+	}
+	mux.HandleFunc("/credentials/idp-providers", providers)
+	mux.HandleFunc("/v2/idps", providers)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, &discoveries
+}
+
+// newDiscoveryClient returns a client for server with discovery enabled
+func newDiscoveryClient(t *testing.T, server *httptest.Server, serverURL, cacheDir string) *Client {
+	t.Helper()
+	client, err := New(WithBaseURL(serverURL), WithHTTPClient(server.Client()), WithRetryPolicy(NoRetry), WithDiscovery(cacheDir))
+	require.NoError(t, err)
+	return client
+}
+
+func TestDiscovery_CustomEndpoints(t *testing.T) {
+	server, discoveries := newDiscoveryBroker(t, `{
+		"apiVersion": "2",
+		"serverVersion": "0.9.0",
+		"features": ["idp-providers", "mint"],
+		"endpoints": {"idp-providers": "/v2/idps"}
+	}`)
+
+	client := newDiscoveryClient(t, server, server.URL, "")
+
+	for i := 0; i < 2; i++ {
+		providers, err := client.ListIdpProviders(context.Background())
+		assert.NoError(t, err)
+		assert.Len(t, providers, 1)
+	}
+
+	// Capabilities are only discovered once per client
+	assert.Equal(t, int32(1), discoveries.Load())
+
+	capabilities, err := client.Capabilities(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "0.9.0", capabilities.ServerVersion)
+	assert.False(t, capabilities.Legacy)
+}
+
+func TestDiscovery_UnsupportedFeature(t *testing.T) {
+	server, _ := newDiscoveryBroker(t, `{"serverVersion": "0.5.0", "features": ["mint"]}`)
+
+	client := newDiscoveryClient(t, server, server.URL, "")
+
+	_, err := client.ListKeys(context.Background(), "test-token")
+
+	assert.ErrorIs(t, err, ErrUnsupportedFeature)
+	assert.Contains(t, err.Error(), `broker too old for feature "keys" (server version 0.5.0)`)
+}
+
+func TestDiscovery_LegacyBroker(t *testing.T) {
+	server, discoveries := newDiscoveryBroker(t, "")
+
+	client := newDiscoveryClient(t, server, server.URL, "")
+
+	providers, err := client.ListIdpProviders(context.Background())
+
+	assert.NoError(t, err)
+	assert.Len(t, providers, 1)
+	assert.Equal(t, int32(1), discoveries.Load())
+	capabilities, err := client.Capabilities(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, capabilities.Legacy)
+	assert.Equal(t, "unknown (broker does not support discovery)", capabilities.DisplayVersion())
+}
+
+func TestDiscovery_SubPath(t *testing.T) {
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		if r.URL.Path == "/voidkey/.well-known/voidkey" {
+			_, _ = w.Write([]byte(`{"features": ["idp-providers"], "endpoints": {"idp-providers": "/api/idps"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+
+	client := newDiscoveryClient(t, server, server.URL+"/voidkey/", "")
+
+	_, err := client.ListIdpProviders(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/voidkey/.well-known/voidkey", "/voidkey/api/idps"}, requested)
+}
+
+func TestDiscovery_DiskCache(t *testing.T) {
+	server, discoveries := newDiscoveryBroker(t, `{"serverVersion": "1.0.0", "features": ["idp-providers"]}`)
+	cacheDir := t.TempDir()
+
+	// A second invocation uses the capabilities cached by the first
+	for i := 0; i < 2; i++ {
+		client := newDiscoveryClient(t, server, server.URL, cacheDir)
+		capabilities, err := client.Capabilities(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "1.0.0", capabilities.ServerVersion)
+	}
+	assert.Equal(t, int32(1), discoveries.Load())
+
+	entries, err := os.ReadDir(cacheDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	path := filepath.Join(cacheDir, entries[0].Name())
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(cacheFileMode), info.Mode().Perm())
+
+	// Expired entries are discovered again
+	expired, _ := json.Marshal(discoveryCacheEntry{
+		Server:       server.URL,
+		FetchedAt:    time.Now().Add(-2 * discoveryTTL),
+		Capabilities: &Capabilities{ServerVersion: "0.1.0"},
+	})
+	require.NoError(t, os.WriteFile(path, expired, cacheFileMode))
+
+	client := newDiscoveryClient(t, server, server.URL, cacheDir)
+	capabilities, err := client.Capabilities(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, "1.0.0", capabilities.ServerVersion)
+	assert.Equal(t, int32(2), discoveries.Load())
+}
+
+func TestDiscovery_Disabled(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client := newTestClient(t, mockClient)
+
+	capabilities, err := client.Capabilities(context.Background())
+
+	assert.NoError(t, err)
+	assert.True(t, capabilities.Legacy)
+	assert.True(t, capabilities.Supports(FeatureMint))
+	mockClient.AssertNotCalled(t, "Do")
+}
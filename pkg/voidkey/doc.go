@@ -0,0 +1,19 @@
+// Package voidkey is a client for the Voidkey credential broker. It mints
+// short-lived credentials for named keys in exchange for an OIDC token.
+//
+// Create a client with New and functional options, then call its methods
+// with a context:
+//
+//	client, err := voidkey.New(
+//		voidkey.WithBaseURL("https://broker.example.com"),
+//		voidkey.WithTokenSource(voidkey.EnvToken()),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	creds, err := client.MintKeys(ctx, voidkey.MintRequest{Keys: []string{"AWS_CREDENTIALS"}})
+//
+// Failed requests return errors that match one of the Err* kinds with
+// errors.Is. Errors answered by the broker are *BrokerError values with the
+// HTTP status and the per-key failures it reported.
+package voidkey
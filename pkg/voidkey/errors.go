@@ -0,0 +1,331 @@
+package voidkey
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Error kinds returned by the client. Use errors.Is to tell them apart.
+var (
+	// ErrUnauthorized means the broker rejected the OIDC token
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbiddenKey means the identity is not permitted to use a requested key
+	ErrForbiddenKey = errors.New("key not permitted")
+	// ErrBrokerUnavailable means the broker could not be reached or failed
+	// to handle the request
+	ErrBrokerUnavailable = errors.New("broker unavailable")
+	// ErrInvalidResponse means the broker answered with something the
+	// client could not understand
+	ErrInvalidResponse = errors.New("invalid broker response")
+	// ErrRequestRejected means the broker rejected the request for another
+	// reason, e.g. an unknown IdP or key
+	ErrRequestRejected = errors.New("request rejected")
+	// ErrUnsupportedFeature means the broker is too old for a feature
+	ErrUnsupportedFeature = errors.New("feature not supported by broker")
+	// ErrNoToken means no OIDC token was given and the client has no
+	// TokenSource, or the source has none
+	ErrNoToken = errors.New("no OIDC token")
+)
+
+// redactedPlaceholder replaces secrets in error messages
+const redactedPlaceholder = "[REDACTED]"
+
+// ServerURLError is returned for a broker URL the client cannot use
+type ServerURLError struct {
+	// URL is the invalid URL as given
+	URL string
+	// Reason says what is wrong with it
+	Reason string
+}
+
+func (e *ServerURLError) Error() string {
+	return fmt.Sprintf("invalid broker URL %q: %s", e.URL, e.Reason)
+}
+
+// BrokerError is returned when the broker answers with an error status
+type BrokerError struct {
+	// StatusCode is the HTTP status of the response
+	StatusCode int
+	// Code is the machine-readable error code from the response body, if any
+	Code string
+	// Message describes the error; the raw body if it was not structured
+	Message string
+	// Details holds additional free-form details from the response body
+	Details string
+	// KeyFailures lists the requested keys the broker refused and why
+	KeyFailures []KeyFailure
+}
+
+// KeyFailure describes why the broker refused a single key
+type KeyFailure struct {
+	Key    string `json:"key"`
+	Code   string `json:"code,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (e *BrokerError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "server returned error %d: %s", e.StatusCode, e.Message)
+	if e.Details != "" {
+		fmt.Fprintf(&b, " (%s)", e.Details)
+	}
+	for _, failure := range e.KeyFailures {
+		fmt.Fprintf(&b, "\n  - %s: %s", failure.Key, failure.Reason)
+	}
+	return b.String()
+}
+
+// Unwrap returns the kind of the error, so errors.Is(err, ErrUnauthorized)
+// and friends work on broker errors. The error code wins over the status,
+// for brokers that answer with a generic status but a specific code.
+func (e *BrokerError) Unwrap() error {
+	if kind := ErrorKind(e.Code); kind != nil {
+		return kind
+	}
+	return kindForStatus(e.StatusCode)
+}
+
+// KeyError describes why the broker could not mint a single key
+type KeyError struct {
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e *KeyError) Error() string {
+	return e.Message
+}
+
+// Unwrap returns the kind of the error, ErrRequestRejected unless the code
+// says otherwise
+func (e *KeyError) Unwrap() error {
+	if kind := ErrorKind(e.Code); kind != nil {
+		return kind
+	}
+	return ErrRequestRejected
+}
+
+// UnmarshalJSON accepts both an error object and a bare message string
+func (e *KeyError) UnmarshalJSON(data []byte) error {
+	var message string
+	if err := json.Unmarshal(data, &message); err == nil {
+		e.Message = message
+		return nil
+	}
+
+	type keyError KeyError
+	var parsed keyError
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return err
+	}
+	*e = KeyError(parsed)
+	return nil
+}
+
+// errorCodeKinds maps broker error codes to error kinds
+var errorCodeKinds = map[string]error{
+	"unauthorized":      ErrUnauthorized,
+	"invalid_token":     ErrUnauthorized,
+	"token_expired":     ErrUnauthorized,
+	"forbidden":         ErrForbiddenKey,
+	"access_denied":     ErrForbiddenKey,
+	"key_not_permitted": ErrForbiddenKey,
+}
+
+// ErrorKind returns the error kind for a broker error code, e.g.
+// ErrForbiddenKey for "access_denied", or nil for codes without one
+func ErrorKind(code string) error {
+	return errorCodeKinds[code]
+}
+
+// brokerErrorBody is the structured error body returned by the broker, e.g.
+//
+//	{"error": "forbidden", "message": "...", "details": {"AWS_PROD": "..."}}
+type brokerErrorBody struct {
+	Error   string          `json:"error"`
+	Code    string          `json:"code"`
+	Message string          `json:"message"`
+	Details json.RawMessage `json:"details"`
+}
+
+// newBrokerError builds the error for a response with a non-OK status. The
+// message, per-key failures and details are taken from a structured JSON
+// body when there is one; otherwise the raw body is used. secrets are
+// scrubbed from all of them, in case the broker echoes the token.
+func newBrokerError(status int, body []byte, secrets []string) *BrokerError {
+	e := &BrokerError{
+		StatusCode: status,
+		Message:    redact(strings.TrimSpace(string(body)), secrets),
+	}
+
+	var parsed brokerErrorBody
+	if err := json.Unmarshal(body, &parsed); err == nil {
+		e.Code = parsed.Code
+		if e.Code == "" {
+			e.Code = parsed.Error
+		}
+
+		switch {
+		case parsed.Message != "":
+			e.Message = redact(parsed.Message, secrets)
+		case parsed.Error != "":
+			e.Message = redact(parsed.Error, secrets)
+		}
+
+		e.KeyFailures, e.Details = parseErrorDetails(parsed.Details)
+		for i := range e.KeyFailures {
+			e.KeyFailures[i].Reason = redact(e.KeyFailures[i].Reason, secrets)
+		}
+		e.Details = redact(e.Details, secrets)
+	}
+
+	return e
+}
+
+// parseErrorDetails extracts per-key failures from the details of an error
+// body. Brokers report them either as an object mapping key names to a
+// reason (or to an object with a reason), or as a list of objects naming the
+// key. Details in any other shape are returned as text.
+func parseErrorDetails(raw json.RawMessage) ([]KeyFailure, string) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, ""
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return nil, text
+	}
+
+	type detail struct {
+		Key     string `json:"key"`
+		Code    string `json:"code"`
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	toFailure := func(key string, d detail) KeyFailure {
+		reason := d.Reason
+		if reason == "" {
+			reason = d.Message
+		}
+		if reason == "" {
+			reason = d.Error
+		}
+		return KeyFailure{Key: key, Code: d.Code, Reason: reason}
+	}
+
+	var list []detail
+	if err := json.Unmarshal(raw, &list); err == nil {
+		var failures []KeyFailure
+		for _, d := range list {
+			if d.Key != "" {
+				failures = append(failures, toFailure(d.Key, d))
+			}
+		}
+		if len(failures) > 0 {
+			return failures, ""
+		}
+		return nil, string(raw)
+	}
+
+	// Some brokers nest the per-key map under "keys"
+	var nested struct {
+		Keys map[string]json.RawMessage `json:"keys"`
+	}
+	entries := map[string]json.RawMessage{}
+	if err := json.Unmarshal(raw, &nested); err == nil && len(nested.Keys) > 0 {
+		entries = nested.Keys
+	} else if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, string(raw)
+	}
+
+	var failures []KeyFailure
+	for _, key := range sortedRawKeys(entries) {
+		var reason string
+		if err := json.Unmarshal(entries[key], &reason); err == nil {
+			failures = append(failures, KeyFailure{Key: key, Reason: reason})
+			continue
+		}
+		var d detail
+		if err := json.Unmarshal(entries[key], &d); err == nil {
+			failures = append(failures, toFailure(key, d))
+			continue
+		}
+		return nil, string(raw)
+	}
+	return failures, ""
+}
+
+// sortedRawKeys returns the keys of m sorted by name
+func sortedRawKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// kindForStatus returns the error kind for an HTTP status
+func kindForStatus(status int) error {
+	switch {
+	case status == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case status == http.StatusForbidden:
+		return ErrForbiddenKey
+	case retryableStatus(status):
+		return ErrBrokerUnavailable
+	case status >= 400 && status < 500:
+		return ErrRequestRejected
+	default:
+		return ErrInvalidResponse
+	}
+}
+
+// kindError attaches an error kind to err without changing its message
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string   { return e.err.Error() }
+func (e *kindError) Unwrap() []error { return []error{e.kind, e.err} }
+
+// WrapKind returns err tagged with kind without changing its message, so
+// errors.Is matches both. Callers may use their own kinds as well as the
+// ones above.
+func WrapKind(kind, err error) error {
+	return &kindError{kind: kind, err: err}
+}
+
+// redact returns s with every secret replaced by a placeholder
+func redact(s string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, redactedPlaceholder)
+		}
+	}
+	return s
+}
+
+// redactedError is an error whose message had secrets scrubbed. errors.Is
+// and errors.As still see the original error.
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string { return e.msg }
+func (e *redactedError) Unwrap() error { return e.err }
+
+// redactError returns err with secrets scrubbed from its message, e.g. the
+// token in the URL of a failed keys request
+func redactError(err error, secrets []string) error {
+	if len(secrets) == 0 {
+		return err
+	}
+	return &redactedError{msg: redact(err.Error(), secrets), err: err}
+}
@@ -0,0 +1,191 @@
+package voidkey
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBrokerError(t *testing.T) {
+	err := newBrokerError(http.StatusBadRequest, []byte(`{"error":"invalid_token","message":"token expired"}`), nil)
+
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	assert.Equal(t, "invalid_token", err.Code)
+	assert.Equal(t, http.StatusBadRequest, err.StatusCode)
+
+	err = newBrokerError(http.StatusInternalServerError, []byte("Internal server error"), nil)
+
+	assert.ErrorIs(t, err, ErrBrokerUnavailable)
+	assert.Equal(t, "server returned error 500: Internal server error", err.Error())
+}
+
+func TestNewBrokerError_RedactsSecrets(t *testing.T) {
+	err := newBrokerError(http.StatusUnauthorized, []byte(`{"message":"invalid token leaky-token","details":{"A":"leaky-token denied"}}`), []string{"leaky-token"})
+
+	assert.NotContains(t, err.Error(), "leaky-token")
+	assert.Equal(t, "invalid token [REDACTED]", err.Message)
+}
+
+func TestBrokerError_Kinds(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      *BrokerError
+		expected error
+	}{
+		{name: "unauthorized", err: &BrokerError{StatusCode: http.StatusUnauthorized}, expected: ErrUnauthorized},
+		{name: "forbidden", err: &BrokerError{StatusCode: http.StatusForbidden}, expected: ErrForbiddenKey},
+		{name: "unavailable", err: &BrokerError{StatusCode: http.StatusBadGateway}, expected: ErrBrokerUnavailable},
+		{name: "rejected", err: &BrokerError{StatusCode: http.StatusNotFound}, expected: ErrRequestRejected},
+		{name: "code wins over status", err: &BrokerError{StatusCode: http.StatusBadRequest, Code: "access_denied"}, expected: ErrForbiddenKey},
+		{name: "unexpected status", err: &BrokerError{StatusCode: http.StatusFound}, expected: ErrInvalidResponse},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, tt.err, tt.expected)
+		})
+	}
+}
+
+func TestKeyError_Kind(t *testing.T) {
+	assert.ErrorIs(t, &KeyError{Code: "key_not_permitted", Message: "denied"}, ErrForbiddenKey)
+	assert.ErrorIs(t, &KeyError{Message: "provider failed"}, ErrRequestRejected)
+	assert.Nil(t, ErrorKind("no_such_code"))
+}
+
+func TestClient_TypedErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		expected error
+	}{
+		{name: "unauthorized", status: http.StatusUnauthorized, expected: ErrUnauthorized},
+		{name: "forbidden", status: http.StatusForbidden, expected: ErrForbiddenKey},
+		{name: "unavailable", status: http.StatusServiceUnavailable, expected: ErrBrokerUnavailable},
+		{name: "rejected", status: http.StatusUnprocessableEntity, expected: ErrRequestRejected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := &MockHTTPClient{}
+			client := newTestClient(t, mockClient)
+			mockClient.On("Do", requestMatching(http.MethodPost, testServerURL+"/credentials/mint")).Return(newResponse(tt.status, "nope"), nil)
+
+			_, err := client.MintKeys(context.Background(), MintRequest{Token: "test-token", Keys: []string{"A"}})
+
+			assert.ErrorIs(t, err, tt.expected)
+			var brokerErr *BrokerError
+			assert.ErrorAs(t, err, &brokerErr)
+			assert.Equal(t, tt.status, brokerErr.StatusCode)
+			mockClient.AssertExpectations(t)
+		})
+	}
+
+	t.Run("connection error", func(t *testing.T) {
+		mockClient := &MockHTTPClient{}
+		client := newTestClient(t, mockClient)
+		mockClient.On("Do", requestMatching(http.MethodGet, testServerURL+"/credentials/idp-providers")).
+			Return((*http.Response)(nil), errors.New("connection refused"))
+
+		_, err := client.ListIdpProviders(context.Background())
+
+		assert.ErrorIs(t, err, ErrBrokerUnavailable)
+		assert.Contains(t, err.Error(), "failed to connect to broker server")
+	})
+
+	t.Run("token in URL", func(t *testing.T) {
+		const token = "leaky-query-token"
+		mockClient := &MockHTTPClient{}
+		client := newTestClient(t, mockClient)
+		url := testServerURL + "/credentials/keys?token=" + token
+		mockClient.On("Do", requestMatching(http.MethodGet, url)).Return((*http.Response)(nil), errors.New(`Get "`+url+`": connection refused`))
+
+		_, err := client.ListKeys(context.Background(), token)
+
+		assert.ErrorIs(t, err, ErrBrokerUnavailable)
+		assert.NotContains(t, err.Error(), token)
+	})
+}
+
+func TestNewBrokerError_StructuredBody(t *testing.T) {
+	tests := []struct {
+		name             string
+		status           int
+		body             string
+		expectedMessage  string
+		expectedDetails  string
+		expectedFailures []KeyFailure
+	}{
+		{
+			name:            "plain text",
+			status:          http.StatusBadRequest,
+			body:            "bad request",
+			expectedMessage: "bad request",
+		},
+		{
+			name:            "message preferred over error",
+			status:          http.StatusBadRequest,
+			body:            `{"error":"bad_request","message":"duration too long"}`,
+			expectedMessage: "duration too long",
+		},
+		{
+			name:            "error only",
+			status:          http.StatusBadRequest,
+			body:            `{"error":"duration too long"}`,
+			expectedMessage: "duration too long",
+		},
+		{
+			name:            "string details",
+			status:          http.StatusBadRequest,
+			body:            `{"message":"invalid request","details":"duration must be at most 3600"}`,
+			expectedMessage: "invalid request",
+			expectedDetails: "duration must be at most 3600",
+		},
+		{
+			name:             "details map",
+			status:           http.StatusForbidden,
+			body:             `{"error":"forbidden","message":"keys denied","details":{"AWS_PROD":"not allowed for subject repo:x","AWS_ADMIN":{"code":"key_not_permitted","reason":"admin only"}}}`,
+			expectedMessage:  "keys denied",
+			expectedFailures: []KeyFailure{{Key: "AWS_ADMIN", Code: "key_not_permitted", Reason: "admin only"}, {Key: "AWS_PROD", Reason: "not allowed for subject repo:x"}},
+		},
+		{
+			name:             "nested keys map",
+			status:           http.StatusForbidden,
+			body:             `{"message":"keys denied","details":{"keys":{"AWS_PROD":"not allowed"}}}`,
+			expectedMessage:  "keys denied",
+			expectedFailures: []KeyFailure{{Key: "AWS_PROD", Reason: "not allowed"}},
+		},
+		{
+			name:             "details list",
+			status:           http.StatusNotFound,
+			body:             `{"message":"unknown keys","details":[{"key":"NOPE","message":"key not configured"}]}`,
+			expectedMessage:  "unknown keys",
+			expectedFailures: []KeyFailure{{Key: "NOPE", Reason: "key not configured"}},
+		},
+		{
+			name:            "unauthorized hint",
+			status:          http.StatusUnauthorized,
+			body:            `{"error":"token_expired","message":"token expired"}`,
+			expectedMessage: "token expired",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newBrokerError(tt.status, []byte(tt.body), nil)
+
+			assert.Equal(t, tt.expectedMessage, err.Message)
+			assert.Equal(t, tt.expectedDetails, err.Details)
+			assert.Equal(t, tt.expectedFailures, err.KeyFailures)
+		})
+	}
+}
+
+func TestBrokerError_ErrorListsKeyFailures(t *testing.T) {
+	err := newBrokerError(http.StatusForbidden, []byte(`{"error":"forbidden","message":"keys denied","details":{"AWS_PROD":"not allowed"}}`), nil)
+
+	assert.Equal(t, "server returned error 403: keys denied\n  - AWS_PROD: not allowed", err.Error())
+}
@@ -0,0 +1,282 @@
+package voidkey
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// DefaultRefreshBefore is how long before they expire a KeySource replaces
+// cached credentials, unless told otherwise with WithRefreshBefore
+const DefaultRefreshBefore = 5 * time.Minute
+
+// KeySource mints the credentials of a single key and caches them until
+// shortly before they expire, so callers can ask for credentials on every
+// use. It is safe for concurrent use and is the base of the adapters for
+// other SDKs in the subpackages.
+type KeySource struct {
+	client        *Client
+	key           string
+	idpName       string
+	duration      time.Duration
+	refreshBefore time.Duration
+	cacheFile     string
+
+	mu     sync.Mutex
+	cached *KeyCredentialResponse
+	// loaded is set once the cache file was read
+	loaded bool
+}
+
+// KeySourceOption configures a KeySource
+type KeySourceOption func(*KeySource)
+
+// WithIdpName sets the identity provider that issued the client's tokens,
+// for brokers with more than one
+func WithIdpName(name string) KeySourceOption {
+	return func(s *KeySource) {
+		s.idpName = name
+	}
+}
+
+// WithDuration asks the broker for credentials valid for d instead of the
+// key's default lifetime
+func WithDuration(d time.Duration) KeySourceOption {
+	return func(s *KeySource) {
+		s.duration = d
+	}
+}
+
+// WithRefreshBefore sets how long before they expire cached credentials are
+// replaced. It should be well below the lifetime of the credentials.
+func WithRefreshBefore(d time.Duration) KeySourceOption {
+	return func(s *KeySource) {
+		s.refreshBefore = d
+	}
+}
+
+// WithCacheFile keeps the credentials in the file at path as well, so
+// short-lived processes minting the same key share them until they are due
+// for a refresh. The file holds secrets: it is only read if no other user
+// can access it, and it is written with owner-only permissions. Callers must
+// use a path unique to the broker, token and key. Files in the same
+// directory holding expired credentials are deleted.
+func WithCacheFile(path string) KeySourceOption {
+	return func(s *KeySource) {
+		s.cacheFile = path
+	}
+}
+
+// DefaultCredentialCacheDir returns the per-user directory for
+// WithCacheFile, or an empty string if there is none
+func DefaultCredentialCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "voidkey", "credentials")
+}
+
+// NewKeySource returns a KeySource for the key with the given name. The
+// client needs a TokenSource to mint with.
+func NewKeySource(client *Client, key string, opts ...KeySourceOption) *KeySource {
+	s := &KeySource{
+		client:        client,
+		key:           key,
+		refreshBefore: DefaultRefreshBefore,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Key returns the name of the key
+func (s *KeySource) Key() string {
+	return s.key
+}
+
+// Credentials returns the key's credentials, minting new ones if there are
+// none cached or they expire within the refresh window. Should minting fail
+// while the cached credentials are still valid, those are returned instead.
+// Credentials without an expiry time are never cached.
+func (s *KeySource) Credentials(ctx context.Context) (KeyCredentialResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.loaded {
+		s.loaded = true
+		s.cached = s.readCacheFile()
+	}
+	if s.cached != nil && s.cached.FreshAt(s.client.Now(), s.refreshBefore) {
+		return *s.cached, nil
+	}
+
+	response, err := s.mint(ctx)
+	if err != nil {
+		if s.cached != nil && s.cached.FreshAt(s.client.Now(), 0) {
+			s.client.logger.Warn("failed to refresh credentials, using cached ones", "key", s.key, "expiresAt", s.cached.ExpiresAt, "error", err)
+			return *s.cached, nil
+		}
+		return KeyCredentialResponse{}, err
+	}
+
+	s.cached = &response
+	s.writeCacheFile(response)
+	return response, nil
+}
+
+// Expired reports whether Credentials would mint new credentials, because
+// there are none cached or they expire within the refresh window
+func (s *KeySource) Expired() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cached == nil || !s.cached.FreshAt(s.client.Now(), s.refreshBefore)
+}
+
+// LocalExpiry converts the expiry time of credentials to the local clock,
+// for SDKs that compare it against time.Now. ok is false if the broker did
+// not send a valid expiry time.
+func (s *KeySource) LocalExpiry(response KeyCredentialResponse) (expiry time.Time, ok bool) {
+	expiry, ok = response.Expiry()
+	if !ok {
+		return time.Time{}, false
+	}
+	skew, _ := s.client.ClockSkew()
+	return expiry.Add(-skew), true
+}
+
+// mint mints the key's credentials
+func (s *KeySource) mint(ctx context.Context) (KeyCredentialResponse, error) {
+	responses, err := s.client.MintKeys(ctx, MintRequest{
+		IdpName:  s.idpName,
+		Keys:     []string{s.key},
+		Duration: s.duration,
+	})
+	if err != nil {
+		return KeyCredentialResponse{}, err
+	}
+
+	response, ok := responses[s.key]
+	if !ok {
+		return KeyCredentialResponse{}, WrapKind(ErrInvalidResponse, fmt.Errorf("broker did not return key %s", s.key))
+	}
+	if response.Error != nil {
+		return KeyCredentialResponse{}, fmt.Errorf("failed to mint key %s: %w", s.key, response.Error)
+	}
+	return response, nil
+}
+
+// readCacheFile returns the credentials in the cache file, if there is one
+// that only the current user can access. A file with expired credentials is
+// deleted.
+func (s *KeySource) readCacheFile() *KeyCredentialResponse {
+	if s.cacheFile == "" {
+		return nil
+	}
+	info, err := os.Lstat(s.cacheFile)
+	if err != nil {
+		return nil
+	}
+	if !info.Mode().IsRegular() || (runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0) {
+		s.client.logger.Warn("ignoring credential cache accessible by other users", "path", s.cacheFile)
+		return nil
+	}
+
+	response, ok := readCachedCredentials(s.cacheFile)
+	if !ok {
+		s.client.logger.Debug("ignoring invalid credential cache", "path", s.cacheFile)
+		return nil
+	}
+	if !response.FreshAt(s.client.Now(), 0) {
+		s.removeCacheFile(s.cacheFile)
+		return nil
+	}
+	return &response
+}
+
+// readCachedCredentials reads a credential cache file, reporting whether it
+// holds credentials
+func readCachedCredentials(path string) (KeyCredentialResponse, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return KeyCredentialResponse{}, false
+	}
+	var response KeyCredentialResponse
+	if err := json.Unmarshal(data, &response); err != nil || response.Error != nil {
+		return KeyCredentialResponse{}, false
+	}
+	return response, true
+}
+
+// removeExpiredCacheFiles deletes the files next to the cache file that
+// hold expired credentials, left behind by tokens that are no longer used
+func (s *KeySource) removeExpiredCacheFiles() {
+	paths, err := filepath.Glob(filepath.Join(filepath.Dir(s.cacheFile), "*.json"))
+	if err != nil {
+		return
+	}
+	now := s.client.Now()
+	for _, path := range paths {
+		if path == s.cacheFile {
+			continue
+		}
+		if info, err := os.Lstat(path); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if response, ok := readCachedCredentials(path); ok && !response.FreshAt(now, 0) {
+			s.removeCacheFile(path)
+		}
+	}
+}
+
+// removeCacheFile deletes a credential cache file. Failures are only logged.
+func (s *KeySource) removeCacheFile(path string) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		s.client.logger.Debug("failed to delete expired credential cache", "path", path, "error", err)
+	}
+}
+
+// writeCacheFile atomically stores credentials with an expiry time in the
+// cache file. The cache is an optimisation, so failures are only logged.
+func (s *KeySource) writeCacheFile(response KeyCredentialResponse) {
+	if s.cacheFile == "" {
+		return
+	}
+	if _, ok := response.Expiry(); !ok {
+		return
+	}
+
+	data, _ := json.Marshal(response)
+	dir := filepath.Dir(s.cacheFile)
+	if err := os.MkdirAll(dir, cacheDirMode); err != nil {
+		s.client.logger.Debug("failed to create credential cache directory", "error", err)
+		return
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(s.cacheFile)+".tmp-*")
+	if err != nil {
+		s.client.logger.Debug("failed to write credential cache", "error", err)
+		return
+	}
+	// CreateTemp creates files with owner-only permissions
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.cacheFile)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		s.client.logger.Debug("failed to write credential cache", "error", err)
+		return
+	}
+	s.removeExpiredCacheFiles()
+}
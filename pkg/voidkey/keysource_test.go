@@ -0,0 +1,233 @@
+package voidkey
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mintResponse returns a mint response for AWS_CREDENTIALS with credentials
+// that expire after lifetime
+func mintResponse(accessKey string, lifetime time.Duration) *http.Response {
+	return newResponse(http.StatusOK, map[string]KeyCredentialResponse{
+		"AWS_CREDENTIALS": {
+			Credentials: map[string]string{"AWS_ACCESS_KEY_ID": accessKey},
+			ExpiresAt:   time.Now().Add(lifetime).UTC().Format(time.RFC3339),
+		},
+	})
+}
+
+func TestKeySource_CachesFreshCredentials(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client := newTestClient(t, mockClient, WithTokenSource(StaticToken("test-token")))
+	mockClient.On("Do", requestMatching(http.MethodPost, testServerURL+"/credentials/mint")).Return(mintResponse("AKIA1", time.Hour), nil).Once()
+
+	source := NewKeySource(client, "AWS_CREDENTIALS")
+	assert.True(t, source.Expired())
+
+	for i := 0; i < 3; i++ {
+		response, err := source.Credentials(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "AKIA1", response.Credentials["AWS_ACCESS_KEY_ID"])
+	}
+
+	assert.False(t, source.Expired())
+	mockClient.AssertNumberOfCalls(t, "Do", 1)
+}
+
+func TestKeySource_RefreshesEarly(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client := newTestClient(t, mockClient, WithTokenSource(StaticToken("test-token")))
+	mockClient.On("Do", requestMatching(http.MethodPost, testServerURL+"/credentials/mint")).Return(mintResponse("AKIA1", 2*time.Minute), nil).Once()
+	mockClient.On("Do", requestMatching(http.MethodPost, testServerURL+"/credentials/mint")).Return(mintResponse("AKIA2", time.Hour), nil).Once()
+
+	source := NewKeySource(client, "AWS_CREDENTIALS")
+
+	response, err := source.Credentials(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "AKIA1", response.Credentials["AWS_ACCESS_KEY_ID"])
+	assert.True(t, source.Expired())
+
+	response, err = source.Credentials(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "AKIA2", response.Credentials["AWS_ACCESS_KEY_ID"])
+	mockClient.AssertExpectations(t)
+}
+
+func TestKeySource_KeepsValidCredentialsWhenRefreshFails(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client := newTestClient(t, mockClient, WithTokenSource(StaticToken("test-token")))
+	mockClient.On("Do", requestMatching(http.MethodPost, testServerURL+"/credentials/mint")).Return(mintResponse("AKIA1", 2*time.Minute), nil).Once()
+	mockClient.On("Do", requestMatching(http.MethodPost, testServerURL+"/credentials/mint")).Return(newResponse(http.StatusServiceUnavailable, "down"), nil)
+
+	source := NewKeySource(client, "AWS_CREDENTIALS")
+	_, err := source.Credentials(context.Background())
+	require.NoError(t, err)
+
+	response, err := source.Credentials(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, "AKIA1", response.Credentials["AWS_ACCESS_KEY_ID"])
+
+	// Once the credentials are expired the error is returned
+	source = NewKeySource(client, "AWS_CREDENTIALS")
+	_, err = source.Credentials(context.Background())
+	assert.ErrorIs(t, err, ErrBrokerUnavailable)
+}
+
+func TestKeySource_CacheFile(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client := newTestClient(t, mockClient, WithTokenSource(StaticToken("test-token")))
+	mockClient.On("Do", requestMatching(http.MethodPost, testServerURL+"/credentials/mint")).Return(mintResponse("AKIA1", time.Hour), nil).Once()
+	mockClient.On("Do", requestMatching(http.MethodPost, testServerURL+"/credentials/mint")).Return(mintResponse("AKIA2", time.Hour), nil).Once()
+	cacheFile := filepath.Join(t.TempDir(), "credentials", "aws.json")
+
+	response, err := NewKeySource(client, "AWS_CREDENTIALS", WithCacheFile(cacheFile)).Credentials(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "AKIA1", response.Credentials["AWS_ACCESS_KEY_ID"])
+
+	info, err := os.Stat(cacheFile)
+	require.NoError(t, err)
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	// Another source, as in another process, uses the cached credentials
+	source := NewKeySource(client, "AWS_CREDENTIALS", WithCacheFile(cacheFile))
+	response, err = source.Credentials(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "AKIA1", response.Credentials["AWS_ACCESS_KEY_ID"])
+	mockClient.AssertNumberOfCalls(t, "Do", 1)
+
+	// A cache file other users can read is not trusted
+	if runtime.GOOS != "windows" {
+		require.NoError(t, os.Chmod(cacheFile, 0644))
+		response, err = NewKeySource(client, "AWS_CREDENTIALS", WithCacheFile(cacheFile)).Credentials(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "AKIA2", response.Credentials["AWS_ACCESS_KEY_ID"])
+	}
+}
+
+func TestKeySource_RemovesExpiredCacheFiles(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client := newTestClient(t, mockClient, WithTokenSource(StaticToken("test-token")))
+	mockClient.On("Do", requestMatching(http.MethodPost, testServerURL+"/credentials/mint")).Return(mintResponse("AKIA2", time.Hour), nil).Once()
+
+	dir := t.TempDir()
+	writeCache := func(name, accessKey string, expiresAt time.Time) string {
+		data, err := json.Marshal(KeyCredentialResponse{
+			Credentials: map[string]string{"AWS_ACCESS_KEY_ID": accessKey},
+			ExpiresAt:   expiresAt.UTC().Format(time.RFC3339),
+		})
+		require.NoError(t, err)
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, data, 0600))
+		return path
+	}
+	cacheFile := writeCache("aws.json", "AKIA1", time.Now().Add(-time.Minute))
+	otherExpired := writeCache("other.json", "AKIA3", time.Now().Add(-time.Hour))
+	otherFresh := writeCache("fresh.json", "AKIA4", time.Now().Add(time.Hour))
+
+	response, err := NewKeySource(client, "AWS_CREDENTIALS", WithCacheFile(cacheFile)).Credentials(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "AKIA2", response.Credentials["AWS_ACCESS_KEY_ID"])
+
+	cached, ok := readCachedCredentials(cacheFile)
+	require.True(t, ok)
+	assert.Equal(t, "AKIA2", cached.Credentials["AWS_ACCESS_KEY_ID"])
+	assert.NoFileExists(t, otherExpired)
+	assert.FileExists(t, otherFresh)
+}
+
+func TestKeySource_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		response *http.Response
+		expected error
+		message  string
+	}{
+		{
+			name:     "key error",
+			response: newResponse(http.StatusOK, `{"AWS_CREDENTIALS": {"error": {"code": "forbidden", "message": "not allowed"}}}`),
+			expected: ErrForbiddenKey,
+			message:  "failed to mint key AWS_CREDENTIALS: not allowed",
+		},
+		{
+			name:     "key missing",
+			response: newResponse(http.StatusOK, `{}`),
+			expected: ErrInvalidResponse,
+			message:  "broker did not return key AWS_CREDENTIALS",
+		},
+		{
+			name:     "unauthorized",
+			response: newResponse(http.StatusUnauthorized, "bad token"),
+			expected: ErrUnauthorized,
+			message:  "server returned error 401",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockClient := &MockHTTPClient{}
+			client := newTestClient(t, mockClient, WithTokenSource(StaticToken("test-token")))
+			mockClient.On("Do", requestMatching(http.MethodPost, testServerURL+"/credentials/mint")).Return(tt.response, nil)
+
+			_, err := NewKeySource(client, "AWS_CREDENTIALS").Credentials(context.Background())
+
+			assert.ErrorIs(t, err, tt.expected)
+			assert.Contains(t, err.Error(), tt.message)
+		})
+	}
+}
+
+func TestKeySource_NoToken(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client := newTestClient(t, mockClient)
+
+	_, err := NewKeySource(client, "AWS_CREDENTIALS").Credentials(context.Background())
+
+	assert.True(t, errors.Is(err, ErrNoToken))
+	mockClient.AssertNotCalled(t, "Do")
+}
+
+func TestKeySource_LocalExpiry(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client := newTestClient(t, mockClient, WithTokenSource(StaticToken("test-token")))
+	brokerNow := time.Now().Add(time.Hour)
+	response := newResponse(http.StatusOK, map[string]KeyCredentialResponse{
+		"AWS_CREDENTIALS": {ExpiresAt: brokerNow.Add(30 * time.Minute).UTC().Format(time.RFC3339)},
+	})
+	response.Header.Set("Date", brokerNow.UTC().Format(http.TimeFormat))
+	mockClient.On("Do", requestMatching(http.MethodPost, testServerURL+"/credentials/mint")).Return(response, nil)
+
+	source := NewKeySource(client, "AWS_CREDENTIALS")
+	credentials, err := source.Credentials(context.Background())
+	require.NoError(t, err)
+
+	expiry, ok := source.LocalExpiry(credentials)
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(30*time.Minute), expiry, 2*time.Second)
+
+	_, ok = source.LocalExpiry(KeyCredentialResponse{})
+	assert.False(t, ok)
+}
+
+func TestKeySource_Options(t *testing.T) {
+	client := newTestClient(t, &MockHTTPClient{})
+
+	source := NewKeySource(client, "AWS_CREDENTIALS", WithIdpName("github"), WithDuration(15*time.Minute), WithRefreshBefore(time.Minute))
+
+	assert.Equal(t, "AWS_CREDENTIALS", source.Key())
+	assert.Equal(t, "github", source.idpName)
+	assert.Equal(t, 15*time.Minute, source.duration)
+	assert.Equal(t, time.Minute, source.refreshBefore)
+}
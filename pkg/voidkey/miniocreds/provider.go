@@ -0,0 +1,83 @@
+// Package miniocreds provides MinIO Go client credentials backed by a
+// Voidkey key.
+//
+//	client, err := voidkey.New(
+//		voidkey.WithBaseURL("https://broker.example.com"),
+//		voidkey.WithTokenSource(voidkey.EnvToken()),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	minioClient, err := minio.New("minio.example.com", &minio.Options{
+//		Creds:  miniocreds.New(client, "MINIO_CREDENTIALS"),
+//		Secure: true,
+//	})
+package miniocreds
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+)
+
+// Credential fields the broker returns for MinIO keys
+const (
+	AccessKeyIDField     = "MINIO_ACCESS_KEY_ID"
+	SecretAccessKeyField = "MINIO_SECRET_ACCESS_KEY"
+	SessionTokenField    = "MINIO_SESSION_TOKEN"
+)
+
+// Provider is a credentials.Provider that mints the credentials of a
+// Voidkey key. Credentials are cached and refreshed before they expire by
+// a voidkey.KeySource.
+type Provider struct {
+	source *voidkey.KeySource
+}
+
+var _ credentials.Provider = (*Provider)(nil)
+
+// NewProvider returns a provider for the key with the given name, see
+// voidkey.NewKeySource for the options
+func NewProvider(client *voidkey.Client, key string, opts ...voidkey.KeySourceOption) *Provider {
+	return &Provider{source: voidkey.NewKeySource(client, key, opts...)}
+}
+
+// New returns credentials for the MinIO client from a Provider for the key
+// with the given name
+func New(client *voidkey.Client, key string, opts ...voidkey.KeySourceOption) *credentials.Credentials {
+	return credentials.New(NewProvider(client, key, opts...))
+}
+
+// Retrieve returns the key's credentials, minting new ones if the cached
+// ones are about to expire. The MinIO client gives it no context, so the
+// request is only bounded by the voidkey client's HTTP timeout.
+func (p *Provider) Retrieve() (credentials.Value, error) {
+	response, err := p.source.Credentials(context.Background())
+	if err != nil {
+		return credentials.Value{}, err
+	}
+
+	value := credentials.Value{
+		AccessKeyID:     response.Credentials[AccessKeyIDField],
+		SecretAccessKey: response.Credentials[SecretAccessKeyField],
+		SessionToken:    response.Credentials[SessionTokenField],
+		SignerType:      credentials.SignatureV4,
+	}
+	if value.AccessKeyID == "" || value.SecretAccessKey == "" {
+		return credentials.Value{}, fmt.Errorf("key %s has no %s and %s credentials: %w",
+			p.source.Key(), AccessKeyIDField, SecretAccessKeyField, voidkey.ErrInvalidResponse)
+	}
+
+	// The MinIO client compares the expiry time against the local clock
+	if expiry, ok := p.source.LocalExpiry(response); ok {
+		value.Expiration = expiry
+	}
+	return value, nil
+}
+
+// IsExpired reports whether the credentials are due to be refreshed
+func (p *Provider) IsExpired() bool {
+	return p.source.Expired()
+}
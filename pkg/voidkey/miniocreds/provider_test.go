@@ -0,0 +1,71 @@
+package miniocreds
+
+import (
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+	"github.com/voidkey-oss/cli/pkg/voidkey/voidkeytest"
+)
+
+// newBroker starts a broker with a MINIO_CREDENTIALS key
+func newBroker(t *testing.T) *voidkeytest.Broker {
+	broker := voidkeytest.NewBroker(t)
+	broker.SetKey("MINIO_CREDENTIALS", map[string]string{
+		AccessKeyIDField:     "minio",
+		SecretAccessKeyField: "secret",
+	})
+	return broker
+}
+
+func TestProvider_Retrieve(t *testing.T) {
+	broker := newBroker(t)
+	provider := NewProvider(broker.Client(t), "MINIO_CREDENTIALS")
+	assert.True(t, provider.IsExpired())
+
+	value, err := provider.Retrieve()
+
+	require.NoError(t, err)
+	assert.Equal(t, "minio-1", value.AccessKeyID)
+	assert.Equal(t, "secret-1", value.SecretAccessKey)
+	assert.Empty(t, value.SessionToken)
+	assert.Equal(t, credentials.SignatureV4, value.SignerType)
+	assert.WithinDuration(t, time.Now().Add(voidkeytest.DefaultLifetime), value.Expiration, 2*time.Second)
+	assert.False(t, provider.IsExpired())
+}
+
+func TestNew_CachesAndRefreshesEarly(t *testing.T) {
+	broker := newBroker(t)
+	creds := New(broker.Client(t), "MINIO_CREDENTIALS")
+
+	for i := 0; i < 3; i++ {
+		value, err := creds.Get()
+		require.NoError(t, err)
+		assert.Equal(t, "minio-1", value.AccessKeyID)
+	}
+	assert.Equal(t, 1, broker.Mints("MINIO_CREDENTIALS"))
+
+	// Credentials that expire within the refresh window are minted again
+	creds = New(broker.Client(t), "MINIO_CREDENTIALS", voidkey.WithDuration(time.Minute), voidkey.WithRefreshBefore(2*time.Minute))
+	for i := 0; i < 2; i++ {
+		_, err := creds.Get()
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 3, broker.Mints("MINIO_CREDENTIALS"))
+}
+
+func TestProvider_Errors(t *testing.T) {
+	broker := newBroker(t)
+	broker.SetKey("AWS_CREDENTIALS", map[string]string{"AWS_ACCESS_KEY_ID": "AKIA"})
+
+	_, err := NewProvider(broker.Client(t), "AWS_CREDENTIALS").Retrieve()
+	assert.ErrorIs(t, err, voidkey.ErrInvalidResponse)
+	assert.Contains(t, err.Error(), "key AWS_CREDENTIALS has no MINIO_ACCESS_KEY_ID and MINIO_SECRET_ACCESS_KEY credentials")
+
+	broker.SetUnavailable(true)
+	_, err = NewProvider(broker.Client(t), "MINIO_CREDENTIALS").Retrieve()
+	assert.ErrorIs(t, err, voidkey.ErrBrokerUnavailable)
+}
@@ -0,0 +1,66 @@
+package voidkey
+
+import "log/slog"
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithBaseURL sets the broker URL, e.g. https://broker.example.com or
+// unix:///run/voidkey.sock. It is required.
+func WithBaseURL(serverURL string) Option {
+	return func(c *Client) {
+		c.serverURL = serverURL
+	}
+}
+
+// WithHTTPClient sets the HTTP client requests are sent with. It replaces
+// the default client, including its timeout and redirect policy; use
+// CheckRedirect to keep the latter.
+func WithHTTPClient(client HTTPClient) Option {
+	return func(c *Client) {
+		c.httpClient = client
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent to the broker
+func WithUserAgent(userAgent string) Option {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithTokenSource sets where the OIDC token comes from for calls that are
+// not given one
+func WithTokenSource(source TokenSource) Option {
+	return func(c *Client) {
+		c.tokenSource = source
+	}
+}
+
+// WithRetryPolicy sets how failed requests are retried. Clients use
+// DefaultRetryPolicy unless told otherwise; NoRetry disables retries.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
+// WithLogger sets the logger for request diagnostics, which are logged at
+// debug level. Clients log nothing by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// WithDiscovery makes the client learn the broker's endpoints and features
+// from /.well-known/voidkey before its first request. Results are cached in
+// memory and, if cacheDir is not empty, on disk for other processes; see
+// DefaultDiscoveryCacheDir. Without discovery the client uses the endpoints
+// of brokers that predate it.
+func WithDiscovery(cacheDir string) Option {
+	return func(c *Client) {
+		c.discover = true
+		c.discoveryCacheDir = cacheDir
+	}
+}
@@ -0,0 +1,140 @@
+package voidkey
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed broker requests are retried. Connection
+// errors, 429 and 5xx responses are retried with capped exponential backoff
+// and full jitter, honouring Retry-After, until either MaxAttempts or
+// MaxElapsed is reached.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one
+	MaxAttempts int
+	// BaseDelay is the backoff before the first retry, doubled for each
+	// further retry
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff between two attempts
+	MaxDelay time.Duration
+	// MaxElapsed is the total time budget for all attempts and delays. An
+	// attempt still running when it is used up is cancelled.
+	MaxElapsed time.Duration
+}
+
+// DefaultRetryPolicy makes up to three retries over at most a minute
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   250 * time.Millisecond,
+	MaxDelay:    5 * time.Second,
+	MaxElapsed:  time.Minute,
+}
+
+// NoRetry sends every request exactly once
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// WithRetries returns the policy with MaxAttempts set for the given number
+// of retries after the first attempt
+func (p RetryPolicy) WithRetries(retries int) RetryPolicy {
+	if retries < 0 {
+		retries = 0
+	}
+	p.MaxAttempts = retries + 1
+	return p
+}
+
+// backoff returns the jittered delay before retry number attempt (0-based)
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	if p.BaseDelay <= 0 {
+		return 0
+	}
+
+	ceiling := p.BaseDelay
+	for i := 0; i < attempt && (p.MaxDelay <= 0 || ceiling < p.MaxDelay); i++ {
+		ceiling *= 2
+	}
+	if p.MaxDelay > 0 && ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+
+	// Full jitter spreads out clients that failed at the same moment
+	return time.Duration(rand.Int64N(int64(ceiling) + 1))
+}
+
+// delay returns how long to wait before retry number attempt, never less
+// than what the broker asked for in Retry-After
+func (p RetryPolicy) delay(attempt int, retryAfter time.Duration) time.Duration {
+	d := p.backoff(attempt)
+	if retryAfter > d {
+		d = retryAfter
+	}
+	return d
+}
+
+// retryableError marks a failed attempt that may be retried
+type retryableError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// retryableStatus reports whether a response status is worth retrying
+func retryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusNotImplemented, http.StatusHTTPVersionNotSupported:
+		return false
+	}
+	return status >= 500 && status <= 599
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as
+// an HTTP date. It returns zero if the header is missing or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// newIdempotencyKey returns a random key identifying one logical mint
+// request across retries, so the broker can deduplicate them
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	if _, err := cryptorand.Read(b); err != nil {
+		// crypto/rand never fails on supported platforms; fall back to a
+		// time-based key rather than sending none
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,244 @@
+package voidkey
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// newRetryingTestClient returns a client that retries without sleeping and
+// records the delays it would have waited
+func newRetryingTestClient(t *testing.T, mockClient *MockHTTPClient, retries int) (*Client, *[]time.Duration) {
+	client := newTestClient(t, mockClient, WithRetryPolicy(DefaultRetryPolicy.WithRetries(retries)))
+
+	var delays []time.Duration
+	client.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return ctx.Err()
+	}
+	return client, &delays
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	for attempt := 0; attempt < 10; attempt++ {
+		ceiling := 100 * time.Millisecond << attempt
+		if ceiling > time.Second {
+			ceiling = time.Second
+		}
+		for i := 0; i < 20; i++ {
+			d := policy.backoff(attempt)
+			assert.GreaterOrEqual(t, d, time.Duration(0))
+			assert.LessOrEqual(t, d, ceiling)
+		}
+	}
+
+	assert.Equal(t, time.Duration(0), RetryPolicy{}.backoff(3))
+}
+
+func TestRetryPolicy_DelayHonoursRetryAfter(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+
+	assert.Equal(t, 3*time.Second, policy.delay(0, 3*time.Second))
+	assert.LessOrEqual(t, policy.delay(0, 0), 2*time.Millisecond)
+}
+
+func TestRetryPolicy_WithRetries(t *testing.T) {
+	assert.Equal(t, 4, DefaultRetryPolicy.WithRetries(3).MaxAttempts)
+	assert.Equal(t, 1, DefaultRetryPolicy.WithRetries(0).MaxAttempts)
+	assert.Equal(t, 1, DefaultRetryPolicy.WithRetries(-2).MaxAttempts)
+	assert.Equal(t, DefaultRetryPolicy.BaseDelay, DefaultRetryPolicy.WithRetries(1).BaseDelay)
+}
+
+func TestRetryableStatus(t *testing.T) {
+	tests := map[int]bool{
+		http.StatusOK:                  false,
+		http.StatusBadRequest:          false,
+		http.StatusUnauthorized:        false,
+		http.StatusForbidden:           false,
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: true,
+		http.StatusNotImplemented:      false,
+		http.StatusBadGateway:          true,
+		http.StatusServiceUnavailable:  true,
+		http.StatusGatewayTimeout:      true,
+	}
+
+	for status, expected := range tests {
+		assert.Equal(t, expected, retryableStatus(status), "status %d", status)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, 5*time.Second, parseRetryAfter("5", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(now.Add(-time.Minute).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("-1", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+}
+
+func TestClient_RetriesServerErrors(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client, delays := newRetryingTestClient(t, mockClient, 3)
+
+	url := "http://localhost:3000/credentials/idp-providers"
+	mockClient.On("Do", requestMatching(http.MethodGet, url)).Return(newResponse(http.StatusBadGateway, "bad gateway"), nil).Once()
+	mockClient.On("Do", requestMatching(http.MethodGet, url)).Return((*http.Response)(nil), errors.New("connection reset by peer")).Once()
+	mockClient.On("Do", requestMatching(http.MethodGet, url)).Return(newResponse(http.StatusOK, testIdpProviders()), nil).Once()
+
+	providers, err := client.ListIdpProviders(context.Background())
+
+	assert.NoError(t, err)
+	assert.Len(t, providers, 4)
+	assert.Len(t, *delays, 2)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestClient_RetriesExhausted(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client, delays := newRetryingTestClient(t, mockClient, 2)
+
+	url := "http://localhost:3000/credentials/idp-providers"
+	mockClient.On("Do", requestMatching(http.MethodGet, url)).Return(newResponse(http.StatusServiceUnavailable, "down"), nil).Times(3)
+
+	_, err := client.ListIdpProviders(context.Background())
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "server returned error 503")
+	assert.Len(t, *delays, 2)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestClient_DoesNotRetryClientErrors(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client, delays := newRetryingTestClient(t, mockClient, 3)
+
+	url := "http://localhost:3000/credentials/idp-providers"
+	mockClient.On("Do", requestMatching(http.MethodGet, url)).Return(newResponse(http.StatusBadRequest, "bad request"), nil).Once()
+
+	_, err := client.ListIdpProviders(context.Background())
+
+	assert.Error(t, err)
+	assert.Empty(t, *delays)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestClient_HonoursRetryAfter(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client, delays := newRetryingTestClient(t, mockClient, 1)
+
+	throttled := newResponse(http.StatusTooManyRequests, "slow down")
+	throttled.Header = http.Header{"Retry-After": []string{"7"}}
+
+	url := "http://localhost:3000/credentials/idp-providers"
+	mockClient.On("Do", requestMatching(http.MethodGet, url)).Return(throttled, nil).Once()
+	mockClient.On("Do", requestMatching(http.MethodGet, url)).Return(newResponse(http.StatusOK, testIdpProviders()), nil).Once()
+
+	_, err := client.ListIdpProviders(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{7 * time.Second}, *delays)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestClient_RetryDeadline(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client, delays := newRetryingTestClient(t, mockClient, 5)
+	client.retry.MaxElapsed = time.Second
+
+	// The broker asks for more time than the whole retry budget
+	throttled := newResponse(http.StatusTooManyRequests, "slow down")
+	throttled.Header = http.Header{"Retry-After": []string{"60"}}
+
+	url := "http://localhost:3000/credentials/idp-providers"
+	mockClient.On("Do", requestMatching(http.MethodGet, url)).Return(throttled, nil).Once()
+
+	_, err := client.ListIdpProviders(context.Background())
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "server returned error 429")
+	assert.Empty(t, *delays)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestClient_RetryDeadlineCancelsAttempt(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client, _ := newRetryingTestClient(t, mockClient, 5)
+	client.retry.MaxElapsed = time.Second
+
+	// The retry hangs until the budget is used up
+	url := "http://localhost:3000/credentials/idp-providers"
+	mockClient.On("Do", requestMatching(http.MethodGet, url)).Return(newResponse(http.StatusBadGateway, ""), nil).Once()
+	mockClient.On("Do", requestMatching(http.MethodGet, url)).Run(func(args mock.Arguments) {
+		<-args.Get(0).(*http.Request).Context().Done()
+	}).Return((*http.Response)(nil), context.DeadlineExceeded).Once()
+
+	start := time.Now()
+	_, err := client.ListIdpProviders(context.Background())
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	mockClient.AssertExpectations(t)
+}
+
+func TestClient_MintRetriesWithSameIdempotencyKey(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client, _ := newRetryingTestClient(t, mockClient, 3)
+
+	var keys []string
+	recordKey := func(args mock.Arguments) {
+		keys = append(keys, args.Get(0).(*http.Request).Header.Get("Idempotency-Key"))
+	}
+
+	url := "http://localhost:3000/credentials/mint"
+	mockClient.On("Do", requestMatching(http.MethodPost, url)).Run(recordKey).Return(newResponse(http.StatusBadGateway, ""), nil).Once()
+	mockClient.On("Do", requestMatching(http.MethodPost, url)).Run(recordKey).Return(newResponse(http.StatusOK, testKeyCredentials()), nil).Once()
+
+	_, err := client.MintKeys(context.Background(), MintRequest{Token: "test-token", Keys: []string{"MINIO_CREDENTIALS"}})
+	assert.NoError(t, err)
+
+	assert.Len(t, keys, 2)
+	assert.NotEmpty(t, keys[0])
+	assert.Equal(t, keys[0], keys[1])
+
+	// A new mint call gets a new key
+	mockClient.On("Do", requestMatching(http.MethodPost, url)).Run(recordKey).Return(newResponse(http.StatusOK, testKeyCredentials()), nil).Once()
+	_, err = client.MintKeys(context.Background(), MintRequest{Token: "test-token", Keys: []string{"MINIO_CREDENTIALS"}})
+	assert.NoError(t, err)
+	assert.NotEqual(t, keys[0], keys[2])
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestClient_RetryCancelled(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client := newTestClient(t, mockClient, WithRetryPolicy(DefaultRetryPolicy))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client.sleep = func(context.Context, time.Duration) error {
+		cancel()
+		return ctx.Err()
+	}
+
+	url := "http://localhost:3000/credentials/idp-providers"
+	mockClient.On("Do", requestMatching(http.MethodGet, url)).Return(newResponse(http.StatusBadGateway, ""), nil).Once()
+
+	_, err := client.ListIdpProviders(ctx)
+
+	assert.ErrorIs(t, err, context.Canceled)
+	mockClient.AssertExpectations(t)
+}
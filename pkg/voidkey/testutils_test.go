@@ -0,0 +1,83 @@
+package voidkey
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// testServerURL is the broker URL of clients created with newTestClient
+const testServerURL = "http://localhost:3000"
+
+// MockHTTPClient is a mock implementation of HTTPClient for testing
+type MockHTTPClient struct {
+	mock.Mock
+}
+
+func (m *MockHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	args := m.Called(req)
+	return args.Get(0).(*http.Response), args.Error(1)
+}
+
+// newTestClient returns a client for testServerURL that sends requests with
+// httpClient and does not retry unless opts say otherwise
+func newTestClient(t *testing.T, httpClient HTTPClient, opts ...Option) *Client {
+	t.Helper()
+	opts = append([]Option{WithBaseURL(testServerURL), WithHTTPClient(httpClient), WithRetryPolicy(NoRetry)}, opts...)
+	client, err := New(opts...)
+	require.NoError(t, err)
+	return client
+}
+
+// newResponse creates an HTTP response with body, which is sent as is if
+// it is a string and marshalled as JSON otherwise
+func newResponse(statusCode int, body any) *http.Response {
+	var data []byte
+	switch v := body.(type) {
+	case nil:
+	case string:
+		data = []byte(v)
+	default:
+		data, _ = json.Marshal(v)
+	}
+	return &http.Response{
+		StatusCode: statusCode,
+		Header:     http.Header{},
+		Body:       io.NopCloser(bytes.NewReader(data)),
+	}
+}
+
+// requestMatching matches requests with the given method and URL
+func requestMatching(method, url string) interface{} {
+	return mock.MatchedBy(func(req *http.Request) bool {
+		return req.Method == method && req.URL.String() == url
+	})
+}
+
+// testKeyCredentials returns sample key credentials
+func testKeyCredentials() map[string]KeyCredentialResponse {
+	return map[string]KeyCredentialResponse{
+		"MINIO_CREDENTIALS": {
+			Credentials: map[string]string{
+				"MINIO_ACCESS_KEY_ID":     "AKIATEST123456789",
+				"MINIO_SECRET_ACCESS_KEY": "wJalrXUtnFEMI/K7MDENG/bPxRfiCYEXAMPLEKEY",
+			},
+			ExpiresAt: "2024-12-31T23:59:59Z",
+		},
+	}
+}
+
+// testIdpProviders returns sample IdP providers
+func testIdpProviders() []IdpProvider {
+	return []IdpProvider{
+		{Name: "auth0", IsDefault: true},
+		{Name: "github", IsDefault: false},
+		{Name: "okta", IsDefault: false},
+		{Name: "hello-world", IsDefault: false},
+	}
+}
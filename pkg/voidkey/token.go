@@ -0,0 +1,65 @@
+package voidkey
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// TokenSource supplies the OIDC token the client authenticates with. It is
+// asked for a token on every call that is not given one, so it may return a
+// fresh token each time.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// TokenSourceFunc adapts a function to a TokenSource
+type TokenSourceFunc func(ctx context.Context) (string, error)
+
+// Token calls f
+func (f TokenSourceFunc) Token(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// StaticToken returns a TokenSource that always returns token
+func StaticToken(token string) TokenSource {
+	return TokenSourceFunc(func(context.Context) (string, error) {
+		return token, nil
+	})
+}
+
+// EnvToken returns a TokenSource that reads the token from the first of
+// the environment variables that is set, by default OIDC_TOKEN and then
+// GITHUB_TOKEN
+func EnvToken(names ...string) TokenSource {
+	if len(names) == 0 {
+		names = []string{"OIDC_TOKEN", "GITHUB_TOKEN"}
+	}
+	return TokenSourceFunc(func(context.Context) (string, error) {
+		for _, name := range names {
+			if token := os.Getenv(name); token != "" {
+				return token, nil
+			}
+		}
+		return "", fmt.Errorf("%w: none of %v is set", ErrNoToken, names)
+	})
+}
+
+// token returns token, or one from the client's TokenSource if it is empty
+func (c *Client) token(ctx context.Context, token string) (string, error) {
+	if token != "" {
+		return token, nil
+	}
+	if c.tokenSource == nil {
+		return "", fmt.Errorf("%w: pass a token or create the client WithTokenSource", ErrNoToken)
+	}
+
+	token, err := c.tokenSource.Token(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get OIDC token: %w", err)
+	}
+	if token == "" {
+		return "", fmt.Errorf("%w: the token source returned an empty token", ErrNoToken)
+	}
+	return token, nil
+}
@@ -0,0 +1,63 @@
+package voidkey
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvToken(t *testing.T) {
+	t.Setenv("OIDC_TOKEN", "")
+	t.Setenv("GITHUB_TOKEN", "github-token")
+
+	token, err := EnvToken().Token(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "github-token", token)
+
+	t.Setenv("OIDC_TOKEN", "oidc-token")
+	token, err = EnvToken().Token(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "oidc-token", token)
+
+	_, err = EnvToken("VOIDKEY_TEST_UNSET_TOKEN").Token(context.Background())
+	assert.ErrorIs(t, err, ErrNoToken)
+}
+
+func TestClient_TokenSource(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+	client := newTestClient(t, mockClient, WithTokenSource(StaticToken("source-token")))
+	mockClient.On("Do", requestMatching(http.MethodGet, testServerURL+"/credentials/keys?token=source-token")).Return(newResponse(http.StatusOK, []string{"A"}), nil).Once()
+	mockClient.On("Do", requestMatching(http.MethodGet, testServerURL+"/credentials/keys?token=explicit-token")).Return(newResponse(http.StatusOK, []string{"B"}), nil).Once()
+
+	keys, err := client.ListKeys(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"A"}, keys)
+
+	// A token given to the call wins over the source
+	keys, err = client.ListKeys(context.Background(), "explicit-token")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"B"}, keys)
+
+	mockClient.AssertExpectations(t)
+}
+
+func TestClient_NoToken(t *testing.T) {
+	mockClient := &MockHTTPClient{}
+
+	_, err := newTestClient(t, mockClient).MintKeys(context.Background(), MintRequest{Keys: []string{"A"}})
+	assert.ErrorIs(t, err, ErrNoToken)
+
+	failing := TokenSourceFunc(func(context.Context) (string, error) {
+		return "", errors.New("metadata server unreachable")
+	})
+	_, err = newTestClient(t, mockClient, WithTokenSource(failing)).MintKeys(context.Background(), MintRequest{Keys: []string{"A"}})
+	assert.ErrorContains(t, err, "failed to get OIDC token: metadata server unreachable")
+
+	_, err = newTestClient(t, mockClient, WithTokenSource(StaticToken(""))).ListKeys(context.Background(), "")
+	assert.ErrorIs(t, err, ErrNoToken)
+
+	mockClient.AssertNotCalled(t, "Do")
+}
@@ -0,0 +1,61 @@
+package voidkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxRedirects is the number of redirects followed for a single request,
+// the same limit net/http applies by default
+const maxRedirects = 10
+
+// unixSocketBase is the base URL of requests to a broker on a unix socket.
+// Its host is never resolved, the transport dials the socket instead.
+const unixSocketBase = "http://unix"
+
+// ErrRedirectRefused is returned for redirects that would leave the broker
+// or downgrade the connection; requests failing with it are not retried
+var ErrRedirectRefused = errors.New("refusing to follow redirect")
+
+// CheckRedirect is the redirect policy for broker requests, for use as
+// http.Client.CheckRedirect. Redirects are only followed on the same host
+// and never from HTTPS to HTTP, so a compromised or misconfigured proxy
+// cannot send the token elsewhere.
+func CheckRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+
+	original := via[0].URL
+	if req.URL.Host != original.Host {
+		return fmt.Errorf("%w from %s to another host %s", ErrRedirectRefused, original.Host, req.URL.Host)
+	}
+	if original.Scheme == "https" && req.URL.Scheme != "https" {
+		return fmt.Errorf("%w from HTTPS to %s", ErrRedirectRefused, strings.ToUpper(req.URL.Scheme))
+	}
+	return nil
+}
+
+// DialUnixSocket makes transport send every request to the unix socket at
+// path, for brokers addressed as unix:///path/to.sock
+func DialUnixSocket(transport *http.Transport, path string) {
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "unix", path)
+	}
+}
+
+// defaultTransport returns the transport of the default HTTP client
+func defaultTransport(base *url.URL) http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if base.Scheme == "unix" {
+		DialUnixSocket(transport, base.Path)
+	}
+	return transport
+}
@@ -0,0 +1,107 @@
+package voidkey
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckRedirect(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[]`))
+	})
+	other := httptest.NewServer(ok)
+	defer other.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/same-host", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/ok", http.StatusFound)
+	})
+	mux.HandleFunc("/other-host", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, other.URL+"/ok", http.StatusFound)
+	})
+	mux.HandleFunc("/downgrade", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://"+r.Host+"/ok", http.StatusFound)
+	})
+	mux.Handle("/ok", ok)
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+
+	client := server.Client()
+	client.CheckRedirect = CheckRedirect
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{path: "/same-host"},
+		{path: "/other-host", expected: "to another host"},
+		{path: "/downgrade", expected: "from HTTPS to HTTP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := client.Get(server.URL + tt.path)
+
+			if tt.expected == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+				_ = resp.Body.Close()
+				return
+			}
+			assert.ErrorIs(t, err, ErrRedirectRefused)
+			assert.Contains(t, err.Error(), tt.expected)
+		})
+	}
+}
+
+func TestClient_RedirectRefusedNotRetried(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Redirect(w, r, "http://broker.evil.example/credentials/idp-providers", http.StatusTemporaryRedirect)
+	}))
+	defer server.Close()
+
+	// The default HTTP client refuses the redirect
+	client, err := New(WithBaseURL(server.URL), WithRetryPolicy(RetryPolicy{MaxAttempts: 3}))
+	require.NoError(t, err)
+
+	_, err = client.ListIdpProviders(context.Background())
+
+	assert.ErrorIs(t, err, ErrRedirectRefused)
+	assert.Equal(t, 1, requests)
+}
+
+func TestClient_UnixSocket(t *testing.T) {
+	// Unix socket paths are limited to ~100 bytes, too short for t.TempDir on some systems
+	dir, err := os.MkdirTemp("", "voidkey")
+	require.NoError(t, err)
+	defer func() { _ = os.RemoveAll(dir) }()
+	socket := filepath.Join(dir, "broker.sock")
+
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/credentials/idp-providers", r.URL.Path)
+		_, _ = w.Write([]byte(`[{"name":"github","isDefault":true}]`))
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	// The default HTTP client dials the socket
+	client, err := New(WithBaseURL("unix://" + socket))
+	require.NoError(t, err)
+	providers, err := client.ListIdpProviders(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, []IdpProvider{{Name: "github", IsDefault: true}}, providers)
+}
@@ -0,0 +1,190 @@
+// Package voidkeytest provides a fake Voidkey broker for testing code that
+// uses package voidkey.
+package voidkeytest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/voidkey-oss/cli/pkg/voidkey"
+)
+
+// Token is the only OIDC token the broker accepts
+const Token = "voidkeytest-token"
+
+// DefaultLifetime is how long minted credentials are valid unless the
+// request asks for another duration
+const DefaultLifetime = time.Hour
+
+// Broker is a fake broker on an httptest server. It mints the keys set with
+// SetKey, appending the number of the mint to every credential value (e.g.
+// AKIA becomes AKIA-1, then AKIA-2) so tests can tell refreshes apart.
+type Broker struct {
+	Server *httptest.Server
+
+	mu          sync.Mutex
+	keys        map[string]map[string]string
+	mints       map[string]int
+	unavailable bool
+	skew        time.Duration
+}
+
+// NewBroker starts a broker that is closed when the test ends
+func NewBroker(t testing.TB) *Broker {
+	t.Helper()
+	b := &Broker{
+		keys:  make(map[string]map[string]string),
+		mints: make(map[string]int),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/credentials/mint", b.handleMint)
+	mux.HandleFunc("/credentials/keys", b.handleKeys)
+	b.Server = httptest.NewServer(b.withDate(mux))
+	t.Cleanup(b.Server.Close)
+	return b
+}
+
+// URL returns the broker's base URL
+func (b *Broker) URL() string {
+	return b.Server.URL
+}
+
+// Client returns a client for the broker that authenticates with Token and
+// does not retry, plus any further opts
+func (b *Broker) Client(t testing.TB, opts ...voidkey.Option) *voidkey.Client {
+	t.Helper()
+	opts = append([]voidkey.Option{
+		voidkey.WithBaseURL(b.URL()),
+		voidkey.WithHTTPClient(b.Server.Client()),
+		voidkey.WithTokenSource(voidkey.StaticToken(Token)),
+		voidkey.WithRetryPolicy(voidkey.NoRetry),
+	}, opts...)
+	client, err := voidkey.New(opts...)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return client
+}
+
+// SetKey makes the broker mint the key with the given credentials
+func (b *Broker) SetKey(name string, credentials map[string]string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.keys[name] = credentials
+}
+
+// Mints returns how often the key was minted
+func (b *Broker) Mints(name string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.mints[name]
+}
+
+// SetUnavailable makes the broker answer every request with 503 until it is
+// called again with false
+func (b *Broker) SetUnavailable(unavailable bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.unavailable = unavailable
+}
+
+// SetClockSkew sets how far the broker's clock is ahead of the local one.
+// It shifts the Date header and the expiry times of minted credentials.
+func (b *Broker) SetClockSkew(skew time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.skew = skew
+}
+
+// now returns the time by the broker's clock
+func (b *Broker) now() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Now().Add(b.skew)
+}
+
+// withDate sets the Date header from the broker's clock, and fails
+// requests while the broker is unavailable
+func (b *Broker) withDate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", b.now().UTC().Format(http.TimeFormat))
+
+		b.mu.Lock()
+		unavailable := b.unavailable
+		b.mu.Unlock()
+		if unavailable {
+			http.Error(w, "broker unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleMint mints the requested keys
+func (b *Broker) handleMint(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		OidcToken string   `json:"oidcToken"`
+		Keys      []string `json:"keys"`
+		Duration  int      `json:"duration"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error":"bad_request","message":"invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+	if request.OidcToken != Token {
+		http.Error(w, `{"error":"invalid_token","message":"token rejected"}`, http.StatusUnauthorized)
+		return
+	}
+
+	lifetime := DefaultLifetime
+	if request.Duration > 0 {
+		lifetime = time.Duration(request.Duration) * time.Second
+	}
+	expiresAt := b.now().Add(lifetime).UTC().Format(time.RFC3339)
+
+	b.mu.Lock()
+	responses := make(map[string]voidkey.KeyCredentialResponse, len(request.Keys))
+	for _, key := range request.Keys {
+		credentials, ok := b.keys[key]
+		if !ok {
+			responses[key] = voidkey.KeyCredentialResponse{Error: &voidkey.KeyError{Code: "key_not_found", Message: "key not configured"}}
+			continue
+		}
+		b.mints[key]++
+		minted := make(map[string]string, len(credentials))
+		for name, value := range credentials {
+			minted[name] = fmt.Sprintf("%s-%d", value, b.mints[key])
+		}
+		responses[key] = voidkey.KeyCredentialResponse{Credentials: minted, ExpiresAt: expiresAt}
+	}
+	b.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(responses)
+}
+
+// handleKeys lists the keys set with SetKey
+func (b *Broker) handleKeys(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("token") != Token {
+		http.Error(w, `{"error":"invalid_token","message":"token rejected"}`, http.StatusUnauthorized)
+		return
+	}
+
+	b.mu.Lock()
+	keys := make([]string, 0, len(b.keys))
+	for key := range b.keys {
+		keys = append(keys, key)
+	}
+	b.mu.Unlock()
+	sort.Strings(keys)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(keys)
+}